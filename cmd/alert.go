@@ -0,0 +1,55 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+
+	"time"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/flowlogs"
+	"github.com/spf13/cobra"
+)
+
+var alertOptions flowlogs.AlertOptions
+
+// alertCmd represents the alert command
+var alertCmd = &cobra.Command{
+	Use:   "alert",
+	Short: "Evaluates alert rules against the indexed flow logs.",
+}
+
+// alertRunCmd represents the alert run command
+var alertRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Evaluates the alert rules periodically, writing the alerts to the alerts index and to the notifiers of each rule.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if code := flowlogs.RunAlerts(alertOptions, trace); code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(alertCmd)
+	alertCmd.AddCommand(alertRunCmd)
+
+	alertRunCmd.Flags().StringVar(&alertOptions.RulesFile, "rules", "config/alerts.json", "file holding the alert rules")
+	alertRunCmd.Flags().DurationVar(&alertOptions.Interval, "interval", 5*time.Minute, "time between two evaluations of the rules")
+	alertRunCmd.Flags().BoolVar(&alertOptions.Once, "once", false, "When set the rules are evaluated once and the command exits")
+
+	alertRunCmd.Flags().BoolVar(&trace, "trace", false, "When set will add elasticsearch request and response body to the output")
+}
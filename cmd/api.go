@@ -0,0 +1,44 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/flowlogs"
+	"github.com/spf13/cobra"
+)
+
+var apiOptions flowlogs.APIOptions
+
+// apiCmd represents the api command
+var apiCmd = &cobra.Command{
+	Use:   "api",
+	Short: "Serves the saved queries and the flow logs search over http, authenticated with the tokens in api.tokens.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if code := flowlogs.API(apiOptions, trace); code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(apiCmd)
+
+	apiCmd.Flags().StringVar(&apiOptions.Listen, "listen", ":8081", "address the server listens on")
+
+	apiCmd.Flags().BoolVar(&trace, "trace", false, "When set will add elasticsearch request and response body to the output")
+}
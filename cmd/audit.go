@@ -0,0 +1,57 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+
+	"time"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/flowlogs"
+	"github.com/spf13/cobra"
+)
+
+var auditOptions flowlogs.AuditOptions
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Audits the completeness of the indexed flow logs.",
+}
+
+// auditGapsCmd represents the audit gaps command
+var auditGapsCmd = &cobra.Command{
+	Use:   "gaps",
+	Short: "Reports the gaps, overlaps and duplicates between the capture windows of each interface and the objects not fully indexed.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if code := flowlogs.AuditGaps(auditOptions, trace); code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditGapsCmd)
+
+	auditGapsCmd.Flags().StringVar(&auditOptions.Filter, "filter", "", "filter selecting the flow logs, i.e. \"vpc_crn=crn:v1:...\"")
+	auditGapsCmd.Flags().StringVar(&auditOptions.From, "from", "now-7d", "start of the time window on capture_start_time, i.e. now-7d or 2020-12-01")
+	auditGapsCmd.Flags().StringVar(&auditOptions.To, "to", "now", "end of the time window on capture_start_time, i.e. now or 2020-12-02")
+	auditGapsCmd.Flags().DurationVar(&auditOptions.Tolerance, "tolerance", time.Second, "difference between the end of a window and the start of the next one ignored")
+	auditGapsCmd.Flags().StringVar(&auditOptions.Format, "format", "json", "output format, json or csv (one row per finding)")
+
+	auditGapsCmd.Flags().BoolVar(&trace, "trace", false, "When set will add elasticsearch request and response body to the output")
+}
@@ -0,0 +1,51 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/flowlogs"
+	"github.com/spf13/cobra"
+)
+
+var validateOptions flowlogs.ValidateOptions
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Checks the configuration read from flowlogs.json and the environment.",
+}
+
+// configValidateCmd represents the config validate command
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Reports every missing or invalid key of the configuration and exits non-zero when there is any.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if code := flowlogs.ValidateConfig(validateOptions, trace); code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configValidateCmd)
+
+	configValidateCmd.Flags().BoolVar(&validateOptions.Connect, "connect", false, "When set will also reach elasticsearch and the cos buckets with the configured credentials")
+
+	configValidateCmd.Flags().BoolVar(&trace, "trace", false, "When set will add elasticsearch request and response body to the output")
+}
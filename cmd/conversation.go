@@ -0,0 +1,51 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/flowlogs"
+	"github.com/spf13/cobra"
+)
+
+var conversationOptions flowlogs.ConversationOptions
+
+// conversationCmd represents the conversation command
+var conversationCmd = &cobra.Command{
+	Use:   "conversation <ip_or_cidr> <ip_or_cidr>",
+	Short: "Shows the connections in both directions between two endpoints.",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		conversationOptions.First = args[0]
+		conversationOptions.Second = args[1]
+		if code := flowlogs.Conversation(conversationOptions, trace); code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(conversationCmd)
+
+	conversationCmd.Flags().StringVar(&conversationOptions.Filter, "filter", "", "filter selecting the flow logs, i.e. \"target_port=443\"")
+	conversationCmd.Flags().StringVar(&conversationOptions.From, "from", "now-24h", "start of the time window on capture_start_time, i.e. now-24h or 2020-12-01")
+	conversationCmd.Flags().StringVar(&conversationOptions.To, "to", "now", "end of the time window on capture_start_time, i.e. now or 2020-12-02")
+	conversationCmd.Flags().IntVar(&conversationOptions.Limit, "limit", 10000, "maximum number of flows to read, 0 for no limit")
+	conversationCmd.Flags().StringVar(&conversationOptions.Format, "format", "json", "output format, json or csv (one row per connection)")
+
+	conversationCmd.Flags().BoolVar(&trace, "trace", false, "When set will add elasticsearch request and response body to the output")
+}
@@ -0,0 +1,108 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/flowlogs"
+	"github.com/spf13/cobra"
+)
+
+var scanOptions flowlogs.ScanOptions
+var exfilOptions flowlogs.ExfilOptions
+var pairsOptions flowlogs.PairsOptions
+
+// detectCmd represents the detect command
+var detectCmd = &cobra.Command{
+	Use:   "detect",
+	Short: "Runs an analysis over the indexed flow logs and reports the findings as JSON.",
+}
+
+// detectScansCmd represents the detect scans command
+var detectScansCmd = &cobra.Command{
+	Use:   "scans",
+	Short: "Finds initiators contacting many ports of one host or one port of many hosts.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if code := flowlogs.DetectScans(scanOptions, trace); code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+// detectExfilCmd represents the detect exfil command
+var detectExfilCmd = &cobra.Command{
+	Use:   "exfil",
+	Short: "Finds hours during which an instance sent unusually many bytes to public destinations.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if code := flowlogs.DetectExfil(exfilOptions, trace); code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+// detectNewPairsCmd represents the detect new-pairs command
+var detectNewPairsCmd = &cobra.Command{
+	Use:   "new-pairs",
+	Short: "Finds the (instance or initiator, target, port, protocol) tuples that are not in the baseline.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if code := flowlogs.DetectNewPairs(pairsOptions, trace); code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(detectCmd)
+	detectCmd.AddCommand(detectScansCmd)
+
+	detectScansCmd.Flags().StringVar(&scanOptions.Filter, "filter", "", "filter selecting the flow logs, i.e. \"direction=inbound\"")
+	detectScansCmd.Flags().StringVar(&scanOptions.From, "from", "now-24h", "start of the time window on capture_start_time, i.e. now-24h or 2020-12-01")
+	detectScansCmd.Flags().StringVar(&scanOptions.To, "to", "now", "end of the time window on capture_start_time, i.e. now or 2020-12-02")
+	detectScansCmd.Flags().StringVar(&scanOptions.Window, "window", "1h", "interval the distinct ports and hosts are counted in, i.e. 10m or 1h")
+	detectScansCmd.Flags().StringVar(&scanOptions.Type, "type", "all", "scans to detect, vertical (ports of one host), horizontal (hosts on one port) or all")
+	detectScansCmd.Flags().Int64Var(&scanOptions.Threshold, "threshold", 25, "score above which a finding is reported, the score is the distinct ports or hosts reached by accepted flows plus rejectedWeight times those reached by rejected flows")
+	detectScansCmd.Flags().Float64Var(&scanOptions.RejectedWeight, "rejectedWeight", 2, "weight of the distinct ports or hosts reached by rejected flows in the score")
+
+	detectScansCmd.Flags().BoolVar(&trace, "trace", false, "When set will add elasticsearch request and response body to the output")
+
+	detectCmd.AddCommand(detectExfilCmd)
+
+	detectExfilCmd.Flags().StringVar(&exfilOptions.Filter, "filter", "", "filter selecting the flow logs, i.e. \"vpc_crn=crn:v1:...\"")
+	detectExfilCmd.Flags().StringVar(&exfilOptions.From, "from", "now-24h", "start of the time window on capture_start_time, i.e. now-24h or 2020-12-01")
+	detectExfilCmd.Flags().StringVar(&exfilOptions.To, "to", "now", "end of the time window on capture_start_time, i.e. now or 2020-12-02")
+	detectExfilCmd.Flags().IntVar(&exfilOptions.BaselineHours, "baselineHours", 168, "number of hours before each hour averaged to compute its baseline")
+	detectExfilCmd.Flags().Float64Var(&exfilOptions.Factor, "factor", 3, "an hour is flagged when its bytes exceed the baseline multiplied by this factor")
+	detectExfilCmd.Flags().Int64Var(&exfilOptions.MinBytes, "minBytes", 10*1024*1024, "hours with fewer bytes are never flagged")
+	detectExfilCmd.Flags().StringSliceVar(&exfilOptions.PrivateCIDRs, "privateCidrs", flowlogs.PrivateCIDRs, "destinations that are not public")
+	detectExfilCmd.Flags().IntVar(&exfilOptions.Top, "top", 5, "number of destinations reported for each spike")
+
+	detectExfilCmd.Flags().BoolVar(&trace, "trace", false, "When set will add elasticsearch request and response body to the output")
+
+	detectCmd.AddCommand(detectNewPairsCmd)
+
+	detectNewPairsCmd.Flags().StringVar(&pairsOptions.Filter, "filter", "", "filter selecting the flow logs, i.e. \"direction=outbound\"")
+	detectNewPairsCmd.Flags().StringVar(&pairsOptions.BaselineFrom, "baselineFrom", "now-30d", "start of the learning window, the learning window ends where the evaluation window starts")
+	detectNewPairsCmd.Flags().StringVar(&pairsOptions.From, "from", "now-24h", "start of the evaluation window on capture_start_time, i.e. now-24h or 2020-12-01")
+	detectNewPairsCmd.Flags().StringVar(&pairsOptions.To, "to", "now", "end of the evaluation window on capture_start_time, i.e. now or 2020-12-02")
+	detectNewPairsCmd.Flags().StringVar(&pairsOptions.By, "by", "instance", "talking side of a pair, instance (instance_crn) or initiator (initiator_ip)")
+	detectNewPairsCmd.Flags().StringVar(&pairsOptions.Store, "store", "local", "where the baseline is kept, local or elasticsearch")
+	detectNewPairsCmd.Flags().StringVar(&pairsOptions.BaselineFile, "baselineFile", "", "file of the local baseline (default is new-pairs-<by>.json)")
+	detectNewPairsCmd.Flags().BoolVar(&pairsOptions.Relearn, "relearn", false, "When set the learning window is read again even if a baseline exists")
+	detectNewPairsCmd.Flags().BoolVar(&pairsOptions.DryRun, "dryRun", false, "When set the baseline is not updated")
+
+	detectNewPairsCmd.Flags().BoolVar(&trace, "trace", false, "When set will add elasticsearch request and response body to the output")
+}
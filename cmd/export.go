@@ -0,0 +1,52 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/flowlogs"
+	"github.com/spf13/cobra"
+)
+
+var exportOptions flowlogs.ExportOptions
+var maxFileSize int64
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Exports the flow logs matching a filter or saved query to compressed files.",
+	Run: func(cmd *cobra.Command, args []string) {
+		exportOptions.MaxFileSize = maxFileSize * 1024 * 1024
+		if code := flowlogs.Export(exportOptions, trace); code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().StringVar(&exportOptions.QueryName, "query", "", "name of a saved query from config/queries.json whose query selects the flow logs to export")
+	exportCmd.Flags().StringVar(&exportOptions.Filter, "filter", "", "filter selecting the flow logs to export, i.e. \"action=rejected,target_port=22|3389,ip=10.240.0.0/16\"")
+	exportCmd.Flags().StringVar(&exportOptions.From, "from", "", "start of the time window on capture_start_time, i.e. now-14d/d or 2020-12-01")
+	exportCmd.Flags().StringVar(&exportOptions.To, "to", "", "end of the time window on capture_start_time, i.e. now/d or 2020-12-15")
+	exportCmd.Flags().StringVar(&exportOptions.Format, "format", "ndjson", "format of the exported files, ndjson or csv")
+	exportCmd.Flags().StringVar(&exportOptions.Directory, "outputDir", "export", "directory to write the files and manifest to, an interrupted export is resumed from this directory")
+	exportCmd.Flags().Int64Var(&maxFileSize, "maxFileSize", 100, "size in MB after which a new file is started")
+
+	exportCmd.Flags().BoolVar(&trace, "trace", false, "When set will add elasticsearch request and response body to the output")
+}
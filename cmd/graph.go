@@ -0,0 +1,49 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/flowlogs"
+	"github.com/spf13/cobra"
+)
+
+var graphOptions flowlogs.GraphOptions
+
+// graphCmd represents the graph command
+var graphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Exports the dependency graph of the flows between instances, ips or zones.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if code := flowlogs.Graph(graphOptions, trace); code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(graphCmd)
+
+	graphCmd.Flags().StringVar(&graphOptions.Nodes, "nodes", "instance", "what the nodes are, instance, ip or zone, the ips not resolved to an instance stay ip nodes")
+	graphCmd.Flags().StringVar(&graphOptions.Filter, "filter", "", "filter selecting the flow logs, i.e. \"action=accepted\"")
+	graphCmd.Flags().StringVar(&graphOptions.From, "from", "now-24h", "start of the time window on capture_start_time, i.e. now-24h or 2020-12-01")
+	graphCmd.Flags().StringVar(&graphOptions.To, "to", "now", "end of the time window on capture_start_time, i.e. now or 2020-12-02")
+	graphCmd.Flags().Int64Var(&graphOptions.MinFlows, "minFlows", 1, "minimum number of flows of an edge")
+	graphCmd.Flags().StringVar(&graphOptions.Format, "format", "dot", "output format, dot (Graphviz), graphml or json (D3 nodes and links)")
+
+	graphCmd.Flags().BoolVar(&trace, "trace", false, "When set will add elasticsearch request and response body to the output")
+}
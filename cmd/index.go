@@ -16,16 +16,44 @@ limitations under the License.
 package cmd
 
 import (
+	"fmt"
+	"log"
+	"os"
+
 	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/flowlogs"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
+var indexOptions flowlogs.IndexOptions
+var profilesOptions flowlogs.ProfilesOptions
+var allProfiles bool
+
 // indexCmd represents the serve command
 var indexCmd = &cobra.Command{
 	Use:   "index",
 	Short: "Reads VPC flowlogs from COS and imports them in Elasticsearch.",
 	Run: func(cmd *cobra.Command, args []string) {
-		flowlogs.Index(trace, recreateIndex)
+		if allProfiles {
+			if profile != "" {
+				log.Fatalln("--profile and --allProfiles cannot be used together")
+			}
+			// Each profile is indexed with the flags of this run, less the ones selecting the profiles.
+			var forward []string
+			cmd.Flags().Visit(func(f *pflag.Flag) {
+				if f.Name != "allProfiles" && f.Name != "parallel" && f.Name != "profile" {
+					forward = append(forward, fmt.Sprintf("--%s=%s", f.Name, f.Value.String()))
+				}
+			})
+			if code := flowlogs.IndexProfiles(profilesOptions, forward); code != 0 {
+				os.Exit(code)
+			}
+			return
+		}
+
+		if code := flowlogs.Index(indexOptions, trace); code != 0 {
+			os.Exit(code)
+		}
 	},
 }
 
@@ -33,5 +61,10 @@ func init() {
 	rootCmd.AddCommand(indexCmd)
 
 	indexCmd.Flags().BoolVar(&trace, "trace", false, "When set will add elasticsearch request and response body to the output")
-	indexCmd.Flags().BoolVar(&recreateIndex, "recreateIndex", false, "When set it will delete the elasticsearch index and recreate it")
+	indexCmd.Flags().BoolVar(&indexOptions.RecreateIndex, "recreateIndex", false, "When set it will delete the elasticsearch index and recreate it")
+	indexCmd.Flags().StringVar(&indexOptions.MetricsFile, "metricsFile", "", "file the prometheus metrics of the run are written to for the node exporter textfile collector, i.e. /var/lib/node_exporter/flowlogs.prom")
+	indexCmd.Flags().StringVar(&indexOptions.ReportDir, "reportDir", "logs", "directory the json report of the run is written to, empty to disable")
+	indexCmd.Flags().BoolVar(&indexOptions.IndexReport, "indexReport", false, "When set the report of the run is also added to the <indexName>-runs index")
+	indexCmd.Flags().BoolVar(&allProfiles, "allProfiles", false, "When set will index every profile of the configuration file, each in its own process with its own logs and report")
+	indexCmd.Flags().IntVar(&profilesOptions.Parallel, "parallel", 1, "number of profiles indexed at the same time with --allProfiles, 0 for all of them")
 }
@@ -0,0 +1,50 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+
+	"time"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/flowlogs"
+	"github.com/spf13/cobra"
+)
+
+var inventoryOptions flowlogs.InventoryOptions
+
+// inventoryCmd represents the inventory command
+var inventoryCmd = &cobra.Command{
+	Use:   "inventory",
+	Short: "Lists the collectors, vpcs, instances and interfaces seen in the flow logs.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if code := flowlogs.Inventory(inventoryOptions, trace); code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(inventoryCmd)
+
+	inventoryCmd.Flags().StringVar(&inventoryOptions.Filter, "filter", "", "filter selecting the flow logs, i.e. \"vpc_crn=crn:v1:...\"")
+	inventoryCmd.Flags().StringVar(&inventoryOptions.From, "from", "now-30d", "start of the time window on capture_start_time, i.e. now-30d or 2020-12-01")
+	inventoryCmd.Flags().StringVar(&inventoryOptions.To, "to", "", "end of the time window on capture_start_time, i.e. now or 2020-12-02")
+	inventoryCmd.Flags().DurationVar(&inventoryOptions.StaleAfter, "staleAfter", 2*time.Hour, "time without capture after which a collector is flagged as stale")
+	inventoryCmd.Flags().StringVar(&inventoryOptions.Format, "format", "json", "output format, json or csv (one row per item with its type)")
+
+	inventoryCmd.Flags().BoolVar(&trace, "trace", false, "When set will add elasticsearch request and response body to the output")
+}
@@ -0,0 +1,52 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/notifier"
+	"github.com/spf13/cobra"
+)
+
+var notifierNames []string
+
+// notifyCmd represents the notify command
+var notifyCmd = &cobra.Command{
+	Use:   "notify",
+	Short: "Manages the notifiers defined in the configuration.",
+}
+
+// notifyTestCmd represents the notify test command
+var notifyTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Sends a test event to the notifiers, i.e. to check a webhook url and secret.",
+	Run: func(cmd *cobra.Command, args []string) {
+		event := notifier.NewEvent(notifier.EventTest, map[string]string{"message": "Test event from vpc-flowlogs-elasticsearch."})
+		if err := notifier.NotifyAll(notifierNames, []notifier.Event{event}); err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("done")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(notifyCmd)
+	notifyCmd.AddCommand(notifyTestCmd)
+
+	notifyTestCmd.Flags().StringSliceVar(&notifierNames, "notifier", []string{"stdout"}, "names of the notifiers to send the test event to")
+}
@@ -0,0 +1,79 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/flowlogs"
+	"github.com/spf13/cobra"
+)
+
+var policyOptions flowlogs.PolicyOptions
+var recommendOptions flowlogs.RecommendOptions
+
+// policyCmd represents the policy command
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Evaluates security group or network ACL rules against the indexed flow logs.",
+}
+
+// policySimulateCmd represents the policy simulate command
+var policySimulateCmd = &cobra.Command{
+	Use:   "simulate",
+	Short: "Reports the accepted traffic that the rules would block, by instance and port.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if code := flowlogs.SimulatePolicy(policyOptions, trace); code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+// policyRecommendCmd represents the policy recommend command
+var policyRecommendCmd = &cobra.Command{
+	Use:   "recommend",
+	Short: "Recommends least-privilege allow rules per interface or instance from the accepted flows.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if code := flowlogs.RecommendRules(recommendOptions, trace); code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(policyCmd)
+	policyCmd.AddCommand(policySimulateCmd)
+
+	policySimulateCmd.Flags().StringVar(&policyOptions.RulesFile, "rules", "config/policy.json", "file holding the rules to simulate")
+	policySimulateCmd.Flags().StringVar(&policyOptions.Filter, "filter", "", "filter selecting the flow logs, i.e. \"vpc_crn=crn:v1:...\"")
+	policySimulateCmd.Flags().StringVar(&policyOptions.From, "from", "now-7d", "start of the time window on capture_start_time, i.e. now-7d or 2020-12-01")
+	policySimulateCmd.Flags().StringVar(&policyOptions.To, "to", "now", "end of the time window on capture_start_time, i.e. now or 2020-12-02")
+	policySimulateCmd.Flags().StringVar(&policyOptions.Format, "format", "json", "output format, json or csv")
+
+	policySimulateCmd.Flags().BoolVar(&trace, "trace", false, "When set will add elasticsearch request and response body to the output")
+
+	policyCmd.AddCommand(policyRecommendCmd)
+
+	policyRecommendCmd.Flags().StringVar(&recommendOptions.By, "by", "interface", "subject of the rule sets, interface (network_interface_id) or instance (instance_crn)")
+	policyRecommendCmd.Flags().StringVar(&recommendOptions.Filter, "filter", "", "filter selecting the flow logs, i.e. \"vpc_crn=crn:v1:...\"")
+	policyRecommendCmd.Flags().StringVar(&recommendOptions.From, "from", "now-30d", "start of the time window on capture_start_time, i.e. now-30d or 2020-12-01")
+	policyRecommendCmd.Flags().StringVar(&recommendOptions.To, "to", "now", "end of the time window on capture_start_time, i.e. now or 2020-12-02")
+	policyRecommendCmd.Flags().IntVar(&recommendOptions.Prefix, "prefix", 24, "prefix length of the CIDR blocks the remote ips are collapsed into")
+	policyRecommendCmd.Flags().IntVar(&recommendOptions.Threshold, "threshold", 4, "number of distinct remote ips of a block from which the block is allowed rather than each ip")
+	policyRecommendCmd.Flags().Int64Var(&recommendOptions.EphemeralMin, "ephemeralMin", 32768, "first port of the ephemeral range, a target port in the range of a connection not seen starting is read as the reply side")
+
+	policyRecommendCmd.Flags().BoolVar(&trace, "trace", false, "When set will add elasticsearch request and response body to the output")
+}
@@ -21,15 +21,17 @@ import (
 	"os"
 	"strings"
 
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/config"
 	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/metrics"
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/notifier"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var cfgFile string
+var profile string
 var trace bool
-var recreateIndex bool
-var query string
 
 var rootCmd = &cobra.Command{
 	Use:   "vpc-flowlogs-elasticsearch",
@@ -51,6 +53,7 @@ func init() {
 
 	cobra.OnInitialize(initConfig)
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "configuation file (default is $HOME/.flowlogs.json)")
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", "", "profile of the configuration file whose cos, elasticsearch and ibmcloud sections are used")
 
 }
 
@@ -65,7 +68,6 @@ func initConfig() {
 	}
 
 	viper.AutomaticEnv()
-	logger.InitLogger()
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 
 	err := viper.ReadInConfig()
@@ -73,6 +75,21 @@ func initConfig() {
 		log.Println("warning: configuration file not found, expecting environment variables to be set.")
 	}
 
+	if profile != "" {
+		if err := config.UseProfile(profile); err != nil {
+			log.Fatalln("unable to select the profile,", err)
+		}
+		metrics.SetProfile(config.Profile())
+	}
+
+	if err := logger.InitLogger(); err != nil {
+		log.Println("warning: unable to configure the logging, using the defaults,", err)
+	}
+
+	if err := notifier.InitNotifiers(); err != nil {
+		log.Println("warning: unable to configure the notifiers,", err)
+	}
+
 	if _, err := os.Stat("config/queries.json"); os.IsNotExist(err) {
 		log.Println("warning: unable to find config/queries.json, make sure the directory exist and is located at the same level as the binary you are running.")
 		os.Exit(1)
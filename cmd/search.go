@@ -20,12 +20,22 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var query string
+var filter string
+var from string
+var to string
+var metric string
+var iocHits bool
+
 // searchCmd represents the serve command
 var searchCmd = &cobra.Command{
 	Use:   "search",
 	Short: "Performs a search in Elasticsearch.",
 	Run: func(cmd *cobra.Command, args []string) {
-		flowlogs.Search(query, trace)
+		if iocHits {
+			query = "ioc_hits"
+		}
+		flowlogs.Search(query, filter, from, to, metric, trace)
 	},
 }
 
@@ -33,6 +43,11 @@ func init() {
 	rootCmd.AddCommand(searchCmd)
 
 	searchCmd.Flags().StringVar(&query, "query", "", "name of the query to run")
+	searchCmd.Flags().StringVar(&filter, "filter", "", "filter narrowing the query to the matching flow logs, i.e. \"direction=inbound,target_port=22\"")
+	searchCmd.Flags().StringVar(&from, "from", "", "start of the time window on capture_start_time, i.e. now-24h or 2020-12-01")
+	searchCmd.Flags().StringVar(&to, "to", "", "end of the time window on capture_start_time, i.e. now or 2020-12-02")
+	searchCmd.Flags().StringVar(&metric, "metric", "", "When set to flows or bytes prints the number of matching flows and their bytes instead of the output of the query, the value an alert rule compares to its threshold")
+	searchCmd.Flags().BoolVar(&iocHits, "ioc", false, "When set lists the flow logs matching an IOC list, same as --query ioc_hits")
 
 	searchCmd.Flags().BoolVar(&trace, "trace", false, "When set will add elasticsearch request and response body to the output")
 }
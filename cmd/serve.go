@@ -0,0 +1,48 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+
+	"time"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/flowlogs"
+	"github.com/spf13/cobra"
+)
+
+var serveOptions flowlogs.ServeOptions
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Imports in Elasticsearch the VPC flowlogs objects named in the object-created events posted to /events.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if code := flowlogs.Serve(serveOptions, trace); code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().StringVar(&serveOptions.Listen, "listen", ":8080", "address the server listens on")
+	serveCmd.Flags().DurationVar(&serveOptions.FlushInterval, "flushInterval", 2*time.Second, "maximum time the documents wait in the bulk indexer")
+	serveCmd.Flags().DurationVar(&serveOptions.ResultTimeout, "resultTimeout", 30*time.Second, "time a request waits for its documents to be flushed before reporting them as queued")
+
+	serveCmd.Flags().BoolVar(&trace, "trace", false, "When set will add elasticsearch request and response body to the output")
+}
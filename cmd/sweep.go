@@ -0,0 +1,52 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/flowlogs"
+	"github.com/spf13/cobra"
+)
+
+var sweepOptions flowlogs.SweepOptions
+
+// sweepCmd represents the sweep command
+var sweepCmd = &cobra.Command{
+	Use:   "sweep <list_file>",
+	Short: "Searches the indexed flow logs for each ip or CIDR block of a list, i.e. a newly received IOC list.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		sweepOptions.File = args[0]
+		if code := flowlogs.Sweep(sweepOptions, trace); code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sweepCmd)
+
+	sweepCmd.Flags().StringVar(&sweepOptions.ListFormat, "listFormat", "", "format of the list, plain, csv or stix (default guessed from the file extension)")
+	sweepCmd.Flags().StringVar(&sweepOptions.Filter, "filter", "", "filter selecting the flow logs, i.e. \"direction=outbound\"")
+	sweepCmd.Flags().StringVar(&sweepOptions.From, "from", "", "start of the time window on capture_start_time, i.e. now-90d or 2020-12-01 (default all the index)")
+	sweepCmd.Flags().StringVar(&sweepOptions.To, "to", "", "end of the time window on capture_start_time, i.e. now or 2020-12-02")
+	sweepCmd.Flags().IntVar(&sweepOptions.BatchSize, "batchSize", 100, "number of indicators searched per request")
+	sweepCmd.Flags().BoolVar(&sweepOptions.HitsOnly, "hitsOnly", false, "When set only the indicators found in the flow logs are reported")
+	sweepCmd.Flags().StringVar(&sweepOptions.Format, "format", "json", "output format, json or csv")
+
+	sweepCmd.Flags().BoolVar(&trace, "trace", false, "When set will add elasticsearch request and response body to the output")
+}
@@ -0,0 +1,52 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/flowlogs"
+	"github.com/spf13/cobra"
+)
+
+var timelineOptions flowlogs.TimelineOptions
+
+// timelineCmd represents the timeline command
+var timelineCmd = &cobra.Command{
+	Use:   "timeline",
+	Short: "Shows the traffic over time as a histogram.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if code := flowlogs.Timeline(timelineOptions, trace); code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(timelineCmd)
+
+	timelineCmd.Flags().StringVar(&timelineOptions.Filter, "filter", "", "filter selecting the flow logs, i.e. \"direction=outbound,target_port=443\"")
+	timelineCmd.Flags().StringVar(&timelineOptions.From, "from", "now-24h", "start of the time window, i.e. now-24h or 2020-12-01")
+	timelineCmd.Flags().StringVar(&timelineOptions.To, "to", "now", "end of the time window, i.e. now or 2020-12-02")
+	timelineCmd.Flags().StringVar(&timelineOptions.TimeField, "timeField", "capture_start_time", "date field of the histogram, capture_start_time or start_time")
+	timelineCmd.Flags().StringVar(&timelineOptions.Interval, "interval", "1h", "width of each bucket, i.e. 5m, 1h or 1d")
+	timelineCmd.Flags().StringVar(&timelineOptions.Metric, "metric", "flows", "value of each bucket, flows, bytes_from_initiator, bytes_from_target or rejected")
+	timelineCmd.Flags().StringVar(&timelineOptions.Split, "split", "", "splits the timeline in one series per direction or action")
+	timelineCmd.Flags().StringVar(&timelineOptions.Format, "format", "json", "output format, json or csv")
+	timelineCmd.Flags().StringVar(&timelineOptions.Chart, "chart", "sparkline", "chart written to stderr, sparkline, bar or none")
+
+	timelineCmd.Flags().BoolVar(&trace, "trace", false, "When set will add elasticsearch request and response body to the output")
+}
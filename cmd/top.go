@@ -0,0 +1,50 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/flowlogs"
+	"github.com/spf13/cobra"
+)
+
+var topOptions flowlogs.TopOptions
+
+// topCmd represents the top command
+var topCmd = &cobra.Command{
+	Use:   "top",
+	Short: "Ranks the top talkers by bytes, packets or flows.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if code := flowlogs.Top(topOptions, trace); code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(topCmd)
+
+	topCmd.Flags().StringVar(&topOptions.Filter, "filter", "", "filter selecting the flow logs, i.e. \"direction=outbound\"")
+	topCmd.Flags().StringVar(&topOptions.From, "from", "now-24h", "start of the time window on capture_start_time, i.e. now-24h or 2020-12-01")
+	topCmd.Flags().StringVar(&topOptions.To, "to", "now", "end of the time window on capture_start_time, i.e. now or 2020-12-02")
+	topCmd.Flags().StringVar(&topOptions.By, "by", "target_ip", "what to rank, initiator_ip, target_ip, pair or target_port")
+	topCmd.Flags().StringVar(&topOptions.Order, "order", "bytes", "metric used to rank, bytes, packets or flows")
+	topCmd.Flags().IntVar(&topOptions.Size, "size", 25, "number of rows to return")
+	topCmd.Flags().StringVar(&topOptions.Format, "format", "json", "output format, json or csv")
+
+	topCmd.Flags().BoolVar(&trace, "trace", false, "When set will add elasticsearch request and response body to the output")
+}
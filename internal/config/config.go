@@ -0,0 +1,556 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package config
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap/zapcore"
+)
+
+// placeholder is the value of the keys to fill in config/flowlogs.json.
+const placeholder = "<provide_value>"
+
+// Config is the typed model of flowlogs.json, each key can also be set in the environment with `_` replacing the `.`,
+// i.e. COS_APIKEY for cos.apikey.
+type Config struct {
+	IBMCloud      IBMCloud
+	COS           COS
+	Elasticsearch Elasticsearch
+	API           API
+	Logging       Logging
+	Tracing       Tracing
+	Notifiers     []Notifier
+	IOC           IOC
+}
+
+// IBMCloud holds the ibmcloud block.
+type IBMCloud struct {
+	IAMURL string
+}
+
+// COS holds the cos block.
+type COS struct {
+	APIKey             string
+	ResourceInstanceID string
+	ServiceEndpoint    string
+	BucketsLocation    string
+	SourceBucketName   string
+	IndexedBucketName  string
+}
+
+// Elasticsearch holds the elasticsearch block, Certificate is the decoded certificate_base64 once validated.
+type Elasticsearch struct {
+	Hostname           string
+	Port               string
+	Username           string
+	Password           string
+	CertificateBase64  string
+	Certificate        []byte
+	IndexName          string
+	IndexMapping       string
+	PairsIndexName     string
+	PairsIndexMapping  string
+	AlertsIndexName    string
+	AlertsIndexMapping string
+	RunsIndexName      string
+	RunsIndexMapping   string
+}
+
+// API holds the api block, Tokens are the bearer tokens allowed to call the api server.
+type API struct {
+	Tokens []string
+}
+
+// Logging holds the logging block, the defaults keep the logs in ./logs as json.
+type Logging struct {
+	Dir           string
+	Level         string
+	ConsoleLevel  string
+	Format        string
+	StdoutOnly    bool
+	MaxSize       int
+	MaxBackups    int
+	MaxAge        int
+	Compress      bool
+	RotateOnStart bool
+}
+
+// Tracing holds the tracing block, the spans are dropped when Exporter is empty.
+type Tracing struct {
+	Exporter    string
+	Endpoint    string
+	Insecure    bool
+	Headers     map[string]string
+	File        string
+	ServiceName string
+}
+
+// Notifier holds a notifier of the notifiers block, keyed by its name.
+type Notifier struct {
+	Name         string
+	Type         string
+	URL          string
+	Secret       string
+	Headers      map[string]string
+	Events       []string
+	MaxRetries   int
+	Backoff      time.Duration
+	Timeout      time.Duration
+	Template     string
+	TemplateFile string
+}
+
+// IOC holds the ioc block.
+type IOC struct {
+	Lists []IOCList
+
+	// err is the error of reading ioc.lists, reported by Validate.
+	err error
+}
+
+// IOCList is an entry of ioc.lists, the format is guessed from the file extension when not set: .json files are
+// STIX 2.1 bundles, .csv files have an indicator and an optional confidence column, other files list one ip or CIDR
+// block per line.
+type IOCList struct {
+	Name       string `mapstructure:"name"`
+	File       string `mapstructure:"file"`
+	Format     string `mapstructure:"format"`
+	Confidence int    `mapstructure:"confidence"`
+}
+
+// Tracing exporters.
+const (
+	TracingOTLP = "otlp"
+	TracingFile = "file"
+)
+
+// DefaultIOCConfidence is given to the indicators of a list that does not carry a confidence of its own.
+const DefaultIOCConfidence = 50
+
+// notifierEvents are the types of event a notifier can subscribe to, alerts are sent to the notifiers named by each
+// rule.
+var notifierEvents = []string{"index_run", "dead_letter", "test"}
+
+func init() {
+	viper.SetDefault("logging.dir", "logs")
+	viper.SetDefault("logging.level", "debug")
+	viper.SetDefault("logging.consoleLevel", "info")
+	viper.SetDefault("logging.format", "json")
+	viper.SetDefault("logging.stdoutOnly", false)
+	viper.SetDefault("logging.maxSize", 50)
+	viper.SetDefault("logging.maxBackups", 500)
+	viper.SetDefault("logging.maxAge", 14)
+	viper.SetDefault("logging.compress", true)
+	viper.SetDefault("logging.rotateOnStart", false)
+}
+
+// Problem is a missing or invalid key.
+type Problem struct {
+	Key     string `json:"key"`
+	Env     string `json:"env"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s or %s %s", p.Key, p.Env, p.Message)
+}
+
+// Load reads the configuration from flowlogs.json and the environment. The pairs, alerts and runs indices default to
+// the flow logs index suffixed with _pairs, _alerts and -runs, the ioc lists to a confidence of 50, the webhooks are
+// retried 3 times after 1 second and time out after 10 seconds and the spans are written to traces.json in
+// logging.dir.
+func Load() *Config {
+	return load(viper.GetViper())
+}
+
+func load(v *viper.Viper) *Config {
+	cfg := &Config{
+		IBMCloud: IBMCloud{
+			IAMURL: v.GetString("ibmcloud.iamUrl"),
+		},
+		COS: COS{
+			APIKey:             v.GetString("cos.apikey"),
+			ResourceInstanceID: v.GetString("cos.resource_instance_id"),
+			ServiceEndpoint:    v.GetString("cos.serviceEndpoint"),
+			BucketsLocation:    v.GetString("cos.bucketsLocation"),
+			SourceBucketName:   v.GetString("cos.sourceBucketName"),
+			IndexedBucketName:  v.GetString("cos.indexedBucketName"),
+		},
+		Elasticsearch: Elasticsearch{
+			Hostname:           v.GetString("elasticsearch.hostname"),
+			Port:               v.GetString("elasticsearch.port"),
+			Username:           v.GetString("elasticsearch.username"),
+			Password:           v.GetString("elasticsearch.password"),
+			CertificateBase64:  v.GetString("elasticsearch.certificate.certificate_base64"),
+			IndexName:          v.GetString("elasticsearch.indexName"),
+			IndexMapping:       v.GetString("elasticsearch.indexMapping"),
+			PairsIndexName:     v.GetString("elasticsearch.pairsIndexName"),
+			PairsIndexMapping:  v.GetString("elasticsearch.pairsIndexMapping"),
+			AlertsIndexName:    v.GetString("elasticsearch.alertsIndexName"),
+			AlertsIndexMapping: v.GetString("elasticsearch.alertsIndexMapping"),
+			RunsIndexName:      v.GetString("elasticsearch.runsIndexName"),
+			RunsIndexMapping:   v.GetString("elasticsearch.runsIndexMapping"),
+		},
+		Logging: Logging{
+			Dir:           v.GetString("logging.dir"),
+			Level:         v.GetString("logging.level"),
+			ConsoleLevel:  v.GetString("logging.consoleLevel"),
+			Format:        v.GetString("logging.format"),
+			StdoutOnly:    v.GetBool("logging.stdoutOnly"),
+			MaxSize:       v.GetInt("logging.maxSize"),
+			MaxBackups:    v.GetInt("logging.maxBackups"),
+			MaxAge:        v.GetInt("logging.maxAge"),
+			Compress:      v.GetBool("logging.compress"),
+			RotateOnStart: v.GetBool("logging.rotateOnStart"),
+		},
+		Tracing: Tracing{
+			Exporter:    v.GetString("tracing.exporter"),
+			Endpoint:    v.GetString("tracing.endpoint"),
+			Insecure:    v.GetBool("tracing.insecure"),
+			Headers:     v.GetStringMapString("tracing.headers"),
+			File:        v.GetString("tracing.file"),
+			ServiceName: v.GetString("tracing.serviceName"),
+		},
+	}
+
+	cfg.IOC.err = v.UnmarshalKey("ioc.lists", &cfg.IOC.Lists)
+	for i := range cfg.IOC.Lists {
+		if cfg.IOC.Lists[i].Confidence == 0 {
+			cfg.IOC.Lists[i].Confidence = DefaultIOCConfidence
+		}
+	}
+
+	var names []string
+	for name := range v.GetStringMap("notifiers") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key := "notifiers." + name
+		n := Notifier{
+			Name:         name,
+			Type:         v.GetString(key + ".type"),
+			URL:          v.GetString(key + ".url"),
+			Secret:       v.GetString(key + ".secret"),
+			Headers:      v.GetStringMapString(key + ".headers"),
+			Events:       v.GetStringSlice(key + ".events"),
+			MaxRetries:   3,
+			Backoff:      time.Second,
+			Timeout:      10 * time.Second,
+			Template:     v.GetString(key + ".template"),
+			TemplateFile: v.GetString(key + ".templateFile"),
+		}
+		if v.IsSet(key + ".maxRetries") {
+			n.MaxRetries = v.GetInt(key + ".maxRetries")
+		}
+		if v.IsSet(key + ".backoff") {
+			n.Backoff = v.GetDuration(key + ".backoff")
+		}
+		if v.IsSet(key + ".timeout") {
+			n.Timeout = v.GetDuration(key + ".timeout")
+		}
+		cfg.Notifiers = append(cfg.Notifiers, n)
+	}
+
+	// The tokens can be set space separated in API_TOKENS, the empty entries of the file are ignored.
+	for _, token := range v.GetStringSlice("api.tokens") {
+		if token != "" {
+			cfg.API.Tokens = append(cfg.API.Tokens, token)
+		}
+	}
+
+	es := &cfg.Elasticsearch
+	defaultValue(&es.PairsIndexName, es.IndexName+"_pairs")
+	defaultValue(&es.PairsIndexMapping, "pairs-v1.json")
+	defaultValue(&es.AlertsIndexName, es.IndexName+"_alerts")
+	defaultValue(&es.AlertsIndexMapping, "alerts-v1.json")
+	defaultValue(&es.RunsIndexName, es.IndexName+"-runs")
+	defaultValue(&es.RunsIndexMapping, "runs-v1.json")
+	defaultValue(&cfg.Tracing.File, filepath.Join(cfg.Logging.Dir, "traces.json"))
+	return cfg
+}
+
+func defaultValue(value *string, defaultValue string) {
+	if *value == "" {
+		*value = defaultValue
+	}
+}
+
+// Address returns the url of the elasticsearch cluster.
+func (e *Elasticsearch) Address() string {
+	return fmt.Sprintf("https://%s:%s", e.Hostname, e.Port)
+}
+
+// Validate checks the keys used by every command reading the flow logs and decodes the certificate.
+func (e *Elasticsearch) Validate() []Problem {
+	var problems []Problem
+	if required(&problems, "elasticsearch.hostname", e.Hostname) {
+		if u, err := url.Parse("https://" + e.Hostname); err != nil || u.Host != e.Hostname || u.Port() != "" {
+			problems = append(problems, problem("elasticsearch.hostname", "is not a host name, expecting the host only, without scheme or port"))
+		}
+	}
+	if required(&problems, "elasticsearch.port", e.Port) {
+		if port, err := strconv.Atoi(e.Port); err != nil || port < 1 || port > 65535 {
+			problems = append(problems, problem("elasticsearch.port", "is not a port number"))
+		}
+	}
+	required(&problems, "elasticsearch.username", e.Username)
+	required(&problems, "elasticsearch.password", e.Password)
+	required(&problems, "elasticsearch.indexName", e.IndexName)
+
+	e.Certificate = nil
+	if required(&problems, "elasticsearch.certificate.certificate_base64", e.CertificateBase64) {
+		cert, err := base64.StdEncoding.DecodeString(e.CertificateBase64)
+		if err != nil {
+			problems = append(problems, problem("elasticsearch.certificate.certificate_base64", fmt.Sprintf("is not base64: %v", err)))
+		} else if !x509.NewCertPool().AppendCertsFromPEM(cert) {
+			problems = append(problems, problem("elasticsearch.certificate.certificate_base64", "does not hold a PEM certificate"))
+		} else {
+			e.Certificate = cert
+		}
+	}
+	return problems
+}
+
+// ValidateMappings checks that the mapping of each index is a file of the config directory.
+func (e *Elasticsearch) ValidateMappings() []Problem {
+	var problems []Problem
+	mappingFile(&problems, "elasticsearch.indexMapping", e.IndexMapping)
+	mappingFile(&problems, "elasticsearch.pairsIndexMapping", e.PairsIndexMapping)
+	mappingFile(&problems, "elasticsearch.alertsIndexMapping", e.AlertsIndexMapping)
+	mappingFile(&problems, "elasticsearch.runsIndexMapping", e.RunsIndexMapping)
+	return problems
+}
+
+// ValidateIndexing checks the keys used by the commands moving objects from cos to elasticsearch.
+func (c *Config) ValidateIndexing() []Problem {
+	problems := c.Elasticsearch.Validate()
+	mappingFile(&problems, "elasticsearch.indexMapping", c.Elasticsearch.IndexMapping)
+	return append(problems, c.ValidateCOS()...)
+}
+
+// ValidateCOS checks the keys used to reach the cos buckets.
+func (c *Config) ValidateCOS() []Problem {
+	var problems []Problem
+	required(&problems, "cos.apikey", c.COS.APIKey)
+	required(&problems, "cos.resource_instance_id", c.COS.ResourceInstanceID)
+	required(&problems, "cos.bucketsLocation", c.COS.BucketsLocation)
+	source := required(&problems, "cos.sourceBucketName", c.COS.SourceBucketName)
+	if required(&problems, "cos.indexedBucketName", c.COS.IndexedBucketName) && source && c.COS.SourceBucketName == c.COS.IndexedBucketName {
+		problems = append(problems, problem("cos.indexedBucketName", "is the source bucket, the indexed objects would be deleted"))
+	}
+
+	if required(&problems, "cos.serviceEndpoint", c.COS.ServiceEndpoint) {
+		// The cos sdk defaults to https when the endpoint has no scheme.
+		endpoint := c.COS.ServiceEndpoint
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "https://" + endpoint
+		}
+		if u, err := url.Parse(endpoint); err != nil || u.Host == "" {
+			problems = append(problems, problem("cos.serviceEndpoint", "is not an endpoint, i.e. s3.us-south.cloud-object-storage.appdomain.cloud"))
+		}
+	}
+	if required(&problems, "ibmcloud.iamUrl", c.IBMCloud.IAMURL) {
+		if u, err := url.Parse(c.IBMCloud.IAMURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			problems = append(problems, problem("ibmcloud.iamUrl", "is not an https url, i.e. https://iam.cloud.ibm.com/identity/token"))
+		}
+	}
+	return problems
+}
+
+// Validate checks the tokens of the api server, none is required unless the server is started.
+func (a *API) Validate() []Problem {
+	var problems []Problem
+	for _, token := range a.Tokens {
+		if token == placeholder {
+			problems = append(problems, problem("api.tokens", "still holds "+placeholder))
+		}
+	}
+	return problems
+}
+
+// Validate checks the levels, the format and the rotation settings.
+func (l *Logging) Validate() []Problem {
+	var problems []Problem
+	level(&problems, "logging.level", l.Level)
+	level(&problems, "logging.consoleLevel", l.ConsoleLevel)
+	if l.Format != "json" && l.Format != "console" {
+		problems = append(problems, problem("logging.format", fmt.Sprintf("is not a format: %s, expecting json or console", l.Format)))
+	}
+	notNegative(&problems, "logging.maxSize", l.MaxSize)
+	notNegative(&problems, "logging.maxBackups", l.MaxBackups)
+	notNegative(&problems, "logging.maxAge", l.MaxAge)
+	return problems
+}
+
+// Validate checks the exporter and the endpoint of the otlp exporter.
+func (t *Tracing) Validate() []Problem {
+	var problems []Problem
+	switch t.Exporter {
+	case "", TracingFile:
+	case TracingOTLP:
+		if t.Endpoint != "" {
+			if _, _, err := net.SplitHostPort(t.Endpoint); err != nil {
+				problems = append(problems, problem("tracing.endpoint", fmt.Sprintf("is not an address: %s, expecting host:port", t.Endpoint)))
+			}
+		}
+	default:
+		problems = append(problems, problem("tracing.exporter", fmt.Sprintf("is not an exporter: %s, expecting %s or %s", t.Exporter, TracingOTLP, TracingFile)))
+	}
+	return problems
+}
+
+// ValidateNotifiers checks each notifier and the events it subscribes to, i.e. a url left to the placeholder would
+// have each event retried against it.
+func (c *Config) ValidateNotifiers() []Problem {
+	var problems []Problem
+	for _, n := range c.Notifiers {
+		key := "notifiers." + n.Name
+		if n.Type != "webhook" {
+			problems = append(problems, problem(key+".type", fmt.Sprintf("is not a notifier type: %q, expecting webhook", n.Type)))
+			continue
+		}
+		if required(&problems, key+".url", n.URL) {
+			if u, err := url.Parse(n.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				problems = append(problems, problem(key+".url", fmt.Sprintf("is not an http or https url: %q", n.URL)))
+			}
+		}
+		for _, event := range n.Events {
+			switch {
+			case event == "alert":
+				problems = append(problems, problem(key+".events", "cannot subscribe to alerts, name the notifier in the rules[].notifiers of config/alerts.json instead"))
+			case !contains(notifierEvents, event):
+				problems = append(problems, problem(key+".events", fmt.Sprintf("holds an unknown event: %q, expecting %s", event, strings.Join(notifierEvents[:2], " or "))))
+			}
+		}
+
+		text := n.Template
+		if n.TemplateFile != "" {
+			b, err := ioutil.ReadFile(n.TemplateFile)
+			if err != nil {
+				problems = append(problems, problem(key+".templateFile", fmt.Sprintf("cannot be read: %v", err)))
+				continue
+			}
+			text = string(b)
+		}
+		// The notifier package provides the json function.
+		funcs := template.FuncMap{"json": func(v interface{}) (string, error) { return "", nil }}
+		if _, err := template.New(n.Name).Funcs(funcs).Parse(text); err != nil {
+			problems = append(problems, problem(key+".template", fmt.Sprintf("is not a template: %v", err)))
+		}
+	}
+	return problems
+}
+
+// Validate checks that each list has a name, a file, a known format and a confidence between 1 and 100.
+func (i *IOC) Validate() []Problem {
+	if i.err != nil {
+		return []Problem{problem("ioc.lists", fmt.Sprintf("is not a list of {name, file, format, confidence}: %v", i.err))}
+	}
+	var problems []Problem
+	for index, list := range i.Lists {
+		key := fmt.Sprintf("ioc.lists.%d", index)
+		required(&problems, key+".name", list.Name)
+		required(&problems, key+".file", list.File)
+		switch list.Format {
+		case "", "plain", "csv", "stix":
+		default:
+			problems = append(problems, problem(key+".format", fmt.Sprintf("is not a format: %s, expecting plain, csv or stix", list.Format)))
+		}
+		if list.Confidence < 1 || list.Confidence > 100 {
+			problems = append(problems, problem(key+".confidence", "is not between 1 and 100"))
+		}
+	}
+	return problems
+}
+
+// Error joins the problems in one error, nil when there is none.
+func Error(problems []Problem) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	var lines []string
+	for _, p := range problems {
+		lines = append(lines, p.String())
+	}
+	return errors.New("invalid configuration:\n  " + strings.Join(lines, "\n  "))
+}
+
+// required adds a problem when the value is empty or still the placeholder and reports whether it is set.
+func required(problems *[]Problem, key string, value string) bool {
+	switch value {
+	case "":
+		*problems = append(*problems, problem(key, "not provided"))
+		return false
+	case placeholder:
+		*problems = append(*problems, problem(key, "still set to "+placeholder))
+		return false
+	}
+	return true
+}
+
+// mappingFile adds a problem when the mapping is not set or not a file of the config directory.
+func mappingFile(problems *[]Problem, key string, mapping string) {
+	if !required(problems, key, mapping) {
+		return
+	}
+	if _, err := os.Stat(filepath.Join("config", mapping)); err != nil {
+		*problems = append(*problems, problem(key, fmt.Sprintf("is not a file of the config directory: %v", err)))
+	}
+}
+
+// level adds a problem when the value is not a zap level.
+func level(problems *[]Problem, key string, value string) {
+	var l zapcore.Level
+	if err := l.UnmarshalText([]byte(value)); err != nil {
+		*problems = append(*problems, problem(key, fmt.Sprintf("is not a level: %s, expecting debug, info, warn or error", value)))
+	}
+}
+
+// notNegative adds a problem when the value is negative.
+func notNegative(problems *[]Problem, key string, value int) {
+	if value < 0 {
+		*problems = append(*problems, problem(key, "must not be negative"))
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func problem(key string, message string) Problem {
+	return Problem{Key: key, Env: strings.ToUpper(strings.Replace(key, ".", "_", -1)), Message: message}
+}
@@ -0,0 +1,482 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// testCertificate returns a self-signed PEM certificate encoded in base64, as certificate_base64 holds it.
+func testCertificate(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "elasticsearch"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+// keys returns the keys of the problems, in order.
+func keys(problems []Problem) []string {
+	var list []string
+	for _, p := range problems {
+		list = append(list, p.Key)
+	}
+	return list
+}
+
+func TestElasticsearchValidate(t *testing.T) {
+	certificate := testCertificate(t)
+	valid := func() Elasticsearch {
+		return Elasticsearch{
+			Hostname:          "abc.databases.appdomain.cloud",
+			Port:              "31234",
+			Username:          "admin",
+			Password:          "secret",
+			CertificateBase64: certificate,
+			IndexName:         "ibm_vpc_flowlogs_v1",
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func(e *Elasticsearch)
+		keys   []string
+	}{
+		{name: "valid", change: func(e *Elasticsearch) {}},
+		{
+			name: "missing and placeholder keys",
+			change: func(e *Elasticsearch) {
+				e.Username = ""
+				e.Password = placeholder
+				e.IndexName = ""
+			},
+			keys: []string{"elasticsearch.username", "elasticsearch.password", "elasticsearch.indexName"},
+		},
+		{name: "hostname with scheme", change: func(e *Elasticsearch) { e.Hostname = "https://abc" }, keys: []string{"elasticsearch.hostname"}},
+		{name: "hostname with port", change: func(e *Elasticsearch) { e.Hostname = "abc:9200" }, keys: []string{"elasticsearch.hostname"}},
+		{name: "port not a number", change: func(e *Elasticsearch) { e.Port = "https" }, keys: []string{"elasticsearch.port"}},
+		{name: "port out of range", change: func(e *Elasticsearch) { e.Port = "70000" }, keys: []string{"elasticsearch.port"}},
+		{
+			name:   "certificate not base64",
+			change: func(e *Elasticsearch) { e.CertificateBase64 = "not base64!" },
+			keys:   []string{"elasticsearch.certificate.certificate_base64"},
+		},
+		{
+			name:   "certificate not pem",
+			change: func(e *Elasticsearch) { e.CertificateBase64 = base64.StdEncoding.EncodeToString([]byte("certificate")) },
+			keys:   []string{"elasticsearch.certificate.certificate_base64"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := valid()
+			test.change(&e)
+			problems := e.Validate()
+			if got := keys(problems); !reflect.DeepEqual(got, test.keys) {
+				t.Errorf("Validate keys = %v, want %v", got, test.keys)
+			}
+			if len(problems) == 0 && e.Certificate == nil {
+				t.Error("Validate did not decode the certificate")
+			}
+		})
+	}
+}
+
+func TestValidateCOS(t *testing.T) {
+	valid := func() Config {
+		return Config{
+			IBMCloud: IBMCloud{IAMURL: "https://iam.cloud.ibm.com/identity/token"},
+			COS: COS{
+				APIKey:             "key",
+				ResourceInstanceID: "crn:v1:bluemix:public:cloud-object-storage:global:a/1::",
+				ServiceEndpoint:    "s3.us-south.cloud-object-storage.appdomain.cloud",
+				BucketsLocation:    "us-south",
+				SourceBucketName:   "flowlogs",
+				IndexedBucketName:  "flowlogs-indexed",
+			},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		keys   []string
+	}{
+		{name: "valid", change: func(c *Config) {}},
+		{name: "endpoint with scheme", change: func(c *Config) { c.COS.ServiceEndpoint = "https://s3.example.com" }},
+		{
+			name:   "same buckets",
+			change: func(c *Config) { c.COS.IndexedBucketName = c.COS.SourceBucketName },
+			keys:   []string{"cos.indexedBucketName"},
+		},
+		{
+			name: "placeholder buckets are not compared",
+			change: func(c *Config) {
+				c.COS.SourceBucketName = placeholder
+				c.COS.IndexedBucketName = placeholder
+			},
+			keys: []string{"cos.sourceBucketName", "cos.indexedBucketName"},
+		},
+		{name: "endpoint without host", change: func(c *Config) { c.COS.ServiceEndpoint = "https://" }, keys: []string{"cos.serviceEndpoint"}},
+		{name: "iam url over http", change: func(c *Config) { c.IBMCloud.IAMURL = "http://iam.cloud.ibm.com" }, keys: []string{"ibmcloud.iamUrl"}},
+		{name: "missing apikey", change: func(c *Config) { c.COS.APIKey = "" }, keys: []string{"cos.apikey"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := valid()
+			test.change(&c)
+			if got := keys(c.ValidateCOS()); !reflect.DeepEqual(got, test.keys) {
+				t.Errorf("ValidateCOS keys = %v, want %v", got, test.keys)
+			}
+		})
+	}
+}
+
+func TestValidateMappings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "config"), 0755); err != nil {
+		t.Fatalf("os.Mkdir: %v", err)
+	}
+	for _, name := range []string{"flowlogs-v1.json", "pairs-v1.json", "alerts-v1.json", "runs-v1.json"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "config", name), []byte("{}"), 0644); err != nil {
+			t.Fatalf("ioutil.WriteFile: %v", err)
+		}
+	}
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("os.Chdir: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(e *Elasticsearch)
+		keys   []string
+	}{
+		{name: "valid", change: func(e *Elasticsearch) {}},
+		{name: "missing file", change: func(e *Elasticsearch) { e.PairsIndexMapping = "pairs-v2.json" }, keys: []string{"elasticsearch.pairsIndexMapping"}},
+		{name: "not set", change: func(e *Elasticsearch) { e.IndexMapping = "" }, keys: []string{"elasticsearch.indexMapping"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := Elasticsearch{
+				IndexMapping:       "flowlogs-v1.json",
+				PairsIndexMapping:  "pairs-v1.json",
+				AlertsIndexMapping: "alerts-v1.json",
+				RunsIndexMapping:   "runs-v1.json",
+			}
+			test.change(&e)
+			if got := keys(e.ValidateMappings()); !reflect.DeepEqual(got, test.keys) {
+				t.Errorf("ValidateMappings keys = %v, want %v", got, test.keys)
+			}
+		})
+	}
+}
+
+func TestAPIValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		tokens []string
+		keys   []string
+	}{
+		{name: "no token"},
+		{name: "tokens", tokens: []string{"a", "b"}},
+		{name: "placeholder", tokens: []string{"a", placeholder}, keys: []string{"api.tokens"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := API{Tokens: test.tokens}
+			if got := keys(a.Validate()); !reflect.DeepEqual(got, test.keys) {
+				t.Errorf("Validate keys = %v, want %v", got, test.keys)
+			}
+		})
+	}
+}
+
+func TestLoggingValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(l *Logging)
+		keys   []string
+	}{
+		{name: "valid", change: func(l *Logging) {}},
+		{name: "console", change: func(l *Logging) { l.Format = "console" }},
+		{name: "levels", change: func(l *Logging) { l.Level, l.ConsoleLevel = "verbose", "loud" }, keys: []string{"logging.level", "logging.consoleLevel"}},
+		{name: "format", change: func(l *Logging) { l.Format = "text" }, keys: []string{"logging.format"}},
+		{name: "rotation", change: func(l *Logging) { l.MaxSize, l.MaxAge = -1, -1 }, keys: []string{"logging.maxSize", "logging.maxAge"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := Logging{Dir: "logs", Level: "debug", ConsoleLevel: "info", Format: "json", MaxSize: 50, MaxBackups: 500, MaxAge: 14}
+			test.change(&l)
+			if got := keys(l.Validate()); !reflect.DeepEqual(got, test.keys) {
+				t.Errorf("Validate keys = %v, want %v", got, test.keys)
+			}
+		})
+	}
+}
+
+func TestTracingValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tracing Tracing
+		keys    []string
+	}{
+		{name: "disabled"},
+		{name: "file", tracing: Tracing{Exporter: TracingFile}},
+		{name: "otlp", tracing: Tracing{Exporter: TracingOTLP, Endpoint: "localhost:4317"}},
+		{name: "otlp default endpoint", tracing: Tracing{Exporter: TracingOTLP}},
+		{name: "otlp endpoint without port", tracing: Tracing{Exporter: TracingOTLP, Endpoint: "localhost"}, keys: []string{"tracing.endpoint"}},
+		{name: "unknown exporter", tracing: Tracing{Exporter: "jaeger"}, keys: []string{"tracing.exporter"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := keys(test.tracing.Validate()); !reflect.DeepEqual(got, test.keys) {
+				t.Errorf("Validate keys = %v, want %v", got, test.keys)
+			}
+		})
+	}
+}
+
+func TestValidateNotifiers(t *testing.T) {
+	tests := []struct {
+		name      string
+		notifiers map[string]interface{}
+		keys      []string
+	}{
+		{name: "none", notifiers: map[string]interface{}{}},
+		{
+			name: "valid webhook",
+			notifiers: map[string]interface{}{
+				"ops": map[string]interface{}{"type": "webhook", "url": "https://hooks.example.com/flowlogs", "events": []string{"index_run", "dead_letter"}},
+			},
+		},
+		{
+			name: "http url and template",
+			notifiers: map[string]interface{}{
+				"ops": map[string]interface{}{"type": "webhook", "url": "http://localhost:8080", "template": `{"text": {{json .Type}}}`},
+			},
+		},
+		{
+			name: "placeholder url",
+			notifiers: map[string]interface{}{
+				"ops": map[string]interface{}{"type": "webhook", "url": placeholder},
+			},
+			keys: []string{"notifiers.ops.url"},
+		},
+		{
+			name: "invalid urls",
+			notifiers: map[string]interface{}{
+				"a": map[string]interface{}{"type": "webhook", "url": "hooks.example.com/flowlogs"},
+				"b": map[string]interface{}{"type": "webhook", "url": "ftp://hooks.example.com"},
+				"c": map[string]interface{}{"type": "webhook", "url": "https://"},
+				"d": map[string]interface{}{"type": "webhook"},
+			},
+			keys: []string{"notifiers.a.url", "notifiers.b.url", "notifiers.c.url", "notifiers.d.url"},
+		},
+		{
+			name: "unknown type and event",
+			notifiers: map[string]interface{}{
+				"ops":  map[string]interface{}{"type": "email"},
+				"chat": map[string]interface{}{"type": "webhook", "url": "https://hooks.example.com", "events": []string{"index"}},
+			},
+			keys: []string{"notifiers.chat.events", "notifiers.ops.type"},
+		},
+		{
+			name: "alert subscription",
+			notifiers: map[string]interface{}{
+				"ops": map[string]interface{}{"type": "webhook", "url": "https://hooks.example.com", "events": []string{"alert"}},
+			},
+			keys: []string{"notifiers.ops.events"},
+		},
+		{
+			name: "invalid template",
+			notifiers: map[string]interface{}{
+				"ops": map[string]interface{}{"type": "webhook", "url": "https://hooks.example.com", "template": "{{ .Type"},
+			},
+			keys: []string{"notifiers.ops.template"},
+		},
+		{
+			name: "missing template file",
+			notifiers: map[string]interface{}{
+				"ops": map[string]interface{}{"type": "webhook", "url": "https://hooks.example.com", "templateFile": "missing.tmpl"},
+			},
+			keys: []string{"notifiers.ops.templateFile"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Reset()
+			defer viper.Reset()
+			viper.Set("notifiers", test.notifiers)
+
+			cfg := Load()
+			if got := keys(cfg.ValidateNotifiers()); !reflect.DeepEqual(got, test.keys) {
+				t.Errorf("ValidateNotifiers keys = %v, want %v", got, test.keys)
+			}
+		})
+	}
+}
+
+func TestIOCValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		lists interface{}
+		keys  []string
+	}{
+		{name: "none"},
+		{
+			name:  "valid",
+			lists: []map[string]interface{}{{"name": "feed", "file": "feed.txt"}, {"name": "stix", "file": "feed.json", "format": "stix", "confidence": 90}},
+		},
+		{
+			name:  "missing name and file",
+			lists: []map[string]interface{}{{"format": "csv"}},
+			keys:  []string{"ioc.lists.0.name", "ioc.lists.0.file"},
+		},
+		{
+			name:  "format and confidence",
+			lists: []map[string]interface{}{{"name": "feed", "file": "feed.txt", "format": "xml", "confidence": 101}},
+			keys:  []string{"ioc.lists.0.format", "ioc.lists.0.confidence"},
+		},
+		{name: "not a list", lists: "feed.txt", keys: []string{"ioc.lists"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Reset()
+			defer viper.Reset()
+			if test.lists != nil {
+				viper.Set("ioc.lists", test.lists)
+			}
+
+			cfg := Load()
+			if got := keys(cfg.IOC.Validate()); !reflect.DeepEqual(got, test.keys) {
+				t.Errorf("Validate keys = %v, want %v", got, test.keys)
+			}
+		})
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	defer viper.Reset()
+
+	viper.Set("logging.dir", "logs")
+	viper.Set("elasticsearch.indexName", "flows")
+	viper.Set("elasticsearch.alertsIndexName", "alerts")
+	viper.Set("api.tokens", []string{"", "token"})
+	viper.Set("notifiers.ops.type", "webhook")
+	viper.Set("notifiers.ops.timeout", "30s")
+	viper.Set("ioc.lists", []map[string]interface{}{{"name": "feed", "file": "feed.txt"}})
+
+	cfg := Load()
+	es := cfg.Elasticsearch
+	want := Elasticsearch{
+		IndexName:          "flows",
+		PairsIndexName:     "flows_pairs",
+		PairsIndexMapping:  "pairs-v1.json",
+		AlertsIndexName:    "alerts",
+		AlertsIndexMapping: "alerts-v1.json",
+		RunsIndexName:      "flows-runs",
+		RunsIndexMapping:   "runs-v1.json",
+	}
+	if !reflect.DeepEqual(es, want) {
+		t.Errorf("Load elasticsearch = %+v, want %+v", es, want)
+	}
+	if !reflect.DeepEqual(cfg.API.Tokens, []string{"token"}) {
+		t.Errorf("Load api tokens = %v, want [token]", cfg.API.Tokens)
+	}
+	if n := cfg.Notifiers; len(n) != 1 || n[0].Name != "ops" || n[0].MaxRetries != 3 || n[0].Backoff != time.Second || n[0].Timeout != 30*time.Second {
+		t.Errorf("Load notifiers = %+v, want ops with 3 retries, 1s backoff and 30s timeout", n)
+	}
+	if lists := cfg.IOC.Lists; len(lists) != 1 || lists[0].Confidence != DefaultIOCConfidence {
+		t.Errorf("Load ioc lists = %+v, want feed with confidence %d", lists, DefaultIOCConfidence)
+	}
+	if want := filepath.Join("logs", "traces.json"); cfg.Tracing.File != want {
+		t.Errorf("Load tracing file = %s, want %s", cfg.Tracing.File, want)
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	defer viper.Reset()
+
+	viper.Set("logging.dir", "logs")
+	viper.Set("cos.apikey", "key")
+	viper.Set("cos.sourceBucketName", "flows")
+	viper.Set("profiles.eu-de.cos.sourceBucketName", "eu-flows")
+
+	cfg, err := LoadProfile("EU-DE")
+	if err != nil {
+		t.Fatalf("LoadProfile: %v", err)
+	}
+	if cfg.COS.APIKey != "key" || cfg.COS.SourceBucketName != "eu-flows" {
+		t.Errorf("LoadProfile cos = %+v, want the key of the top level and the bucket of the profile", cfg.COS)
+	}
+	if want := filepath.Join("logs", "eu-de", "traces.json"); cfg.Tracing.File != want {
+		t.Errorf("LoadProfile tracing file = %s, want %s", cfg.Tracing.File, want)
+	}
+	if got := Load().COS.SourceBucketName; got != "flows" {
+		t.Errorf("Load cos.sourceBucketName = %s after LoadProfile, want flows", got)
+	}
+
+	if _, err := LoadProfile("us-south"); err == nil {
+		t.Errorf("LoadProfile us-south = nil error, want not found")
+	}
+}
+
+func TestProblemString(t *testing.T) {
+	p := problem("cos.sourceBucketName", "not provided")
+	if got := p.String(); got != "cos.sourceBucketName or COS_SOURCEBUCKETNAME not provided" {
+		t.Errorf("String = %q", got)
+	}
+}
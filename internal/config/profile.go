@@ -0,0 +1,82 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// profile is the name of the profile selected with UseProfile, empty when the top level sections are used.
+var profile string
+
+// Profiles returns the sorted names of the profiles of flowlogs.json, lower-cased as viper keys are case insensitive.
+func Profiles() []string {
+	var names []string
+	for name := range viper.GetStringMap("profiles") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Profile returns the name of the selected profile, empty when none is.
+func Profile() string {
+	return profile
+}
+
+// LoadProfile returns the configuration of the profile merged over the top level sections as UseProfile would, without
+// selecting it.
+func LoadProfile(name string) (*Config, error) {
+	name = strings.ToLower(name)
+	sections := viper.GetStringMap("profiles." + name)
+	if len(sections) == 0 {
+		return nil, fmt.Errorf("profile %q not found in the configuration file, expecting one of %s", name, strings.Join(Profiles(), ", "))
+	}
+
+	v := viper.New()
+	v.AutomaticEnv()
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	if err := v.MergeConfigMap(viper.AllSettings()); err != nil {
+		return nil, fmt.Errorf("viper.MergeConfigMap: %v", err)
+	}
+	if err := v.MergeConfigMap(sections); err != nil {
+		return nil, fmt.Errorf("viper.MergeConfigMap: %v", err)
+	}
+	v.Set("logging.dir", filepath.Join(v.GetString("logging.dir"), name))
+	return load(v), nil
+}
+
+// UseProfile merges the sections of the profile over the top level ones, so a profile only holds what differs, and
+// moves the logs of the profile under a sub-directory of logging.dir.
+func UseProfile(name string) error {
+	name = strings.ToLower(name)
+	sections := viper.GetStringMap("profiles." + name)
+	if len(sections) == 0 {
+		return fmt.Errorf("profile %q not found in the configuration file, expecting one of %s", name, strings.Join(Profiles(), ", "))
+	}
+	if err := viper.MergeConfigMap(sections); err != nil {
+		return fmt.Errorf("viper.MergeConfigMap: %v", err)
+	}
+
+	profile = name
+	viper.Set("logging.dir", filepath.Join(viper.GetString("logging.dir"), name))
+	return nil
+}
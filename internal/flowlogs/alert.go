@@ -0,0 +1,371 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/notifier"
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+// AlertOptions holds the settings of the alerting engine.
+type AlertOptions struct {
+	RulesFile string
+	Interval  time.Duration
+	Once      bool
+}
+
+// alertRule is read from the rules file. The query is the name of a saved query from config/queries.json, its query
+// is narrowed by the filter and the window ending at evaluation time. The value of the rule is the number of matching
+// flows, or their bytes when the metric is bytes, per group when grouped, and an alert is raised above the threshold.
+type alertRule struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Query       string   `json:"query"`
+	Filter      string   `json:"filter"`
+	Window      string   `json:"window"`
+	GroupBy     string   `json:"group_by"`
+	Metric      string   `json:"metric"`
+	Threshold   int64    `json:"threshold"`
+	Severity    string   `json:"severity"`
+	Notifiers   []string `json:"notifiers"`
+
+	window time.Duration
+}
+
+// Alert is written to the alerts index and notified. Reproduce is the search command returning the saved query
+// output over the same flows the alert was raised from.
+type Alert struct {
+	ID          string          `json:"id"`
+	Timestamp   string          `json:"@timestamp"`
+	Rule        string          `json:"rule"`
+	Description string          `json:"description,omitempty"`
+	Severity    string          `json:"severity"`
+	WindowStart string          `json:"window_start"`
+	WindowEnd   string          `json:"window_end"`
+	GroupBy     string          `json:"group_by,omitempty"`
+	Group       string          `json:"group,omitempty"`
+	Metric      string          `json:"metric"`
+	Value       int64           `json:"value"`
+	Threshold   int64           `json:"threshold"`
+	QueryName   string          `json:"query_name"`
+	Filter      string          `json:"filter,omitempty"`
+	Query       json.RawMessage `json:"query"`
+	Reproduce   string          `json:"reproduce"`
+}
+
+// RunAlerts function, returns the exit code of the command: 0 on success and 1 when it failed.
+func RunAlerts(options AlertOptions, trace bool) int {
+	err := runAlerts(options, trace)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+func runAlerts(options AlertOptions, trace bool) error {
+	rules, err := loadAlertRules(options.RulesFile)
+	if err != nil {
+		return err
+	}
+
+	esClient, es, err := newElasticsearch(trace)
+	if err != nil {
+		return err
+	}
+	esIndexName := es.IndexName
+	alertsIndexName := es.AlertsIndexName
+
+	if err := ensureIndex(esClient, alertsIndexName, es.AlertsIndexMapping); err != nil {
+		logger.ErrorLogger.Error("Cannot create index", zap.Error(err))
+		return err
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	for {
+		evaluateAlertRules(esClient, esIndexName, alertsIndexName, rules, time.Now().UTC().Truncate(time.Second))
+
+		if options.Once {
+			return nil
+		}
+
+		select {
+		case <-stop:
+			logger.SystemLogger.Info("Stopped evaluating alert rules.")
+			return nil
+		case <-time.After(options.Interval):
+		}
+	}
+}
+
+// evaluateAlertRules evaluates every rule at the given time, a failing rule is logged and does not stop the others.
+func evaluateAlertRules(esClient *elasticsearch.Client, esIndexName string, alertsIndexName string, rules []alertRule, end time.Time) {
+	for _, rule := range rules {
+		alerts, err := evaluateAlertRule(esClient, esIndexName, rule, end)
+		if err != nil {
+			logger.ErrorLogger.Error("Error evaluating alert rule", zap.String("rule", rule.Name), zap.Error(err))
+			continue
+		}
+
+		logger.SystemLogger.Info("Evaluated rule.", zap.String("rule", rule.Name), zap.Int("alerts", len(alerts)))
+		if len(alerts) == 0 {
+			continue
+		}
+
+		existing, err := existingAlerts(esClient, alertsIndexName, alerts)
+		if err != nil {
+			logger.ErrorLogger.Error("Error reading alerts", zap.String("rule", rule.Name), zap.Error(err))
+			continue
+		}
+
+		documents := map[string]interface{}{}
+		var events []notifier.Event
+		for _, alert := range alerts {
+			if existing[alert.ID] {
+				continue
+			}
+			documents[alert.ID] = alert
+			events = append(events, notifier.NewEvent(notifier.EventAlert, alert))
+		}
+		if len(documents) == 0 {
+			logger.SystemLogger.Info("Alerts already raised for this window.", zap.String("rule", rule.Name))
+			continue
+		}
+
+		// The alerts already written are not notified again, so alerts that could not be written are not notified
+		// either, the next evaluation raises them again.
+		if err := bulkWrite(esClient, alertsIndexName, documents); err != nil {
+			logger.ErrorLogger.Error("Error writing alerts, they are not notified.", zap.String("rule", rule.Name), zap.Error(err))
+			continue
+		}
+
+		notifier.NotifyAll(rule.Notifiers, events)
+	}
+}
+
+func evaluateAlertRule(esClient *elasticsearch.Client, esIndexName string, rule alertRule, end time.Time) ([]Alert, error) {
+	start := end.Add(-rule.window)
+	from := start.Format(time.RFC3339)
+	to := end.Format(time.RFC3339)
+	// The alerts of a group are identified by the window sized bucket the evaluation falls in, so a condition lasting
+	// across evaluations more frequent than the window raises a single alert per window.
+	bucket := end.Truncate(rule.window).Format(time.RFC3339)
+
+	filter, err := ParseFilter(rule.Filter, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the query of the saved query is used, its aggregations are replaced by the ones computing the value.
+	command, _ := narrowedCommand(savedQuery(loadQueries(), rule.Query), filter).(map[string]interface{})
+	body := map[string]interface{}{
+		"size":             0,
+		"track_total_hits": true,
+		"query":            command["query"],
+	}
+
+	var valueAggs map[string]interface{}
+	if rule.Metric == "bytes" {
+		valueAggs = metricAggs()
+	}
+
+	if rule.GroupBy != "" {
+		terms := map[string]interface{}{"field": filterFields[rule.GroupBy], "size": 1000}
+		if rule.Metric == "bytes" {
+			terms["order"] = map[string]interface{}{"value": "desc"}
+		}
+		groups := map[string]interface{}{"terms": terms}
+		if valueAggs != nil {
+			groups["aggs"] = valueAggs
+		}
+		body["aggs"] = map[string]interface{}{"groups": groups}
+	} else if valueAggs != nil {
+		body["aggs"] = valueAggs
+	}
+
+	response, err := searchBody(esClient, esIndexName, body)
+	if err != nil {
+		return nil, err
+	}
+
+	query, _ := json.Marshal(body["query"])
+	newAlert := func(group string, value int64) Alert {
+		reproduce := fmt.Sprintf("vpc-flowlogs-elasticsearch search --query %s --from %s --to %s --metric %s", rule.Query, from, to, rule.Metric)
+		alertFilter := rule.Filter
+		if group != "" {
+			if alertFilter != "" {
+				alertFilter += ","
+			}
+			alertFilter += rule.GroupBy + "=" + group
+		}
+		if alertFilter != "" {
+			reproduce += " --filter " + strconv.Quote(alertFilter)
+		}
+
+		return Alert{
+			ID:          fmt.Sprintf("%x", sha256.Sum256([]byte(rule.Name+"|"+group+"|"+bucket))),
+			Timestamp:   time.Now().UTC().Format(time.RFC3339),
+			Rule:        rule.Name,
+			Description: rule.Description,
+			Severity:    rule.Severity,
+			WindowStart: from,
+			WindowEnd:   to,
+			GroupBy:     rule.GroupBy,
+			Group:       group,
+			Metric:      rule.Metric,
+			Value:       value,
+			Threshold:   rule.Threshold,
+			QueryName:   rule.Query,
+			Filter:      alertFilter,
+			Query:       query,
+			Reproduce:   reproduce,
+		}
+	}
+
+	var alerts []Alert
+	if rule.GroupBy == "" {
+		value := gjson.GetBytes(response, "hits.total.value").Int()
+		if rule.Metric == "bytes" {
+			value = gjson.GetBytes(response, "aggregations.value.value").Int()
+		}
+		if value > rule.Threshold {
+			alerts = append(alerts, newAlert("", value))
+		}
+		return alerts, nil
+	}
+
+	gjson.GetBytes(response, "aggregations.groups.buckets").ForEach(func(_, bucket gjson.Result) bool {
+		value := bucket.Get("doc_count").Int()
+		if rule.Metric == "bytes" {
+			value = bucket.Get("value.value").Int()
+		}
+		if value > rule.Threshold {
+			alerts = append(alerts, newAlert(bucket.Get("key").String(), value))
+		}
+		return true
+	})
+	return alerts, nil
+}
+
+// existingAlerts returns the ids of the alerts already written to the alerts index.
+func existingAlerts(esClient *elasticsearch.Client, alertsIndexName string, alerts []Alert) (map[string]bool, error) {
+	var ids []string
+	for _, alert := range alerts {
+		ids = append(ids, alert.ID)
+	}
+	body, _ := json.Marshal(map[string]interface{}{"ids": ids})
+
+	res, err := esClient.Mget(bytes.NewReader(body), esClient.Mget.WithIndex(alertsIndexName), esClient.Mget.WithSource("false"))
+	if err != nil {
+		return nil, fmt.Errorf("esClient.Mget: %v", err)
+	}
+	defer res.Body.Close()
+
+	response, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("ioutil.ReadAll: %v", err)
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("esClient.Mget: %s: %s", res.Status(), gjson.GetBytes(response, "error.reason").String())
+	}
+
+	existing := map[string]bool{}
+	gjson.GetBytes(response, "docs").ForEach(func(_, doc gjson.Result) bool {
+		if doc.Get("found").Bool() {
+			existing[doc.Get("_id").String()] = true
+		}
+		return true
+	})
+	return existing, nil
+}
+
+// loadAlertRules reads and validates the rules file, reporting the first invalid rule.
+func loadAlertRules(rulesFile string) ([]alertRule, error) {
+	b, err := ioutil.ReadFile(rulesFile)
+	if err != nil {
+		return nil, fmt.Errorf("ioutil.ReadFile: %v", err)
+	}
+
+	var config struct {
+		Rules []alertRule `json:"rules"`
+	}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("invalid rules in %s: %v", rulesFile, err)
+	}
+	if len(config.Rules) == 0 {
+		return nil, fmt.Errorf("no rules found in %s", rulesFile)
+	}
+
+	queries := loadQueries()
+	names := map[string]bool{}
+
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+
+		if rule.Name == "" || names[rule.Name] {
+			return nil, fmt.Errorf("rule %d: missing or duplicate name", i+1)
+		}
+		names[rule.Name] = true
+
+		if !savedQuery(queries, rule.Query).Exists() {
+			return nil, fmt.Errorf("rule %s: query %q not found in config/queries.json", rule.Name, rule.Query)
+		}
+		if _, err := ParseFilter(rule.Filter, "", ""); err != nil {
+			return nil, fmt.Errorf("rule %s: %v", rule.Name, err)
+		}
+		if rule.window, err = time.ParseDuration(rule.Window); err != nil || rule.window <= 0 {
+			return nil, fmt.Errorf("rule %s: invalid window %q, expecting a duration such as 15m or 24h", rule.Name, rule.Window)
+		}
+		if _, ok := filterFields[rule.GroupBy]; rule.GroupBy != "" && !ok {
+			return nil, fmt.Errorf("rule %s: invalid group_by %s", rule.Name, rule.GroupBy)
+		}
+		if rule.Metric == "" {
+			rule.Metric = "flows"
+		}
+		if rule.Metric != "flows" && rule.Metric != "bytes" {
+			return nil, fmt.Errorf("rule %s: invalid metric %s, expecting flows or bytes", rule.Name, rule.Metric)
+		}
+		if rule.Severity == "" {
+			rule.Severity = "medium"
+		}
+		if len(rule.Notifiers) == 0 {
+			rule.Notifiers = []string{"log"}
+		}
+		for _, name := range rule.Notifiers {
+			if _, err := notifier.Lookup(name); err != nil {
+				return nil, fmt.Errorf("rule %s: %v", rule.Name, err)
+			}
+		}
+	}
+
+	return config.Rules, nil
+}
@@ -0,0 +1,277 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/config"
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultSearchSize is the number of flow logs returned by /flows/search when the request sets no size.
+	defaultSearchSize = 100
+	// maxSearchSize bounds the size of a /flows/search request.
+	maxSearchSize = 1000
+)
+
+// APIOptions holds the settings of the query api server.
+type APIOptions struct {
+	Listen string
+}
+
+// apiRequest is the body of POST /queries/{name} and POST /flows/search, the filter uses the syntax of --filter. The
+// size only applies to /flows/search, the saved queries set their own.
+type apiRequest struct {
+	Filter string `json:"filter"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Size   int    `json:"size"`
+}
+
+type apiQuery struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// apiFlows is the response of POST /flows/search. Unlike the queryResult list of the saved queries it holds the flow
+// log documents as indexed, along with the number of documents matching the request.
+type apiFlows struct {
+	Total int64             `json:"total"`
+	Flows []json.RawMessage `json:"flows"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// queryAPI serves the saved queries without exposing the elasticsearch credentials.
+type queryAPI struct {
+	esClient    *elasticsearch.Client
+	esIndexName string
+	tokens      []string
+}
+
+// API function, returns the exit code of the command: 0 on success and 1 when it failed.
+func API(options APIOptions, trace bool) int {
+	err := serveAPI(options, trace)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+func serveAPI(options APIOptions, trace bool) error {
+	// The tokens are read from api.tokens or API_TOKENS, space separated.
+	cfg := config.Load()
+	if err := config.Error(cfg.API.Validate()); err != nil {
+		return err
+	}
+	tokens := cfg.API.Tokens
+	if len(tokens) == 0 {
+		return errors.New("api.tokens or API_TOKENS not provided, the api requires at least one token")
+	}
+
+	esClient, esIndexName, err := newElasticsearchClient(trace)
+	if err != nil {
+		return err
+	}
+
+	api := &queryAPI{esClient: esClient, esIndexName: esIndexName, tokens: tokens}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/queries", api.authorized(api.listQueries))
+	mux.HandleFunc("/queries/", api.authorized(api.runQuery))
+	mux.HandleFunc("/flows/search", api.authorized(api.searchFlows))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	server := &http.Server{Addr: options.Listen, Handler: mux}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-stop
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			logger.ErrorLogger.Error("Error stopping the server.", zap.Error(err))
+		}
+	}()
+
+	logger.SystemLogger.Info("Serving the queries.", zap.String("index", esIndexName), zap.String("listen", options.Listen))
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.ErrorLogger.Error("Error starting the server.", zap.String("listen", options.Listen), zap.Error(err))
+		return fmt.Errorf("server.ListenAndServe: %v", err)
+	}
+	return nil
+}
+
+// authorized rejects the requests without one of the tokens as bearer token.
+func (api *queryAPI) authorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		if strings.HasPrefix(authorization, "Bearer ") {
+			token := strings.TrimPrefix(authorization, "Bearer ")
+			for _, t := range api.tokens {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+					handler(w, r)
+					return
+				}
+			}
+		}
+		logger.SystemLogger.Warn("Rejected unauthorized request.", zap.String("method", r.Method), zap.String("path", r.URL.Path), zap.String("remote_addr", r.RemoteAddr))
+		writeAPIError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+	}
+}
+
+// listQueries handles GET /queries.
+func (api *queryAPI) listQueries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	list := []apiQuery{}
+	gjson.GetBytes(loadQueries(), "queries").ForEach(func(_, saved gjson.Result) bool {
+		list = append(list, apiQuery{Name: saved.Get("name").String(), Description: strings.TrimSpace(saved.Get("description").String())})
+		return true
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// runQuery handles POST /queries/{name}.
+func (api *queryAPI) runQuery(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/queries/")
+	saved := savedQuery(loadQueries(), name)
+	if name == "" || !saved.Exists() {
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("query %s not found", name))
+		return
+	}
+
+	request, filter, ok := decodeAPIRequest(w, r)
+	if !ok {
+		return
+	}
+	if request.Size != 0 {
+		writeAPIError(w, http.StatusBadRequest, errors.New("size is not supported by the saved queries, it only applies to /flows/search"))
+		return
+	}
+
+	result, err := runSavedQuery(api.esClient, api.esIndexName, saved, filter)
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	logger.SystemLogger.Info("Ran query.", zap.String("query", name), zap.String("filter", request.Filter),
+		zap.String("from", request.From), zap.String("to", request.To))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+// searchFlows handles POST /flows/search, the flow logs matching the filter are returned most recent first.
+func (api *queryAPI) searchFlows(w http.ResponseWriter, r *http.Request) {
+	request, filter, ok := decodeAPIRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if request.Size <= 0 {
+		request.Size = defaultSearchSize
+	}
+	if request.Size > maxSearchSize {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("size must not exceed %d", maxSearchSize))
+		return
+	}
+
+	response, err := searchBody(api.esClient, api.esIndexName, map[string]interface{}{
+		"query":            filter.Query(),
+		"size":             request.Size,
+		"sort":             []interface{}{map[string]interface{}{"capture_start_time": "desc"}},
+		"track_total_hits": true,
+	})
+	if err != nil {
+		logger.ErrorLogger.Error("Error getting response from search", zap.String("index", api.esIndexName), zap.Error(err))
+		writeAPIError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	result := apiFlows{Total: gjson.GetBytes(response, "hits.total.value").Int(), Flows: []json.RawMessage{}}
+	gjson.GetBytes(response, "hits.hits").ForEach(func(_, hit gjson.Result) bool {
+		result.Flows = append(result.Flows, json.RawMessage(hit.Get("_source").Raw))
+		return true
+	})
+
+	logger.SystemLogger.Info("Searched flows.", zap.String("filter", request.Filter), zap.String("from", request.From),
+		zap.String("to", request.To), zap.Int("flows", len(result.Flows)), zap.Int64("total", result.Total))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// decodeAPIRequest decodes the optional body of a request and parses its filter, the error is written to the response
+// when it fails.
+func decodeAPIRequest(w http.ResponseWriter, r *http.Request) (*apiRequest, *Filter, bool) {
+	if r.Method != http.MethodPost {
+		writeAPIError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return nil, nil, false
+	}
+
+	var request apiRequest
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err == nil && len(body) > 0 {
+		err = json.Unmarshal(body, &request)
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		return nil, nil, false
+	}
+
+	filter, err := ParseFilter(request.Filter, request.From, request.To)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return nil, nil, false
+	}
+	return &request, filter, true
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiError{Error: err.Error()})
+}
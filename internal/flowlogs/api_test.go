@@ -0,0 +1,67 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	logger.SystemLogger = zap.NewNop()
+	logger.ErrorLogger = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+func TestAuthorized(t *testing.T) {
+	api := &queryAPI{tokens: []string{"secret", "other"}}
+	handler := api.authorized(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{name: "bearer token", authorization: "Bearer secret", status: http.StatusNoContent},
+		{name: "second token", authorization: "Bearer other", status: http.StatusNoContent},
+		{name: "missing header", status: http.StatusUnauthorized},
+		{name: "token without scheme", authorization: "secret", status: http.StatusUnauthorized},
+		{name: "other scheme", authorization: "Basic secret", status: http.StatusUnauthorized},
+		{name: "unknown token", authorization: "Bearer guess", status: http.StatusUnauthorized},
+		{name: "empty token", authorization: "Bearer ", status: http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/queries", nil)
+			if test.authorization != "" {
+				r.Header.Set("Authorization", test.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != test.status {
+				t.Errorf("status = %d, want %d", w.Code, test.status)
+			}
+		})
+	}
+}
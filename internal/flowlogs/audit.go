@@ -0,0 +1,253 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+// AuditOptions holds the settings of the capture continuity audit.
+type AuditOptions struct {
+	Filter    string
+	From      string
+	To        string
+	Tolerance time.Duration
+	Format    string
+}
+
+// captureWindow is a flow log object of an interface as indexed.
+type captureWindow struct {
+	objectID         string
+	start            time.Time
+	end              time.Time
+	state            string
+	numberOfFlowLogs int64
+	documents        int64
+}
+
+// auditFinding is a gap, an overlap or a duplicate between two capture windows, an object whose number_of_flow_logs
+// disagrees with its indexed documents or an object whose state is not ok.
+type auditFinding struct {
+	Type               string  `json:"type"`
+	NetworkInterfaceID string  `json:"network_interface_id"`
+	Start              string  `json:"start"`
+	End                string  `json:"end"`
+	DurationSeconds    float64 `json:"duration_seconds,omitempty"`
+	ObjectID           string  `json:"object_id,omitempty"`
+	OtherObjectID      string  `json:"other_object_id,omitempty"`
+	State              string  `json:"state,omitempty"`
+	NumberOfFlowLogs   int64   `json:"number_of_flow_logs,omitempty"`
+	IndexedDocuments   int64   `json:"indexed_documents,omitempty"`
+}
+
+var auditFindingColumns = []string{
+	"type", "network_interface_id", "start", "end", "duration_seconds", "object_id", "other_object_id", "state",
+	"number_of_flow_logs", "indexed_documents",
+}
+
+func (f *auditFinding) csvRow() []string {
+	return []string{
+		f.Type, f.NetworkInterfaceID, f.Start, f.End, strconv.FormatFloat(f.DurationSeconds, 'f', 0, 64), f.ObjectID,
+		f.OtherObjectID, f.State, strconv.FormatInt(f.NumberOfFlowLogs, 10), strconv.FormatInt(f.IndexedDocuments, 10),
+	}
+}
+
+// interfaceAudit summarizes the findings of an interface.
+type interfaceAudit struct {
+	NetworkInterfaceID string `json:"network_interface_id"`
+	FirstCapture       string `json:"first_capture"`
+	LastCapture        string `json:"last_capture"`
+	Windows            int    `json:"windows"`
+	Gaps               int    `json:"gaps"`
+	GapSeconds         int64  `json:"gap_seconds"`
+	Overlaps           int    `json:"overlaps"`
+	Duplicates         int    `json:"duplicates"`
+	CountMismatches    int    `json:"count_mismatches"`
+	NotOk              int    `json:"not_ok"`
+}
+
+type auditReport struct {
+	Interfaces []*interfaceAudit `json:"interfaces"`
+	Findings   []*auditFinding   `json:"findings"`
+}
+
+// AuditGaps function, returns the exit code of the command: 0 on success and 1 when it failed.
+func AuditGaps(options AuditOptions, trace bool) int {
+	err := auditGaps(options, trace)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+func auditGaps(options AuditOptions, trace bool) error {
+	if err := validateFormat(options.Format); err != nil {
+		return err
+	}
+
+	filter, err := ParseFilter(options.Filter, options.From, options.To)
+	if err != nil {
+		return err
+	}
+	// The objects holding no flow are recorded by an empty document, without it their capture windows would be gaps.
+	filter.EmptyObjects = true
+
+	esClient, esIndexName, err := newElasticsearchClient(trace)
+	if err != nil {
+		return err
+	}
+
+	sources := []interface{}{
+		compositeSource("network_interface_id", filterFields["network_interface_id"]),
+		compositeSource("capture_start_time", "capture_start_time"),
+		compositeSource("capture_end_time", "capture_end_time"),
+		// The documents indexed before the object_id field existed are grouped by capture window.
+		map[string]interface{}{
+			"object": map[string]interface{}{
+				"terms": map[string]interface{}{"field": "object_id", "missing_bucket": true},
+			},
+		},
+	}
+	aggs := map[string]interface{}{
+		"empty":               map[string]interface{}{"filter": map[string]interface{}{"term": map[string]interface{}{"empty": true}}},
+		"number_of_flow_logs": map[string]interface{}{"max": map[string]interface{}{"field": "number_of_flow_logs"}},
+		"state":               map[string]interface{}{"terms": map[string]interface{}{"field": filterFields["state"], "size": 1}},
+	}
+
+	windows := map[string][]captureWindow{}
+	err = compositeBuckets(esClient, esIndexName, filter.Query(), sources, aggs, func(bucket gjson.Result) error {
+		id := bucket.Get("key.network_interface_id").String()
+		windows[id] = append(windows[id], captureWindow{
+			objectID:         bucket.Get("key.object").String(),
+			start:            time.Unix(0, bucket.Get("key.capture_start_time").Int()*int64(time.Millisecond)).UTC(),
+			end:              time.Unix(0, bucket.Get("key.capture_end_time").Int()*int64(time.Millisecond)).UTC(),
+			state:            bucket.Get("state.buckets.0.key").String(),
+			numberOfFlowLogs: bucket.Get("number_of_flow_logs.value").Int(),
+			documents:        bucket.Get("doc_count").Int() - bucket.Get("empty.doc_count").Int(),
+		})
+		return nil
+	})
+	if err != nil {
+		logger.ErrorLogger.Error("Error getting response from search", zap.Error(err))
+		return err
+	}
+
+	report := auditReport{Interfaces: []*interfaceAudit{}, Findings: []*auditFinding{}}
+	for id, list := range windows {
+		summary, findings := auditWindows(id, list, options.Tolerance)
+		report.Interfaces = append(report.Interfaces, summary)
+		report.Findings = append(report.Findings, findings...)
+	}
+	sort.Slice(report.Interfaces, func(i, j int) bool {
+		return report.Interfaces[i].NetworkInterfaceID < report.Interfaces[j].NetworkInterfaceID
+	})
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		if report.Findings[i].NetworkInterfaceID != report.Findings[j].NetworkInterfaceID {
+			return report.Findings[i].NetworkInterfaceID < report.Findings[j].NetworkInterfaceID
+		}
+		return report.Findings[i].Start < report.Findings[j].Start
+	})
+
+	logger.SystemLogger.Info("Audited the interfaces.", zap.Int("interfaces", len(report.Interfaces)), zap.Int("findings", len(report.Findings)))
+
+	var rows []csvRower
+	for _, f := range report.Findings {
+		rows = append(rows, f)
+	}
+	return printResults(options.Format, auditFindingColumns, report, rows)
+}
+
+// auditWindows orders the capture windows of an interface and compares each window with the previous one.
+func auditWindows(id string, windows []captureWindow, tolerance time.Duration) (*interfaceAudit, []*auditFinding) {
+	sort.Slice(windows, func(i, j int) bool {
+		if !windows[i].start.Equal(windows[j].start) {
+			return windows[i].start.Before(windows[j].start)
+		}
+		return windows[i].end.Before(windows[j].end)
+	})
+
+	format := func(t time.Time) string { return t.Format(time.RFC3339) }
+	summary := &interfaceAudit{
+		NetworkInterfaceID: id,
+		FirstCapture:       format(windows[0].start),
+		Windows:            len(windows),
+	}
+
+	var findings []*auditFinding
+	var last *captureWindow
+	for i := range windows {
+		w := &windows[i]
+
+		if w.numberOfFlowLogs != w.documents {
+			summary.CountMismatches++
+			findings = append(findings, &auditFinding{
+				Type: "count_mismatch", NetworkInterfaceID: id, Start: format(w.start), End: format(w.end),
+				ObjectID: w.objectID, State: w.state, NumberOfFlowLogs: w.numberOfFlowLogs, IndexedDocuments: w.documents,
+			})
+		}
+		if w.state != "" && w.state != "ok" {
+			summary.NotOk++
+			findings = append(findings, &auditFinding{
+				Type: "state", NetworkInterfaceID: id, Start: format(w.start), End: format(w.end), ObjectID: w.objectID, State: w.state,
+			})
+		}
+
+		if last != nil {
+			switch {
+			case w.start.Equal(last.start) && w.end.Equal(last.end):
+				summary.Duplicates++
+				findings = append(findings, &auditFinding{
+					Type: "duplicate", NetworkInterfaceID: id, Start: format(w.start), End: format(w.end),
+					ObjectID: w.objectID, OtherObjectID: last.objectID,
+				})
+			case w.start.Sub(last.end) > tolerance:
+				gap := w.start.Sub(last.end)
+				summary.Gaps++
+				summary.GapSeconds += int64(gap.Seconds())
+				findings = append(findings, &auditFinding{
+					Type: "gap", NetworkInterfaceID: id, Start: format(last.end), End: format(w.start),
+					DurationSeconds: gap.Seconds(), ObjectID: w.objectID, OtherObjectID: last.objectID,
+				})
+			case last.end.Sub(w.start) > tolerance:
+				overlapEnd := last.end
+				if w.end.Before(overlapEnd) {
+					overlapEnd = w.end
+				}
+				summary.Overlaps++
+				findings = append(findings, &auditFinding{
+					Type: "overlap", NetworkInterfaceID: id, Start: format(w.start), End: format(overlapEnd),
+					DurationSeconds: overlapEnd.Sub(w.start).Seconds(), ObjectID: w.objectID, OtherObjectID: last.objectID,
+				})
+			}
+		}
+
+		// The window reaching furthest is kept as reference so a window nested in a longer one is not reported as a gap.
+		if last == nil || w.end.After(last.end) {
+			last = w
+		}
+	}
+
+	summary.LastCapture = format(last.end)
+	return summary, findings
+}
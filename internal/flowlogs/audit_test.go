@@ -0,0 +1,132 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAuditWindows(t *testing.T) {
+	base := time.Date(2020, 12, 1, 10, 0, 0, 0, time.UTC)
+	window := func(id string, start int, end int, flows int64, documents int64, state string) captureWindow {
+		return captureWindow{
+			objectID:         id,
+			start:            base.Add(time.Duration(start) * time.Minute),
+			end:              base.Add(time.Duration(end) * time.Minute),
+			state:            state,
+			numberOfFlowLogs: flows,
+			documents:        documents,
+		}
+	}
+
+	tests := []struct {
+		name       string
+		windows    []captureWindow
+		summary    interfaceAudit
+		findings   []string
+		gapSeconds int64
+	}{
+		{
+			name:     "contiguous",
+			windows:  []captureWindow{window("b", 5, 10, 2, 2, "ok"), window("a", 0, 5, 1, 1, "ok")},
+			summary:  interfaceAudit{Windows: 2},
+			findings: nil,
+		},
+		{
+			name:     "empty object covers a quiet window",
+			windows:  []captureWindow{window("a", 0, 5, 1, 1, "ok"), window("b", 5, 10, 0, 0, "ok"), window("c", 10, 15, 3, 3, "ok")},
+			summary:  interfaceAudit{Windows: 3},
+			findings: nil,
+		},
+		{
+			name:     "within tolerance",
+			windows:  []captureWindow{window("a", 0, 5, 1, 1, "ok"), window("b", 6, 10, 1, 1, "ok")},
+			summary:  interfaceAudit{Windows: 2},
+			findings: nil,
+		},
+		{
+			name:       "gap",
+			windows:    []captureWindow{window("a", 0, 5, 1, 1, "ok"), window("b", 15, 20, 1, 1, "ok")},
+			summary:    interfaceAudit{Windows: 2, Gaps: 1},
+			findings:   []string{"gap"},
+			gapSeconds: 600,
+		},
+		{
+			name:     "overlap",
+			windows:  []captureWindow{window("a", 0, 10, 1, 1, "ok"), window("b", 5, 15, 1, 1, "ok")},
+			summary:  interfaceAudit{Windows: 2, Overlaps: 1},
+			findings: []string{"overlap"},
+		},
+		{
+			name:     "duplicate",
+			windows:  []captureWindow{window("a", 0, 5, 1, 1, "ok"), window("b", 0, 5, 1, 1, "ok")},
+			summary:  interfaceAudit{Windows: 2, Duplicates: 1},
+			findings: []string{"duplicate"},
+		},
+		{
+			name:     "nested window is not a gap",
+			windows:  []captureWindow{window("a", 0, 30, 1, 1, "ok"), window("b", 2, 10, 1, 1, "ok"), window("c", 30, 35, 1, 1, "ok")},
+			summary:  interfaceAudit{Windows: 3, Overlaps: 1},
+			findings: []string{"overlap"},
+		},
+		{
+			name:     "count mismatch and state",
+			windows:  []captureWindow{window("a", 0, 5, 3, 2, "skip_data")},
+			summary:  interfaceAudit{Windows: 1, CountMismatches: 1, NotOk: 1},
+			findings: []string{"count_mismatch", "state"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			summary, findings := auditWindows("vnic", test.windows, 2*time.Minute)
+
+			var types []string
+			for _, f := range findings {
+				types = append(types, f.Type)
+			}
+			if !reflect.DeepEqual(types, test.findings) {
+				t.Errorf("findings = %v, want %v", types, test.findings)
+			}
+
+			test.summary.NetworkInterfaceID = "vnic"
+			test.summary.GapSeconds = test.gapSeconds
+			test.summary.FirstCapture = summary.FirstCapture
+			test.summary.LastCapture = summary.LastCapture
+			if *summary != test.summary {
+				t.Errorf("summary = %+v, want %+v", *summary, test.summary)
+			}
+		})
+	}
+}
+
+func TestAuditWindowsCaptureRange(t *testing.T) {
+	base := time.Date(2020, 12, 1, 10, 0, 0, 0, time.UTC)
+	windows := []captureWindow{
+		{objectID: "b", start: base.Add(10 * time.Minute), end: base.Add(20 * time.Minute), state: "ok"},
+		{objectID: "a", start: base, end: base.Add(30 * time.Minute), state: "ok"},
+	}
+
+	summary, _ := auditWindows("vnic", windows, time.Minute)
+	if summary.FirstCapture != "2020-12-01T10:00:00Z" {
+		t.Errorf("FirstCapture = %s, want 2020-12-01T10:00:00Z", summary.FirstCapture)
+	}
+	if summary.LastCapture != "2020-12-01T10:30:00Z" {
+		t.Errorf("LastCapture = %s, want 2020-12-01T10:30:00Z", summary.LastCapture)
+	}
+}
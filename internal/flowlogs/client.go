@@ -0,0 +1,410 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+
+	"github.com/IBM/ibm-cos-sdk-go/aws"
+	"github.com/IBM/ibm-cos-sdk-go/aws/credentials/ibmiam"
+	"github.com/IBM/ibm-cos-sdk-go/aws/session"
+	"github.com/IBM/ibm-cos-sdk-go/service/s3"
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/config"
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/estransport"
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+const (
+	// scanPageSize is the number of documents read per search request when paging through results.
+	scanPageSize = 1000
+	// compositePageSize is the number of buckets read per search request when paging through a composite aggregation.
+	compositePageSize = 1000
+)
+
+// newElasticsearchClient returns a client for the configured elasticsearch cluster along with the name of the flow logs index.
+func newElasticsearchClient(trace bool) (*elasticsearch.Client, string, error) {
+	esClient, es, err := newElasticsearch(trace)
+	if err != nil {
+		return nil, "", err
+	}
+	return esClient, es.IndexName, nil
+}
+
+// newElasticsearch returns a client for the configured elasticsearch cluster along with its configuration, for the
+// commands using the indices other than the flow logs one.
+func newElasticsearch(trace bool) (*elasticsearch.Client, *config.Elasticsearch, error) {
+	cfg := config.Load()
+	if err := config.Error(cfg.Elasticsearch.Validate()); err != nil {
+		logger.ErrorLogger.Error("Error in the elasticsearch configuration.", zap.Error(err))
+		return nil, nil, err
+	}
+
+	esClient, err := openElasticsearch(&cfg.Elasticsearch, trace)
+	if err != nil {
+		return nil, nil, err
+	}
+	return esClient, &cfg.Elasticsearch, nil
+}
+
+// openElasticsearch creates the client of a validated elasticsearch configuration.
+func openElasticsearch(es *config.Elasticsearch, trace bool) (*elasticsearch.Client, error) {
+	cfg := elasticsearch.Config{
+		Addresses: []string{es.Address()},
+		Username:  es.Username,
+		Password:  es.Password,
+		CACert:    es.Certificate,
+	}
+
+	if trace {
+		cfg.Logger = &estransport.ColorLogger{
+			Output:             os.Stdout,
+			EnableRequestBody:  true,
+			EnableResponseBody: true,
+		}
+	}
+
+	esClient, err := elasticsearch.NewClient(cfg)
+	if err != nil {
+		logger.ErrorLogger.Error("Error creating elasticsearch client.", zap.String("address", es.Address()), zap.Error(err))
+		return nil, fmt.Errorf("elasticsearch.NewClient: %v", err)
+	}
+	return esClient, nil
+}
+
+// openCOS creates the client of a validated cos configuration.
+func openCOS(cfg *config.Config) *s3.S3 {
+	conf := aws.NewConfig().
+		WithRegion(cfg.COS.BucketsLocation).
+		WithEndpoint(cfg.COS.ServiceEndpoint).
+		WithCredentials(ibmiam.NewStaticCredentials(aws.NewConfig(), cfg.IBMCloud.IAMURL, cfg.COS.APIKey, cfg.COS.ResourceInstanceID)).
+		WithS3ForcePathStyle(true)
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		MaxRetries: aws.Int(3),
+	}))
+
+	return s3.New(sess, conf)
+}
+
+// searchBody runs the search request body against the index and returns the raw response body.
+func searchBody(esClient *elasticsearch.Client, esIndexName string, body interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(body); err != nil {
+		return nil, fmt.Errorf("json.Encode: %v", err)
+	}
+
+	res, err := esClient.Search(
+		esClient.Search.WithContext(context.Background()),
+		esClient.Search.WithIndex(esIndexName),
+		esClient.Search.WithBody(&buf),
+		esClient.Search.WithTrackTotalHits(true),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("esClient.Search: %v", err)
+	}
+	defer res.Body.Close()
+
+	response, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("ioutil.ReadAll: %v", err)
+	}
+
+	if res.IsError() {
+		return nil, fmt.Errorf("esClient.Search: %s: %s", res.Status(), gjson.GetBytes(response, "error.reason").String())
+	}
+
+	return response, nil
+}
+
+// scanHits pages through every document matching the query in capture_start_time order, starting after the given
+// sort values when resuming, and calls fn for each hit until fn returns an error. The document_id field breaks the
+// ties, it is first set on the documents indexed before it existed so each document has a unique sort.
+func scanHits(esClient *elasticsearch.Client, esIndexName string, query interface{}, searchAfter []interface{}, fn func(hit gjson.Result) error) error {
+	if err := backfillDocumentIDs(esClient, esIndexName); err != nil {
+		return err
+	}
+
+	sort := []interface{}{
+		map[string]interface{}{"capture_start_time": "asc"},
+		map[string]interface{}{"document_id": map[string]interface{}{"order": "asc", "unmapped_type": "keyword"}},
+	}
+	return scanHitsBy(esClient, esIndexName, query, sort, searchAfter, fn)
+}
+
+// backfillScript sets the document_id and object_id fields from the id of a document, the sha256 of the object key
+// suffixed with the position of the flow.
+const backfillScript = "ctx._source.document_id = ctx._id; int i = ctx._id.lastIndexOf('-'); " +
+	"if (ctx._source.object_id == null && i > 0) { ctx._source.object_id = ctx._id.substring(0, i); }"
+
+// backfillDocumentIDs sets document_id on the documents indexed before the field existed, without it they would tie in
+// the sort of scanHits and search_after could skip or repeat them. It does nothing once every document has it.
+func backfillDocumentIDs(esClient *elasticsearch.Client, esIndexName string) error {
+	missing := map[string]interface{}{
+		"bool": map[string]interface{}{
+			"must_not": map[string]interface{}{"exists": map[string]interface{}{"field": "document_id"}},
+		},
+	}
+	response, err := searchBody(esClient, esIndexName, map[string]interface{}{"size": 0, "query": missing})
+	if err != nil {
+		return err
+	}
+	count := gjson.GetBytes(response, "hits.total.value").Int()
+	if count == 0 {
+		return nil
+	}
+
+	logger.SystemLogger.Info("Setting document_id on the documents indexed before it existed.", zap.String("index", esIndexName), zap.Int64("documents", count))
+
+	// The fields are mapped first, dynamic mapping would make them text fields that cannot be sorted on.
+	res, err := esClient.Indices.PutMapping(strings.NewReader(`{"properties":{"document_id":{"type":"keyword"},"object_id":{"type":"keyword"}}}`),
+		esClient.Indices.PutMapping.WithIndex(esIndexName))
+	if err != nil {
+		return fmt.Errorf("esClient.Indices.PutMapping: %v", err)
+	}
+	res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("esClient.Indices.PutMapping: %v", res)
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"query":  missing,
+		"script": map[string]interface{}{"source": backfillScript, "lang": "painless"},
+	})
+	res, err = esClient.UpdateByQuery([]string{esIndexName},
+		esClient.UpdateByQuery.WithBody(bytes.NewReader(body)),
+		esClient.UpdateByQuery.WithConflicts("proceed"),
+		esClient.UpdateByQuery.WithRefresh(true),
+		esClient.UpdateByQuery.WithWaitForCompletion(true),
+	)
+	if err != nil {
+		return fmt.Errorf("esClient.UpdateByQuery: %v", err)
+	}
+	defer res.Body.Close()
+	result, _ := ioutil.ReadAll(res.Body)
+	if res.IsError() {
+		return fmt.Errorf("esClient.UpdateByQuery: %s: %s", res.Status(), gjson.GetBytes(result, "error.reason").String())
+	}
+	if failures := gjson.GetBytes(result, "failures.#").Int(); failures > 0 {
+		return fmt.Errorf("esClient.UpdateByQuery: %d documents not updated: %s", failures, gjson.GetBytes(result, "failures.0.cause.reason").String())
+	}
+
+	logger.SystemLogger.Info("Set document_id on the documents indexed before it existed.", zap.String("index", esIndexName), zap.Int64("documents", gjson.GetBytes(result, "updated").Int()))
+	return nil
+}
+
+// scanHitsBy is scanHits for indices whose documents are ordered by other fields, the sort must identify each
+// document for search_after to neither skip nor repeat any.
+func scanHitsBy(esClient *elasticsearch.Client, esIndexName string, query interface{}, sort []interface{}, searchAfter []interface{}, fn func(hit gjson.Result) error) error {
+	for {
+		body := map[string]interface{}{
+			"query": query,
+			"size":  scanPageSize,
+			"sort":  sort,
+		}
+		if searchAfter != nil {
+			body["search_after"] = searchAfter
+		}
+
+		response, err := searchBody(esClient, esIndexName, body)
+		if err != nil {
+			return err
+		}
+
+		hits := gjson.GetBytes(response, "hits.hits").Array()
+		if len(hits) == 0 {
+			return nil
+		}
+
+		for _, hit := range hits {
+			if err := fn(hit); err != nil {
+				return err
+			}
+		}
+		searchAfter = hits[len(hits)-1].Get("sort").Value().([]interface{})
+	}
+}
+
+// compositeBuckets pages through every bucket of a composite aggregation made of the sources and sub aggregations
+// and calls fn for each bucket until fn returns an error.
+func compositeBuckets(esClient *elasticsearch.Client, esIndexName string, query interface{}, sources []interface{}, aggs map[string]interface{}, fn func(bucket gjson.Result) error) error {
+	var after interface{}
+
+	for {
+		composite := map[string]interface{}{
+			"size":    compositePageSize,
+			"sources": sources,
+		}
+		if after != nil {
+			composite["after"] = after
+		}
+
+		groups := map[string]interface{}{"composite": composite}
+		if len(aggs) > 0 {
+			groups["aggs"] = aggs
+		}
+
+		body := map[string]interface{}{
+			"size":  0,
+			"query": query,
+			"aggs":  map[string]interface{}{"groups": groups},
+		}
+
+		response, err := searchBody(esClient, esIndexName, body)
+		if err != nil {
+			return err
+		}
+
+		buckets := gjson.GetBytes(response, "aggregations.groups.buckets").Array()
+		for _, bucket := range buckets {
+			if err := fn(bucket); err != nil {
+				return err
+			}
+		}
+
+		afterKey := gjson.GetBytes(response, "aggregations.groups.after_key")
+		if len(buckets) == 0 || !afterKey.Exists() {
+			return nil
+		}
+		after = afterKey.Value()
+	}
+}
+
+// compositeSource returns a terms source of a composite aggregation.
+func compositeSource(name string, field string) map[string]interface{} {
+	return map[string]interface{}{
+		name: map[string]interface{}{"terms": map[string]interface{}{"field": field}},
+	}
+}
+
+// compositeWindow returns a date histogram source of a composite aggregation bucketing the flows per interval.
+func compositeWindow(name string, field string, interval string) map[string]interface{} {
+	return map[string]interface{}{
+		name: map[string]interface{}{
+			"date_histogram": map[string]interface{}{"field": field, "fixed_interval": interval, "format": "strict_date_time"},
+		},
+	}
+}
+
+// ensureIndex creates the index with the mapping read from the config directory when it does not exist yet.
+func ensureIndex(esClient *elasticsearch.Client, esIndexName string, indexMapping string) error {
+	res, err := esClient.Indices.Exists([]string{esIndexName})
+	if err != nil {
+		return fmt.Errorf("esClient.Indices.Exists: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode == 200 {
+		return nil
+	}
+
+	mapping, err := ioutil.ReadFile("config/" + indexMapping)
+	if err != nil {
+		return fmt.Errorf("ioutil.ReadFile: %v", err)
+	}
+
+	res, err = esClient.Indices.Create(esIndexName, esClient.Indices.Create.WithBody(bytes.NewReader(mapping)))
+	if err != nil {
+		return fmt.Errorf("esClient.Indices.Create: %v", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("esClient.Indices.Create: %v", res)
+	}
+
+	logger.SystemLogger.Debug("Created a new index.", zap.String("index", esIndexName))
+	return nil
+}
+
+// updateMapping adds the fields of the mapping file missing from an existing index, i.e. the fields added since the
+// index was created. The typed mappings of elasticsearch 6 are left as is.
+func updateMapping(esClient *elasticsearch.Client, esIndexName string, indexMapping string) error {
+	mapping, err := ioutil.ReadFile("config/" + indexMapping)
+	if err != nil {
+		return fmt.Errorf("ioutil.ReadFile: %v", err)
+	}
+	properties := gjson.GetBytes(mapping, "mappings.properties")
+	if !properties.Exists() {
+		return nil
+	}
+
+	res, err := esClient.Indices.PutMapping(strings.NewReader(`{"properties":`+properties.Raw+`}`), esClient.Indices.PutMapping.WithIndex(esIndexName))
+	if err != nil {
+		return fmt.Errorf("esClient.Indices.PutMapping: %v", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("esClient.Indices.PutMapping: %v", res)
+	}
+	return nil
+}
+
+// bulkWrite indexes the documents keyed by their id and waits until they are all flushed.
+func bulkWrite(esClient *elasticsearch.Client, esIndexName string, documents map[string]interface{}) error {
+	var countFailures uint64
+
+	bi, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
+		Index:      esIndexName,
+		Client:     esClient,
+		NumWorkers: runtime.NumCPU(),
+		FlushBytes: int(5e+6),
+	})
+	if err != nil {
+		return fmt.Errorf("esutil.NewBulkIndexer: %v", err)
+	}
+
+	for id, document := range documents {
+		b, err := json.Marshal(document)
+		if err != nil {
+			return fmt.Errorf("json.Marshal: %v", err)
+		}
+
+		err = bi.Add(context.Background(), esutil.BulkIndexerItem{
+			Action:     "index",
+			DocumentID: id,
+			Body:       bytes.NewReader(b),
+			OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+				atomic.AddUint64(&countFailures, 1)
+				if err != nil {
+					logger.ErrorLogger.Error("Error indexing document.", zap.String("index", esIndexName), zap.String("document_id", item.DocumentID), zap.Error(err))
+				} else {
+					logger.ErrorLogger.Error("Error indexing document.", zap.String("index", esIndexName), zap.String("document_id", item.DocumentID), zap.String("error_type", res.Error.Type), zap.String("error_reason", res.Error.Reason))
+				}
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("bi.Add: %v", err)
+		}
+	}
+
+	if err := bi.Close(context.Background()); err != nil {
+		return fmt.Errorf("bi.Close: %v", err)
+	}
+	if countFailures > 0 {
+		return fmt.Errorf("%d of %d documents could not be written to %s", countFailures, len(documents), esIndexName)
+	}
+	return nil
+}
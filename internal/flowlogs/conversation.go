@@ -0,0 +1,255 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+// ConversationOptions holds the settings of a conversation between two endpoints.
+type ConversationOptions struct {
+	First  string
+	Second string
+	Filter string
+	From   string
+	To     string
+	Limit  int
+	Format string
+}
+
+type conversationFlow struct {
+	ID                 string `json:"id"`
+	StartTime          string `json:"start_time"`
+	EndTime            string `json:"end_time"`
+	Direction          string `json:"direction"`
+	Action             string `json:"action"`
+	WasInitiated       bool   `json:"was_initiated"`
+	WasTerminated      bool   `json:"was_terminated"`
+	BytesFromInitiator int64  `json:"bytes_from_initiator"`
+	BytesFromTarget    int64  `json:"bytes_from_target"`
+}
+
+// connection groups the flows of a 5-tuple. The cumulative_* fields hold running totals since the connection started
+// so the largest value seen is the connection total, the bytes and packets seen in the window are summed separately.
+type connection struct {
+	InitiatorIP               string             `json:"initiator_ip"`
+	InitiatorPort             int64              `json:"initiator_port"`
+	TargetIP                  string             `json:"target_ip"`
+	TargetPort                int64              `json:"target_port"`
+	TransportProtocol         int64              `json:"transport_protocol"`
+	FirstSeen                 string             `json:"first_seen"`
+	LastSeen                  string             `json:"last_seen"`
+	Actions                   []string           `json:"actions"`
+	InitiatedInWindow         bool               `json:"initiated_in_window"`
+	TerminatedInWindow        bool               `json:"terminated_in_window"`
+	TotalBytesFromInitiator   int64              `json:"total_bytes_from_initiator"`
+	TotalPacketsFromInitiator int64              `json:"total_packets_from_initiator"`
+	TotalBytesFromTarget      int64              `json:"total_bytes_from_target"`
+	TotalPacketsFromTarget    int64              `json:"total_packets_from_target"`
+	WindowBytesFromInitiator  int64              `json:"window_bytes_from_initiator"`
+	WindowBytesFromTarget     int64              `json:"window_bytes_from_target"`
+	Flows                     []conversationFlow `json:"flows"`
+}
+
+var connectionColumns = []string{
+	"initiator_ip", "initiator_port", "target_ip", "target_port", "transport_protocol", "first_seen", "last_seen",
+	"actions", "initiated_in_window", "terminated_in_window", "total_bytes_from_initiator", "total_packets_from_initiator",
+	"total_bytes_from_target", "total_packets_from_target", "window_bytes_from_initiator", "window_bytes_from_target", "flows",
+}
+
+func (c *connection) csvRow() []string {
+	return []string{
+		c.InitiatorIP, strconv.FormatInt(c.InitiatorPort, 10), c.TargetIP, strconv.FormatInt(c.TargetPort, 10),
+		strconv.FormatInt(c.TransportProtocol, 10), c.FirstSeen, c.LastSeen, strings.Join(c.Actions, "|"),
+		strconv.FormatBool(c.InitiatedInWindow), strconv.FormatBool(c.TerminatedInWindow),
+		strconv.FormatInt(c.TotalBytesFromInitiator, 10), strconv.FormatInt(c.TotalPacketsFromInitiator, 10),
+		strconv.FormatInt(c.TotalBytesFromTarget, 10), strconv.FormatInt(c.TotalPacketsFromTarget, 10),
+		strconv.FormatInt(c.WindowBytesFromInitiator, 10), strconv.FormatInt(c.WindowBytesFromTarget, 10),
+		strconv.Itoa(len(c.Flows)),
+	}
+}
+
+// Conversation function, returns the exit code of the command: 0 on success and 1 when it failed.
+func Conversation(options ConversationOptions, trace bool) int {
+	err := conversation(options, trace)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+func conversation(options ConversationOptions, trace bool) error {
+	for _, endpoint := range []string{options.First, options.Second} {
+		if err := validateIPOrCIDR(endpoint); err != nil {
+			return err
+		}
+	}
+	if err := validateFormat(options.Format); err != nil {
+		return err
+	}
+
+	filter, err := ParseFilter(options.Filter, options.From, options.To)
+	if err != nil {
+		return err
+	}
+
+	esClient, esIndexName, err := newElasticsearchClient(trace)
+	if err != nil {
+		return err
+	}
+
+	direction := func(initiator string, target string) map[string]interface{} {
+		return map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					termClause(filterFields["initiator_ip"], initiator),
+					termClause(filterFields["target_ip"], target),
+				},
+			},
+		}
+	}
+
+	query := map[string]interface{}{
+		"bool": map[string]interface{}{
+			"filter": []interface{}{
+				filter.Query(),
+				map[string]interface{}{
+					"bool": map[string]interface{}{
+						"should": []interface{}{
+							direction(options.First, options.Second),
+							direction(options.Second, options.First),
+						},
+						"minimum_should_match": 1,
+					},
+				},
+			},
+		},
+	}
+
+	connections := map[string]*connection{}
+	count := 0
+
+	err = scanHits(esClient, esIndexName, query, nil, func(hit gjson.Result) error {
+		count++
+		if options.Limit > 0 && count > options.Limit {
+			return fmt.Errorf("more than %d flows found between %s and %s, narrow the time window or raise the limit", options.Limit, options.First, options.Second)
+		}
+
+		r := newFlowRecord(hit)
+		tuple := fmt.Sprintf("%s:%d-%s:%d/%d", r.InitiatorIP, r.InitiatorPort, r.TargetIP, r.TargetPort, r.TransportProtocol)
+
+		c, ok := connections[tuple]
+		if !ok {
+			c = &connection{
+				InitiatorIP:       r.InitiatorIP,
+				InitiatorPort:     r.InitiatorPort,
+				TargetIP:          r.TargetIP,
+				TargetPort:        r.TargetPort,
+				TransportProtocol: r.TransportProtocol,
+				FirstSeen:         r.StartTime,
+				LastSeen:          r.EndTime,
+			}
+			connections[tuple] = c
+		}
+		c.add(r)
+		return nil
+	})
+	if err != nil {
+		logger.ErrorLogger.Error("Error getting response from search", zap.Error(err))
+		return err
+	}
+
+	results := []*connection{}
+	for _, c := range connections {
+		sort.Slice(c.Flows, func(i, j int) bool { return c.Flows[i].StartTime < c.Flows[j].StartTime })
+		sort.Strings(c.Actions)
+		results = append(results, c)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].FirstSeen < results[j].FirstSeen })
+
+	var rows []csvRower
+	for _, c := range results {
+		rows = append(rows, c)
+	}
+	return printResults(options.Format, connectionColumns, results, rows)
+}
+
+func (c *connection) add(r flowRecord) {
+	c.Flows = append(c.Flows, conversationFlow{
+		ID:                 r.ID,
+		StartTime:          r.StartTime,
+		EndTime:            r.EndTime,
+		Direction:          r.Direction,
+		Action:             r.Action,
+		WasInitiated:       r.WasInitiated,
+		WasTerminated:      r.WasTerminated,
+		BytesFromInitiator: r.BytesFromInitiator,
+		BytesFromTarget:    r.BytesFromTarget,
+	})
+
+	if r.StartTime < c.FirstSeen {
+		c.FirstSeen = r.StartTime
+	}
+	if r.EndTime > c.LastSeen {
+		c.LastSeen = r.EndTime
+	}
+
+	found := false
+	for _, action := range c.Actions {
+		if action == r.Action {
+			found = true
+		}
+	}
+	if !found {
+		c.Actions = append(c.Actions, r.Action)
+	}
+
+	c.InitiatedInWindow = c.InitiatedInWindow || r.WasInitiated
+	c.TerminatedInWindow = c.TerminatedInWindow || r.WasTerminated
+	c.TotalBytesFromInitiator = maxInt64(c.TotalBytesFromInitiator, r.CumulativeBytesFromInitiator)
+	c.TotalPacketsFromInitiator = maxInt64(c.TotalPacketsFromInitiator, r.CumulativePacketsFromInitiator)
+	c.TotalBytesFromTarget = maxInt64(c.TotalBytesFromTarget, r.CumulativeBytesFromTarget)
+	c.TotalPacketsFromTarget = maxInt64(c.TotalPacketsFromTarget, r.CumulativePacketsFromTarget)
+	c.WindowBytesFromInitiator += r.BytesFromInitiator
+	c.WindowBytesFromTarget += r.BytesFromTarget
+}
+
+// validateIPOrCIDR returns an error unless value is an IP address or a CIDR block.
+func validateIPOrCIDR(value string) error {
+	if net.ParseIP(value) != nil {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(value); err == nil {
+		return nil
+	}
+	return fmt.Errorf("invalid endpoint %q, expecting an IP address or a CIDR block", value)
+}
+
+func maxInt64(a int64, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,263 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+// PrivateCIDRs are the destinations that are not considered public: RFC 1918, shared address space, loopback,
+// link local and the IBM Cloud service and private endpoint networks.
+var PrivateCIDRs = []string{
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"161.26.0.0/16",
+	"166.8.0.0/14",
+}
+
+// ExfilOptions holds the settings of the outbound volume detection.
+type ExfilOptions struct {
+	Filter        string
+	From          string
+	To            string
+	BaselineHours int
+	Factor        float64
+	MinBytes      int64
+	PrivateCIDRs  []string
+	Top           int
+}
+
+type exfilDestination struct {
+	TargetIP string `json:"target_ip"`
+	Bytes    int64  `json:"bytes"`
+	Flows    int64  `json:"flows"`
+}
+
+// exfilFinding is an hour during which an instance sent more bytes to public destinations than the average of the
+// previous baseline hours multiplied by the factor, the ratio is 0 when the instance sent nothing during the baseline.
+type exfilFinding struct {
+	InstanceCrn  string             `json:"instance_crn"`
+	Hour         string             `json:"hour"`
+	Bytes        int64              `json:"bytes"`
+	Baseline     float64            `json:"baseline"`
+	Ratio        float64            `json:"ratio"`
+	Destinations []exfilDestination `json:"destinations"`
+}
+
+type exfilHour struct {
+	hour     time.Time
+	bytes    int64
+	inWindow bool
+}
+
+// DetectExfil function, returns the exit code of the command: 0 on success and 1 when it failed.
+func DetectExfil(options ExfilOptions, trace bool) int {
+	err := detectExfil(options, trace)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+func detectExfil(options ExfilOptions, trace bool) error {
+	if options.BaselineHours <= 0 {
+		return fmt.Errorf("invalid baseline hours %d", options.BaselineHours)
+	}
+	if options.Factor <= 1 {
+		return fmt.Errorf("invalid factor %v, expecting a value above 1", options.Factor)
+	}
+	if options.Top <= 0 {
+		return fmt.Errorf("invalid number of destinations %d", options.Top)
+	}
+	if options.From == "" {
+		return fmt.Errorf("the start of the time window is required")
+	}
+	for _, cidr := range options.PrivateCIDRs {
+		if err := validateIPOrCIDR(cidr); err != nil {
+			return err
+		}
+	}
+
+	window, err := ParseFilter(options.Filter, options.From, options.To)
+	if err != nil {
+		return err
+	}
+	// The hours before the window are read as well so the first hours of the window have a baseline.
+	learning, _ := ParseFilter(options.Filter, subtractHours(options.From, options.BaselineHours), options.To)
+
+	esClient, esIndexName, err := newElasticsearchClient(trace)
+	if err != nil {
+		return err
+	}
+
+	var private []interface{}
+	for _, cidr := range options.PrivateCIDRs {
+		private = append(private, termClause(filterFields["target_ip"], cidr))
+	}
+
+	outbound := func(filter *Filter) map[string]interface{} {
+		return map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					filter.Query(),
+					termClause(filterFields["direction"], "outbound"),
+				},
+				"must_not": private,
+			},
+		}
+	}
+
+	sources := []interface{}{
+		compositeSource("instance_crn", filterFields["instance_crn"]),
+		compositeWindow("hour", "capture_start_time", "1h"),
+	}
+	aggs := map[string]interface{}{
+		"bytes":     map[string]interface{}{"sum": map[string]interface{}{"field": "flow_logs.bytes_from_initiator"}},
+		"in_window": map[string]interface{}{"filter": window.Query()},
+	}
+
+	hours := map[string][]exfilHour{}
+	err = compositeBuckets(esClient, esIndexName, outbound(learning), sources, aggs, func(bucket gjson.Result) error {
+		hour, err := time.Parse(time.RFC3339Nano, bucket.Get("key.hour").String())
+		if err != nil {
+			return fmt.Errorf("time.Parse: %v", err)
+		}
+		instance := bucket.Get("key.instance_crn").String()
+		hours[instance] = append(hours[instance], exfilHour{
+			hour:     hour,
+			bytes:    bucket.Get("bytes.value").Int(),
+			inWindow: bucket.Get("in_window.doc_count").Int() > 0,
+		})
+		return nil
+	})
+	if err != nil {
+		logger.ErrorLogger.Error("Error getting response from search", zap.Error(err))
+		return err
+	}
+
+	findings := []exfilFinding{}
+	for instance, series := range hours {
+		for _, finding := range exfilSpikes(series, options) {
+			finding.InstanceCrn = instance
+			findings = append(findings, finding)
+		}
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].Hour == findings[j].Hour {
+			return findings[i].Ratio > findings[j].Ratio
+		}
+		return findings[i].Hour < findings[j].Hour
+	})
+
+	for i := range findings {
+		hour, _ := time.Parse(time.RFC3339, findings[i].Hour)
+		spike, _ := ParseFilter(options.Filter, hour.Format(time.RFC3339), hour.Add(time.Hour).Format(time.RFC3339))
+		spike.Terms = append(spike.Terms, filterTerm{Field: "instance_crn", Values: []string{findings[i].InstanceCrn}})
+
+		body := map[string]interface{}{
+			"size":  0,
+			"query": outbound(spike),
+			"aggs": map[string]interface{}{
+				"destinations": map[string]interface{}{
+					"terms": map[string]interface{}{
+						"field": "flow_logs.target_ip.keyword",
+						"size":  options.Top,
+						"order": map[string]interface{}{"bytes": "desc"},
+					},
+					"aggs": map[string]interface{}{
+						"bytes": map[string]interface{}{"sum": map[string]interface{}{"field": "flow_logs.bytes_from_initiator"}},
+					},
+				},
+			},
+		}
+
+		response, err := searchBody(esClient, esIndexName, body)
+		if err != nil {
+			logger.ErrorLogger.Error("Error getting response from search", zap.Error(err))
+			return err
+		}
+
+		findings[i].Destinations = []exfilDestination{}
+		gjson.GetBytes(response, "aggregations.destinations.buckets").ForEach(func(_, bucket gjson.Result) bool {
+			findings[i].Destinations = append(findings[i].Destinations, exfilDestination{
+				TargetIP: bucket.Get("key").String(),
+				Bytes:    bucket.Get("bytes.value").Int(),
+				Flows:    bucket.Get("doc_count").Int(),
+			})
+			return true
+		})
+	}
+
+	logger.SystemLogger.Info("Found outbound volume spikes.", zap.Int("spikes", len(findings)))
+
+	return printResults("json", nil, findings, nil)
+}
+
+// exfilSpikes returns the hours of the window whose bytes exceed the average of the previous baseline hours by the
+// factor, hours without flows are missing from the aggregation and count as zero in the baseline.
+func exfilSpikes(series []exfilHour, options ExfilOptions) []exfilFinding {
+	byHour := map[int64]int64{}
+	for _, h := range series {
+		byHour[h.hour.Unix()] = h.bytes
+	}
+
+	var findings []exfilFinding
+	for _, h := range series {
+		if !h.inWindow || h.bytes < options.MinBytes {
+			continue
+		}
+
+		var total int64
+		for i := 1; i <= options.BaselineHours; i++ {
+			total += byHour[h.hour.Add(-time.Duration(i)*time.Hour).Unix()]
+		}
+		baseline := float64(total) / float64(options.BaselineHours)
+
+		if float64(h.bytes) > baseline*options.Factor {
+			ratio := 0.0
+			if baseline > 0 {
+				ratio = float64(h.bytes) / baseline
+			}
+			findings = append(findings, exfilFinding{
+				Hour:     h.hour.UTC().Format(time.RFC3339),
+				Bytes:    h.bytes,
+				Baseline: baseline,
+				Ratio:    ratio,
+			})
+		}
+	}
+	return findings
+}
+
+// subtractHours moves an elasticsearch date math expression back by the number of hours.
+func subtractHours(date string, hours int) string {
+	if strings.HasPrefix(date, "now") || strings.Contains(date, "||") {
+		return fmt.Sprintf("%s-%dh", date, hours)
+	}
+	return fmt.Sprintf("%s||-%dh", date, hours)
+}
@@ -0,0 +1,82 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExfilSpikes(t *testing.T) {
+	base := time.Date(2020, 12, 1, 0, 0, 0, 0, time.UTC)
+	hour := func(i int, bytes int64, inWindow bool) exfilHour {
+		return exfilHour{hour: base.Add(time.Duration(i) * time.Hour), bytes: bytes, inWindow: inWindow}
+	}
+	options := ExfilOptions{BaselineHours: 4, Factor: 3, MinBytes: 100}
+
+	tests := []struct {
+		name     string
+		series   []exfilHour
+		findings []exfilFinding
+	}{
+		{
+			name:   "steady",
+			series: []exfilHour{hour(0, 200, false), hour(1, 200, false), hour(2, 200, false), hour(3, 200, false), hour(4, 250, true)},
+		},
+		{
+			name:   "spike over the baseline",
+			series: []exfilHour{hour(0, 200, false), hour(1, 200, false), hour(2, 200, false), hour(3, 200, false), hour(4, 1000, true)},
+			findings: []exfilFinding{
+				{Hour: "2020-12-01T04:00:00Z", Bytes: 1000, Baseline: 200, Ratio: 5},
+			},
+		},
+		{
+			name:   "missing hours count as zero",
+			series: []exfilHour{hour(2, 400, false), hour(4, 500, true)},
+			findings: []exfilFinding{
+				{Hour: "2020-12-01T04:00:00Z", Bytes: 500, Baseline: 100, Ratio: 5},
+			},
+		},
+		{
+			name:   "nothing sent during the baseline",
+			series: []exfilHour{hour(4, 500, true)},
+			findings: []exfilFinding{
+				{Hour: "2020-12-01T04:00:00Z", Bytes: 500},
+			},
+		},
+		{
+			name:   "below the minimum bytes",
+			series: []exfilHour{hour(4, 99, true)},
+		},
+		{
+			name:   "spike before the window",
+			series: []exfilHour{hour(3, 5000, false), hour(4, 100, true)},
+		},
+		{
+			name:   "exactly the factor",
+			series: []exfilHour{hour(0, 400, false), hour(4, 300, true)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if findings := exfilSpikes(test.series, options); !reflect.DeepEqual(findings, test.findings) {
+				t.Errorf("exfilSpikes = %+v, want %+v", findings, test.findings)
+			}
+		})
+	}
+}
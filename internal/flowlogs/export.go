@@ -0,0 +1,351 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+const (
+	exportStateFile    = "export.state.json"
+	exportManifestFile = "manifest.json"
+)
+
+// ExportOptions holds the settings of an export.
+type ExportOptions struct {
+	QueryName   string
+	Filter      string
+	From        string
+	To          string
+	Format      string
+	Directory   string
+	MaxFileSize int64
+}
+
+type exportFile struct {
+	Name      string `json:"name"`
+	Documents int64  `json:"documents"`
+	Bytes     int64  `json:"bytes"`
+	Sha256    string `json:"sha256"`
+}
+
+// exportManifest describes the files of an export. While the export is running it is saved as the state file along
+// with the sort values of the last document written to a completed file, so an interrupted export resumes from there.
+type exportManifest struct {
+	Index       string          `json:"index"`
+	QueryName   string          `json:"query_name,omitempty"`
+	Filter      string          `json:"filter,omitempty"`
+	Query       json.RawMessage `json:"query"`
+	Format      string          `json:"format"`
+	StartedAt   string          `json:"started_at"`
+	CompletedAt string          `json:"completed_at,omitempty"`
+	Documents   int64           `json:"documents"`
+	Files       []exportFile    `json:"files"`
+	SearchAfter []interface{}   `json:"search_after,omitempty"`
+}
+
+// Export function, returns the exit code of the command: 0 on success and 1 when it failed.
+func Export(options ExportOptions, trace bool) int {
+	err := export(options, trace)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+func export(options ExportOptions, trace bool) error {
+	if options.Format != "ndjson" && options.Format != "csv" {
+		return fmt.Errorf("invalid format %s, expecting ndjson or csv", options.Format)
+	}
+	if options.MaxFileSize <= 0 {
+		return fmt.Errorf("invalid maximum file size %d", options.MaxFileSize)
+	}
+
+	query, err := exportQuery(options)
+	if err != nil {
+		return err
+	}
+
+	esClient, esIndexName, err := newElasticsearchClient(trace)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(options.Directory, 0755); err != nil {
+		return fmt.Errorf("os.MkdirAll: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(options.Directory, exportManifestFile)); err == nil {
+		return fmt.Errorf("%s already contains a completed export, use another directory", options.Directory)
+	}
+
+	manifest, err := loadExportState(options.Directory)
+	if err != nil {
+		return err
+	}
+
+	if manifest == nil {
+		manifest = &exportManifest{
+			Index:     esIndexName,
+			QueryName: options.QueryName,
+			Filter:    options.Filter,
+			Query:     query,
+			Format:    options.Format,
+			StartedAt: time.Now().UTC().Format(time.RFC3339),
+		}
+	} else {
+		if !bytes.Equal(manifest.Query, query) || manifest.Format != options.Format || manifest.Index != esIndexName {
+			return fmt.Errorf("%s contains an interrupted export of a different query or format, use another directory", options.Directory)
+		}
+		logger.SystemLogger.Info("Resuming export.", zap.String("dir", options.Directory), zap.Int64("documents", manifest.Documents), zap.Int("files", len(manifest.Files)))
+	}
+
+	var part *exportPart
+	searchAfter := manifest.SearchAfter
+
+	err = scanHits(esClient, esIndexName, json.RawMessage(query), manifest.SearchAfter, func(hit gjson.Result) error {
+		if part == nil {
+			part, err = newExportPart(options.Directory, options.Format, len(manifest.Files)+1)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := part.write(newFlowRecord(hit)); err != nil {
+			return err
+		}
+		searchAfter = hit.Get("sort").Value().([]interface{})
+
+		if part.size() >= options.MaxFileSize {
+			if err := completeExportPart(part, manifest, searchAfter, options.Directory); err != nil {
+				return err
+			}
+			part = nil
+		}
+		return nil
+	})
+	if err != nil {
+		if part != nil {
+			part.abort()
+		}
+		logger.ErrorLogger.Error("Error exporting documents", zap.Error(err))
+		return err
+	}
+
+	if part != nil {
+		if err := completeExportPart(part, manifest, searchAfter, options.Directory); err != nil {
+			return err
+		}
+	}
+
+	manifest.SearchAfter = nil
+	manifest.CompletedAt = time.Now().UTC().Format(time.RFC3339)
+
+	b, _ := json.MarshalIndent(manifest, "", "  ")
+	if err := ioutil.WriteFile(filepath.Join(options.Directory, exportManifestFile), b, 0644); err != nil {
+		return fmt.Errorf("ioutil.WriteFile: %v", err)
+	}
+	os.Remove(filepath.Join(options.Directory, exportStateFile))
+
+	logger.SystemLogger.Info("Exported the documents.", zap.String("dir", options.Directory), zap.Int64("documents", manifest.Documents), zap.Int("files", len(manifest.Files)))
+	fmt.Println(string(b))
+
+	return nil
+}
+
+// exportQuery returns the query selecting the documents to export, made of the query of the saved query and the filter.
+func exportQuery(options ExportOptions) (json.RawMessage, error) {
+	var clauses []interface{}
+
+	if options.QueryName != "" {
+		saved := savedQuery(loadQueries(), options.QueryName)
+		if !saved.Exists() {
+			return nil, fmt.Errorf("query %s not found in config/queries.json", options.QueryName)
+		}
+		if q := saved.Get("command.query"); q.Exists() {
+			clauses = append(clauses, json.RawMessage(q.Raw))
+		}
+	}
+
+	filter, err := ParseFilter(options.Filter, options.From, options.To)
+	if err != nil {
+		return nil, err
+	}
+	clauses = append(clauses, filter.Query())
+
+	query, err := json.Marshal(map[string]interface{}{
+		"bool": map[string]interface{}{"filter": clauses},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %v", err)
+	}
+	return query, nil
+}
+
+func loadExportState(directory string) (*exportManifest, error) {
+	b, err := ioutil.ReadFile(filepath.Join(directory, exportStateFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ioutil.ReadFile: %v", err)
+	}
+
+	var manifest exportManifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid export state in %s: %v", directory, err)
+	}
+	return &manifest, nil
+}
+
+// completeExportPart closes the file and saves the state so that an interrupted export does not write it again.
+func completeExportPart(part *exportPart, manifest *exportManifest, searchAfter []interface{}, directory string) error {
+	file, err := part.close()
+	if err != nil {
+		return err
+	}
+
+	manifest.Files = append(manifest.Files, file)
+	manifest.Documents += file.Documents
+	manifest.SearchAfter = searchAfter
+
+	b, _ := json.MarshalIndent(manifest, "", "  ")
+	tmp := filepath.Join(directory, exportStateFile+".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return fmt.Errorf("ioutil.WriteFile: %v", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(directory, exportStateFile)); err != nil {
+		return fmt.Errorf("os.Rename: %v", err)
+	}
+
+	logger.SystemLogger.Info("Exported file.", zap.String("file", file.Name), zap.Int64("documents", file.Documents))
+	return nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// exportPart is a gzip compressed file of the export, the size and sha256 are computed as the compressed bytes are written.
+type exportPart struct {
+	name      string
+	file      *os.File
+	hash      hash.Hash
+	counter   *countingWriter
+	gzip      *gzip.Writer
+	csv       *csv.Writer
+	documents int64
+}
+
+func newExportPart(directory string, format string, number int) (*exportPart, error) {
+	name := fmt.Sprintf("flowlogs-%05d.%s.gz", number, format)
+
+	file, err := os.Create(filepath.Join(directory, name))
+	if err != nil {
+		return nil, fmt.Errorf("os.Create: %v", err)
+	}
+
+	part := &exportPart{
+		name:    name,
+		file:    file,
+		hash:    sha256.New(),
+		counter: &countingWriter{},
+	}
+	part.gzip = gzip.NewWriter(io.MultiWriter(file, part.hash, part.counter))
+
+	if format == "csv" {
+		part.csv = csv.NewWriter(part.gzip)
+		if err := part.csv.Write(flowRecordColumns); err != nil {
+			part.abort()
+			return nil, fmt.Errorf("csv.Write: %v", err)
+		}
+	}
+
+	return part, nil
+}
+
+func (p *exportPart) write(record flowRecord) error {
+	p.documents++
+
+	if p.csv != nil {
+		if err := p.csv.Write(record.csvRow()); err != nil {
+			return fmt.Errorf("csv.Write: %v", err)
+		}
+		return nil
+	}
+
+	b, _ := json.Marshal(record)
+	if _, err := p.gzip.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("gzip.Write: %v", err)
+	}
+	return nil
+}
+
+func (p *exportPart) size() int64 {
+	return p.counter.n
+}
+
+func (p *exportPart) close() (exportFile, error) {
+	if p.csv != nil {
+		p.csv.Flush()
+		if err := p.csv.Error(); err != nil {
+			p.abort()
+			return exportFile{}, fmt.Errorf("csv.Flush: %v", err)
+		}
+	}
+	if err := p.gzip.Close(); err != nil {
+		p.abort()
+		return exportFile{}, fmt.Errorf("gzip.Close: %v", err)
+	}
+	if err := p.file.Close(); err != nil {
+		return exportFile{}, fmt.Errorf("os.File.Close: %v", err)
+	}
+
+	return exportFile{
+		Name:      p.name,
+		Documents: p.documents,
+		Bytes:     p.counter.n,
+		Sha256:    fmt.Sprintf("%x", p.hash.Sum(nil)),
+	}, nil
+}
+
+// abort closes and removes an incomplete file, a resumed export writes it again from the saved state.
+func (p *exportPart) abort() {
+	p.gzip.Close()
+	p.file.Close()
+	os.Remove(p.file.Name())
+}
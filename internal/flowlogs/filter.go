@@ -0,0 +1,258 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// filterFields maps the names accepted in a filter expression to the fields of the flow logs index.
+var filterFields = map[string]string{
+	"version":                "version.keyword",
+	"collector_crn":          "collector_crn.keyword",
+	"attached_endpoint_type": "attached_endpoint_type.keyword",
+	"network_interface_id":   "network_interface_id.keyword",
+	"instance_crn":           "instance_crn.keyword",
+	"vpc_crn":                "vpc_crn.keyword",
+	"state":                  "state.keyword",
+	"direction":              "flow_logs.direction.keyword",
+	"action":                 "flow_logs.action.keyword",
+	"ether_type":             "flow_logs.ether_type.keyword",
+	"initiator_ip":           "flow_logs.initiator_ip",
+	"target_ip":              "flow_logs.target_ip",
+	"initiator_port":         "flow_logs.initiator_port",
+	"target_port":            "flow_logs.target_port",
+	"transport_protocol":     "flow_logs.transport_protocol",
+	"was_initiated":          "flow_logs.was_initiated",
+	"was_terminated":         "flow_logs.was_terminated",
+	"ioc_matched":            "ioc.matched",
+	"ioc_source":             "ioc.sources",
+	"ioc_indicator":          "ioc.indicators",
+}
+
+// rangeFields can be given a range of values in the form low-high.
+var rangeFields = map[string]bool{
+	"initiator_port":     true,
+	"target_port":        true,
+	"transport_protocol": true,
+}
+
+// timeFields maps the names accepted for the time field of a filter to the fields of the flow logs index.
+var timeFields = map[string]string{
+	"capture_start_time": "capture_start_time",
+	"capture_end_time":   "capture_end_time",
+	"start_time":         "flow_logs.start_time",
+	"end_time":           "flow_logs.end_time",
+}
+
+type filterTerm struct {
+	Field  string
+	Values []string
+	Negate bool
+}
+
+// Filter is a small query language used to select flow logs from the command line. An expression is a comma separated
+// list of name=value or name!=value terms, where a value can list alternatives separated by | and the ports and
+// protocol accept low-high ranges, e.g. "direction=inbound,action=rejected,target_port=22|3389,initiator_ip=10.240.0.0/16".
+// The ip fields accept addresses or CIDR blocks, the special name ip matches either the initiator or the target.
+// The documents recording the objects holding no flow are left out unless EmptyObjects is set.
+type Filter struct {
+	Terms        []filterTerm
+	From         string
+	To           string
+	TimeField    string
+	EmptyObjects bool
+}
+
+// ParseFilter parses a filter expression along with an optional time window expressed in elasticsearch date math, i.e. now-24h.
+func ParseFilter(expression string, from string, to string) (*Filter, error) {
+	filter := &Filter{From: from, To: to, TimeField: "capture_start_time"}
+
+	for _, term := range strings.Split(expression, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		negate := false
+		index := strings.Index(term, "!=")
+		if index > 0 {
+			negate = true
+		} else {
+			index = strings.Index(term, "=")
+		}
+		if index <= 0 {
+			return nil, fmt.Errorf("invalid filter term %q, expecting name=value or name!=value", term)
+		}
+
+		name := strings.TrimSpace(term[:index])
+		value := strings.TrimSpace(term[index+1:])
+		if negate {
+			value = strings.TrimSpace(term[index+2:])
+		}
+
+		if _, ok := filterFields[name]; !ok && name != "ip" {
+			return nil, fmt.Errorf("invalid filter term %q, unknown field %s, expecting one of %s", term, name, strings.Join(FilterFieldNames(), ", "))
+		}
+		if value == "" {
+			return nil, fmt.Errorf("invalid filter term %q, missing value", term)
+		}
+
+		values := strings.Split(value, "|")
+		if rangeFields[name] {
+			for _, v := range values {
+				if _, _, err := parseRange(v); err != nil {
+					return nil, fmt.Errorf("invalid filter term %q, %v", term, err)
+				}
+			}
+		}
+
+		filter.Terms = append(filter.Terms, filterTerm{Field: name, Values: values, Negate: negate})
+	}
+
+	return filter, nil
+}
+
+// FilterFieldNames returns the sorted list of names that can be used in a filter expression.
+func FilterFieldNames() []string {
+	names := []string{"ip"}
+	for name := range filterFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetTimeField selects the date field the time window applies to, either capture_start_time, capture_end_time, start_time or end_time.
+func (f *Filter) SetTimeField(name string) error {
+	if _, ok := timeFields[name]; !ok {
+		return fmt.Errorf("invalid time field %s, expecting capture_start_time, capture_end_time, start_time or end_time", name)
+	}
+	f.TimeField = name
+	return nil
+}
+
+// TimeFieldPath returns the index field the time window applies to.
+func (f *Filter) TimeFieldPath() string {
+	return timeFields[f.TimeField]
+}
+
+// Query returns the elasticsearch bool query matching the filter.
+func (f *Filter) Query() map[string]interface{} {
+	var must []interface{}
+	var mustNot []interface{}
+
+	for _, term := range f.Terms {
+		var clauses []interface{}
+		for _, value := range term.Values {
+			if term.Field == "ip" {
+				clauses = append(clauses,
+					termClause(filterFields["initiator_ip"], value),
+					termClause(filterFields["target_ip"], value))
+				continue
+			}
+			if rangeFields[term.Field] {
+				low, high, _ := parseRange(value)
+				clauses = append(clauses, map[string]interface{}{
+					"range": map[string]interface{}{
+						filterFields[term.Field]: map[string]interface{}{"gte": low, "lte": high},
+					},
+				})
+				continue
+			}
+			clauses = append(clauses, termClause(filterFields[term.Field], value))
+		}
+
+		clause := map[string]interface{}{
+			"bool": map[string]interface{}{"should": clauses, "minimum_should_match": 1},
+		}
+		if term.Negate {
+			mustNot = append(mustNot, clause)
+		} else {
+			must = append(must, clause)
+		}
+	}
+
+	if f.From != "" || f.To != "" {
+		window := map[string]interface{}{}
+		if f.From != "" {
+			window["gte"] = f.From
+		}
+		if f.To != "" {
+			window["lt"] = f.To
+		}
+		must = append(must, map[string]interface{}{
+			"range": map[string]interface{}{f.TimeFieldPath(): window},
+		})
+	}
+
+	if !f.EmptyObjects {
+		mustNot = append(mustNot, map[string]interface{}{"term": map[string]interface{}{"empty": true}})
+	}
+
+	boolQuery := map[string]interface{}{}
+	if len(must) > 0 {
+		boolQuery["filter"] = must
+	}
+	if len(mustNot) > 0 {
+		boolQuery["must_not"] = mustNot
+	}
+	if len(boolQuery) == 0 {
+		return map[string]interface{}{"match_all": map[string]interface{}{}}
+	}
+
+	return map[string]interface{}{"bool": boolQuery}
+}
+
+// String returns the filter in its expression form, used to describe the filter in reports.
+func (f *Filter) String() string {
+	var terms []string
+	for _, term := range f.Terms {
+		operator := "="
+		if term.Negate {
+			operator = "!="
+		}
+		terms = append(terms, term.Field+operator+strings.Join(term.Values, "|"))
+	}
+	return strings.Join(terms, ",")
+}
+
+func termClause(field string, value string) map[string]interface{} {
+	return map[string]interface{}{
+		"term": map[string]interface{}{field: value},
+	}
+}
+
+func parseRange(value string) (int64, int64, error) {
+	parts := strings.SplitN(value, "-", 2)
+
+	low, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s is not a number or a low-high range", value)
+	}
+	if len(parts) == 1 {
+		return low, low, nil
+	}
+
+	high, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || high < low {
+		return 0, 0, fmt.Errorf("%s is not a number or a low-high range", value)
+	}
+	return low, high, nil
+}
@@ -0,0 +1,136 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		terms      []filterTerm
+		wantErr    bool
+	}{
+		{name: "empty"},
+		{
+			name:       "terms",
+			expression: "direction=inbound, action!=accepted,target_port=22|3389-3390,ip=10.240.0.0/16",
+			terms: []filterTerm{
+				{Field: "direction", Values: []string{"inbound"}},
+				{Field: "action", Values: []string{"accepted"}, Negate: true},
+				{Field: "target_port", Values: []string{"22", "3389-3390"}},
+				{Field: "ip", Values: []string{"10.240.0.0/16"}},
+			},
+		},
+		{name: "trailing comma", expression: "state=ok,", terms: []filterTerm{{Field: "state", Values: []string{"ok"}}}},
+		{name: "missing operator", expression: "direction", wantErr: true},
+		{name: "missing name", expression: "=inbound", wantErr: true},
+		{name: "unknown field", expression: "color=red", wantErr: true},
+		{name: "missing value", expression: "direction=", wantErr: true},
+		{name: "port not a number", expression: "target_port=ssh", wantErr: true},
+		{name: "reversed range", expression: "target_port=100-10", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter, err := ParseFilter(test.expression, "now-1h", "now")
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("ParseFilter(%q) = %+v, want an error", test.expression, filter)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFilter(%q): %v", test.expression, err)
+			}
+			if !reflect.DeepEqual(filter.Terms, test.terms) {
+				t.Errorf("ParseFilter(%q) terms = %+v, want %+v", test.expression, filter.Terms, test.terms)
+			}
+			if filter.From != "now-1h" || filter.To != "now" || filter.TimeField != "capture_start_time" || filter.EmptyObjects {
+				t.Errorf("ParseFilter(%q) = %+v, want the window on capture_start_time without the empty objects", test.expression, filter)
+			}
+		})
+	}
+}
+
+func TestFilterQuery(t *testing.T) {
+	const notEmpty = `"must_not":[{"term":{"empty":true}}]`
+
+	tests := []struct {
+		name         string
+		expression   string
+		from         string
+		to           string
+		emptyObjects bool
+		query        string
+	}{
+		{
+			name:  "no term",
+			query: `{"bool":{` + notEmpty + `}}`,
+		},
+		{
+			name:         "no term with the empty objects",
+			emptyObjects: true,
+			query:        `{"match_all":{}}`,
+		},
+		{
+			name:       "term and window",
+			expression: "direction=inbound",
+			from:       "now-1h",
+			to:         "now",
+			query: `{"bool":{"filter":[{"bool":{"minimum_should_match":1,"should":[{"term":{"flow_logs.direction.keyword":"inbound"}}]}},` +
+				`{"range":{"capture_start_time":{"gte":"now-1h","lt":"now"}}}],` + notEmpty + `}}`,
+		},
+		{
+			name:         "window with the empty objects",
+			from:         "now-1h",
+			emptyObjects: true,
+			query:        `{"bool":{"filter":[{"range":{"capture_start_time":{"gte":"now-1h"}}}]}}`,
+		},
+		{
+			name:       "negated range and ip",
+			expression: "target_port!=22|1000-2000,ip=10.0.0.1",
+			query: `{"bool":{"filter":[{"bool":{"minimum_should_match":1,"should":[{"term":{"flow_logs.initiator_ip":"10.0.0.1"}},{"term":{"flow_logs.target_ip":"10.0.0.1"}}]}}],` +
+				`"must_not":[{"bool":{"minimum_should_match":1,"should":[{"range":{"flow_logs.target_port":{"gte":22,"lte":22}}},{"range":{"flow_logs.target_port":{"gte":1000,"lte":2000}}}]}},` +
+				`{"term":{"empty":true}}]}}`,
+		},
+		{
+			name:         "negated term with the empty objects",
+			expression:   "action!=rejected",
+			emptyObjects: true,
+			query:        `{"bool":{"must_not":[{"bool":{"minimum_should_match":1,"should":[{"term":{"flow_logs.action.keyword":"rejected"}}]}}]}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter, err := ParseFilter(test.expression, test.from, test.to)
+			if err != nil {
+				t.Fatalf("ParseFilter(%q): %v", test.expression, err)
+			}
+			filter.EmptyObjects = test.emptyObjects
+
+			b, _ := json.Marshal(filter.Query())
+			if string(b) != test.query {
+				t.Errorf("Query = %s, want %s", b, test.query)
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+// GraphOptions holds the settings of the dependency graph.
+type GraphOptions struct {
+	Nodes    string
+	Filter   string
+	From     string
+	To       string
+	MinFlows int64
+	Format   string
+}
+
+type graphNode struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+	Type  string `json:"type"`
+}
+
+// graphEdge goes from the initiator to the target of the flows, for a target port and protocol.
+type graphEdge struct {
+	Source            string `json:"source"`
+	Target            string `json:"target"`
+	TargetPort        int64  `json:"target_port"`
+	TransportProtocol int64  `json:"transport_protocol"`
+	Flows             int64  `json:"flows"`
+	Bytes             int64  `json:"bytes"`
+}
+
+type graph struct {
+	Nodes []*graphNode `json:"nodes"`
+	Links []*graphEdge `json:"links"`
+}
+
+// graphFlow is a group of flows of an interface between an initiator and a target.
+type graphFlow struct {
+	instanceCrn string
+	direction   string
+	initiatorIP string
+	targetIP    string
+	targetPort  int64
+	protocol    int64
+	flows       int64
+	bytes       int64
+}
+
+// Graph function, returns the exit code of the command: 0 on success and 1 when it failed.
+func Graph(options GraphOptions, trace bool) int {
+	err := buildGraph(options, trace)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+func buildGraph(options GraphOptions, trace bool) error {
+	if options.Nodes != "instance" && options.Nodes != "ip" && options.Nodes != "zone" {
+		return fmt.Errorf("invalid nodes %s, expecting instance, ip or zone", options.Nodes)
+	}
+	if options.Format != "dot" && options.Format != "graphml" && options.Format != "json" {
+		return fmt.Errorf("invalid format %s, expecting dot, graphml or json", options.Format)
+	}
+
+	filter, err := ParseFilter(options.Filter, options.From, options.To)
+	if err != nil {
+		return err
+	}
+
+	esClient, esIndexName, err := newElasticsearchClient(trace)
+	if err != nil {
+		return err
+	}
+
+	sources := []interface{}{
+		compositeSource("instance_crn", filterFields["instance_crn"]),
+		compositeSource("direction", filterFields["direction"]),
+		compositeSource("initiator_ip", "flow_logs.initiator_ip.keyword"),
+		compositeSource("target_ip", "flow_logs.target_ip.keyword"),
+		compositeSource("target_port", filterFields["target_port"]),
+		compositeSource("transport_protocol", filterFields["transport_protocol"]),
+	}
+	aggs := map[string]interface{}{"bytes": trafficAggs()["bytes"]}
+
+	var flows []graphFlow
+	err = compositeBuckets(esClient, esIndexName, filter.Query(), sources, aggs, func(bucket gjson.Result) error {
+		flows = append(flows, graphFlow{
+			instanceCrn: bucket.Get("key.instance_crn").String(),
+			direction:   bucket.Get("key.direction").String(),
+			initiatorIP: bucket.Get("key.initiator_ip").String(),
+			targetIP:    bucket.Get("key.target_ip").String(),
+			targetPort:  bucket.Get("key.target_port").Int(),
+			protocol:    bucket.Get("key.transport_protocol").Int(),
+			flows:       bucket.Get("doc_count").Int(),
+			bytes:       bucket.Get("bytes.value").Int(),
+		})
+		return nil
+	})
+	if err != nil {
+		logger.ErrorLogger.Error("Error getting response from search", zap.Error(err))
+		return err
+	}
+
+	// The local ip of an interface is the initiator of its outbound flows and the target of its inbound flows, it
+	// resolves the remote end of the flows of other interfaces to their instance.
+	instances := map[string]string{}
+	for _, f := range flows {
+		if f.direction == "outbound" {
+			instances[f.initiatorIP] = f.instanceCrn
+		} else {
+			instances[f.targetIP] = f.instanceCrn
+		}
+	}
+
+	nodes := map[string]*graphNode{}
+	edges := map[string]*graphEdge{}
+	node := func(ip string) string {
+		n := &graphNode{ID: ip, Label: ip, Type: "ip"}
+		if crn, ok := instances[ip]; ok && options.Nodes != "ip" {
+			n = &graphNode{ID: crn, Label: crnResource(crn), Type: "instance"}
+			if options.Nodes == "zone" {
+				n = &graphNode{ID: crnZone(crn), Label: crnZone(crn), Type: "zone"}
+			}
+		}
+		if _, ok := nodes[n.ID]; !ok {
+			nodes[n.ID] = n
+		}
+		return n.ID
+	}
+
+	for _, f := range flows {
+		// A flow between two interfaces is logged by both, the outbound side is kept.
+		if _, ok := instances[f.initiatorIP]; ok && f.direction == "inbound" {
+			continue
+		}
+
+		source, target := node(f.initiatorIP), node(f.targetIP)
+		key := fmt.Sprintf("%s|%s|%d|%d", source, target, f.targetPort, f.protocol)
+		e, ok := edges[key]
+		if !ok {
+			e = &graphEdge{Source: source, Target: target, TargetPort: f.targetPort, TransportProtocol: f.protocol}
+			edges[key] = e
+		}
+		e.Flows += f.flows
+		e.Bytes += f.bytes
+	}
+
+	g := graph{Nodes: []*graphNode{}, Links: []*graphEdge{}}
+	linked := map[string]bool{}
+	for _, e := range edges {
+		if e.Flows < options.MinFlows {
+			continue
+		}
+		g.Links = append(g.Links, e)
+		linked[e.Source] = true
+		linked[e.Target] = true
+	}
+	for id, n := range nodes {
+		if linked[id] {
+			g.Nodes = append(g.Nodes, n)
+		}
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].ID < g.Nodes[j].ID })
+	sort.Slice(g.Links, func(i, j int) bool { return g.Links[i].Bytes > g.Links[j].Bytes })
+
+	logger.SystemLogger.Info("Built the graph.", zap.Int("nodes", len(g.Nodes)), zap.Int("edges", len(g.Links)), zap.Int("flow_groups", len(flows)))
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	switch options.Format {
+	case "dot":
+		return writeDOT(w, g)
+	case "graphml":
+		return writeGraphML(w, g)
+	}
+	return json.NewEncoder(w).Encode(g)
+}
+
+// crnZone returns the location of a crn, the zone of an instance.
+func crnZone(crn string) string {
+	parts := strings.Split(crn, ":")
+	if len(parts) < 6 || parts[5] == "" {
+		return "unknown"
+	}
+	return parts[5]
+}
+
+// crnResource returns the resource id at the end of a crn.
+func crnResource(crn string) string {
+	return crn[strings.LastIndex(crn, ":")+1:]
+}
+
+func edgeLabel(e *graphEdge) string {
+	return fmt.Sprintf("%s/%d", protocolName(e.TransportProtocol), e.TargetPort)
+}
+
+func writeDOT(w io.Writer, g graph) error {
+	fmt.Fprintln(w, "digraph flowlogs {")
+	fmt.Fprintln(w, "  rankdir=LR;")
+	for _, n := range g.Nodes {
+		shape := "ellipse"
+		if n.Type != "ip" {
+			shape = "box"
+		}
+		fmt.Fprintf(w, "  %s [label=%s, shape=%s, type=%s];\n", strconv.Quote(n.ID), strconv.Quote(n.Label), shape, n.Type)
+	}
+	for _, e := range g.Links {
+		fmt.Fprintf(w, "  %s -> %s [label=%s, weight=%d, flows=%d, bytes=%d];\n",
+			strconv.Quote(e.Source), strconv.Quote(e.Target), strconv.Quote(edgeLabel(e)), e.Flows, e.Flows, e.Bytes)
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+func writeGraphML(w io.Writer, g graph) error {
+	escape := func(s string) string {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+
+	fmt.Fprintln(w, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintln(w, `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">`)
+	fmt.Fprintln(w, `  <key id="label" for="node" attr.name="label" attr.type="string"/>`)
+	fmt.Fprintln(w, `  <key id="type" for="node" attr.name="type" attr.type="string"/>`)
+	fmt.Fprintln(w, `  <key id="target_port" for="edge" attr.name="target_port" attr.type="long"/>`)
+	fmt.Fprintln(w, `  <key id="transport_protocol" for="edge" attr.name="transport_protocol" attr.type="long"/>`)
+	fmt.Fprintln(w, `  <key id="flows" for="edge" attr.name="flows" attr.type="long"/>`)
+	fmt.Fprintln(w, `  <key id="bytes" for="edge" attr.name="bytes" attr.type="long"/>`)
+	fmt.Fprintln(w, `  <graph id="flowlogs" edgedefault="directed">`)
+	for _, n := range g.Nodes {
+		fmt.Fprintf(w, "    <node id=\"%s\"><data key=\"label\">%s</data><data key=\"type\">%s</data></node>\n", escape(n.ID), escape(n.Label), n.Type)
+	}
+	for _, e := range g.Links {
+		fmt.Fprintf(w, "    <edge source=\"%s\" target=\"%s\"><data key=\"target_port\">%d</data><data key=\"transport_protocol\">%d</data><data key=\"flows\">%d</data><data key=\"bytes\">%d</data></edge>\n",
+			escape(e.Source), escape(e.Target), e.TargetPort, e.TransportProtocol, e.Flows, e.Bytes)
+	}
+	fmt.Fprintln(w, "  </graph>")
+	_, err := fmt.Fprintln(w, "</graphml>")
+	return err
+}
@@ -19,47 +19,63 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/url"
-	"os"
+	"path/filepath"
 	"runtime"
-	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/IBM/ibm-cos-sdk-go/aws"
-	"github.com/IBM/ibm-cos-sdk-go/aws/credentials/ibmiam"
-	"github.com/IBM/ibm-cos-sdk-go/aws/session"
+	"github.com/IBM/ibm-cos-sdk-go/aws/awserr"
 	"github.com/IBM/ibm-cos-sdk-go/service/s3"
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/config"
 	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
-	"github.com/dustin/go-humanize"
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/metrics"
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/notifier"
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/tracing"
 	"github.com/elastic/go-elasticsearch/v7"
-	"github.com/elastic/go-elasticsearch/v7/estransport"
 	"github.com/elastic/go-elasticsearch/v7/esutil"
-	"github.com/spf13/viper"
 	"github.com/tidwall/gjson"
 	"go.uber.org/zap"
 )
 
-// Index function
-func Index(trace bool, recreateIndex bool) string {
-	err := bulkIndex(trace, recreateIndex)
+// IndexOptions holds the settings of an indexing run.
+type IndexOptions struct {
+	RecreateIndex bool
+	MetricsFile   string
+	ReportDir     string
+	IndexReport   bool
+}
+
+// Index function, returns the exit code of the run: 0 when every object was indexed, 1 when the run failed and 2 when
+// objects were left in the source bucket.
+func Index(options IndexOptions, trace bool) int {
+	report, err := bulkIndex(options, trace)
 	if err != nil {
 		fmt.Println(err)
 	}
-	return "done"
+	return exitCode(report)
 }
 
-func validateKey(key string) bool {
-	if key == "" {
-		return false
+// exitCode returns the exit code of a run from its report: 1 when it failed or has no report, 2 when it was partial.
+func exitCode(report *runReport) int {
+	if report == nil {
+		return 1
+	}
+	switch report.Status {
+	case runStatusOk:
+		return 0
+	case runStatusPartial:
+		return 2
+	default:
+		return 1
 	}
-	return true
 }
 
 // FlowLogs struct
@@ -100,121 +116,199 @@ type CosObject struct {
 	State                *string     `json:"state"`
 	NumberOfFlowLogs     *int64      `json:"number_of_flow_logs"`
 	FlowLogs             *[]FlowLogs `json:"flow_logs"`
+	IOC                  *IOCMatch   `json:"ioc,omitempty"`
+	DocumentID           *string     `json:"document_id"`
+	ObjectID             *string     `json:"object_id"`
+	Empty                *bool       `json:"empty,omitempty"`
 }
 
-// bulkIndex function
-func bulkIndex(trace bool, recreateIndex bool) error {
+// objectResult is the outcome of indexing an object, reported once all its documents are flushed.
+type objectResult struct {
+	Bucket     string `json:"bucket"`
+	Key        string `json:"key"`
+	DocumentID string `json:"document_id,omitempty"`
+	Status     string `json:"status"`
+	Documents  int64  `json:"documents"`
+	Failed     int64  `json:"failed,omitempty"`
+	Archived   bool   `json:"archived"`
+	Error      string `json:"error,omitempty"`
+}
 
-	var (
-		countSuccessful   uint64
-		countFailures     uint64
-		apiKey            = viper.GetString("cos.apikey")
-		serviceInstanceID = viper.GetString("cos.resource_instance_id")
-		authEndpoint      = viper.GetString("ibmcloud.iamUrl")
-		serviceEndpoint   = viper.GetString("cos.serviceEndpoint")
-		bucketsLocation   = viper.GetString("cos.bucketsLocation")
-		sourceBucketName  = viper.GetString("cos.sourceBucketName")
-		indexedBucketName = viper.GetString("cos.indexedBucketName")
-		esAddresses       []string
-		esIndexName       = viper.GetString("elasticsearch.indexName")
-		esIndexMapping    = viper.GetString("elasticsearch.indexMapping")
-		esUsername        = viper.GetString("elasticsearch.username")
-		esPassword        = viper.GetString("elasticsearch.password")
-		esCert            = viper.GetString("elasticsearch.certificate.certificate_base64")
-		cfg               elasticsearch.Config
-	)
+// deadLetter is an object that could not be read, indexed or archived and is left in the source bucket.
+type deadLetter struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	Stage  string `json:"stage"`
+	Error  string `json:"error"`
+}
 
-	if !validateKey(apiKey) {
-		log.Fatalln("cos.apikey or COS_APIKEY not provided ")
-	}
-	if !validateKey(serviceInstanceID) {
-		log.Fatalln("cos.resource_instance_id or COS_RESOURCE_INSTANCE_ID not provided ")
-	}
-	if !validateKey(authEndpoint) {
-		log.Fatalln("ibmcloud.iamUrl or IBMCLOUD_IAMURL not provided ")
-	}
-	if !validateKey(serviceEndpoint) {
-		log.Fatalln("cos.serviceEndpoint or COS_SERVICEENDPOINT not provided ")
-	}
-	if !validateKey(bucketsLocation) {
-		log.Fatalln("cos.bucketsLocation or COS_BUCKETSLOCATION not provided ")
-	}
-	if !validateKey(sourceBucketName) {
-		log.Fatalln("cos.sourceBucketName or COS_SOURCEBUCKETNAME not provided ")
-	}
-	if !validateKey(indexedBucketName) {
-		log.Fatalln("cos.indexedBucketName or COS_INDEXEDBUCKETNAME not provided ")
+// indexRunSummary is published to the notifiers subscribed to index_run events at the end of each run.
+type indexRunSummary struct {
+	Bucket             string  `json:"bucket"`
+	Index              string  `json:"index"`
+	Objects            int64   `json:"objects"`
+	EmptyObjects       int64   `json:"empty_objects"`
+	DocumentsFlushed   uint64  `json:"documents_flushed"`
+	DocumentsFailed    uint64  `json:"documents_failed"`
+	DeadLetters        int     `json:"dead_letters"`
+	DurationSeconds    float64 `json:"duration_seconds"`
+	DocumentsPerSecond int64   `json:"documents_per_second"`
+}
+
+// flushStartKey holds the start time of a bulk flush in its context.
+type flushStartKey struct{}
+
+// indexer reads the flow log objects of the source bucket, adds one document per flow to a long-lived bulk indexer and
+// moves each object to the indexed bucket once all its documents are indexed. It is shared by index and watch.
+type indexer struct {
+	listedCount     int64
+	objectsCount    int64
+	emptyCount      int64
+	indexedCount    int64
+	archivedCount   int64
+	bytesRead       int64
+	countSuccessful uint64
+	countFailures   uint64
+
+	cosClient         *s3.S3
+	esClient          *elasticsearch.Client
+	bi                esutil.BulkIndexer
+	iocs              *iocMatcher
+	sourceBucketName  string
+	indexedBucketName string
+	esIndexName       string
+	es                *config.Elasticsearch
+	start             time.Time
+	shutdownTracing   func()
+
+	mu               sync.Mutex
+	pending          map[string]bool
+	deadLetters      []deadLetter
+	deadLettersCount int
+	stageErrors      map[string]int
+	sampleErrors     []deadLetter
+	quarantined      map[string]*quarantine
+}
+
+// quarantine is an object left in the source bucket after a failure, it is skipped until retryAt.
+type quarantine struct {
+	failures int
+	retryAt  time.Time
+}
+
+const (
+	// quarantineBackoff is the time a quarantined object is skipped after its first failure, doubled at each
+	// failure up to maxQuarantineBackoff.
+	quarantineBackoff    = 5 * time.Minute
+	maxQuarantineBackoff = time.Hour
+)
+
+// bulkIndex function
+func bulkIndex(options IndexOptions, trace bool) (*runReport, error) {
+	// The metrics and the report of each profile go to their own file, so the profiles can be indexed in parallel.
+	if profile := config.Profile(); profile != "" {
+		if options.MetricsFile != "" {
+			ext := filepath.Ext(options.MetricsFile)
+			options.MetricsFile = strings.TrimSuffix(options.MetricsFile, ext) + "-" + profile + ext
+		}
+		if options.ReportDir != "" {
+			options.ReportDir = filepath.Join(options.ReportDir, profile)
+		}
 	}
-	if !validateKey(esIndexName) {
-		log.Fatalln("elasticsearch.indexName or ELASTICSEARCH_INDEXNAME not provided ")
+
+	start := time.Now().UTC()
+	ix, err := newIndexer(trace, options.RecreateIndex, 30*time.Second)
+	if err != nil {
+		// The run is still reported, so a scheduler watching the reports or the metrics sees it failed.
+		report := failedRunReport(start, err)
+		var esClient *elasticsearch.Client
+		var es *config.Elasticsearch
+		if options.IndexReport {
+			esClient, es, _ = newElasticsearch(trace)
+		}
+		writeRun(options, report, esClient, es)
+		return report, err
 	}
-	if !validateKey(esIndexMapping) {
-		log.Fatalln("elasticsearch.indexMapping or ELASTICSEARCH_INDEXMAPPING not provided ")
+
+	err = ix.indexBucket(nil)
+	report := ix.close()
+	if err != nil {
+		report.Status = runStatusFailed
+		report.Error = err.Error()
+		report.Errors["list"]++
 	}
-	if !validateKey(esUsername) {
-		log.Fatalln("elasticsearch.username or ELASTICSEARCH_USERNAME not provided ")
+
+	writeRun(options, report, ix.esClient, ix.es)
+	return report, err
+}
+
+// writeRun writes the metrics and the report of a run, and adds the report to the runs index when --indexReport is
+// set and the elasticsearch client could be opened.
+func writeRun(options IndexOptions, report *runReport, esClient *elasticsearch.Client, es *config.Elasticsearch) {
+	if report.Status == runStatusFailed {
+		metrics.LastRunFailed.Set(1)
+	} else {
+		metrics.LastRunFailed.Set(0)
 	}
-	if !validateKey(esPassword) {
-		log.Fatalln("elasticsearch.password or ELASTICSEARCH_PASSWORD not provided ")
+	if merr := metrics.WriteTextfile(options.MetricsFile); merr != nil {
+		logger.ErrorLogger.Error("Error writing the metrics.", zap.String("file", options.MetricsFile), zap.Error(merr))
 	}
-	if !validateKey(esCert) {
-		log.Fatalln("elasticsearch.certificate.certificate_base64 or ELASTICSEARCH_CERTIFICATE_CERTIFICATE_BASE64 not provided ")
+	if rerr := writeRunReport(options.ReportDir, report); rerr != nil {
+		logger.ErrorLogger.Error("Error writing the run report.", zap.String("dir", options.ReportDir), zap.Error(rerr))
 	}
-
-	cert, err := base64.StdEncoding.DecodeString(esCert)
-	if err != nil {
-		logger.ErrorLogger.Error("Error decoding certificate for elasticsearch.", zap.String("error: ", err.Error()))
-		return fmt.Errorf("base64.StdEncoding.DecodeString: %v", err)
-	}
-	esAddresses = append(esAddresses, fmt.Sprintf("https://%s:%s", viper.GetString("elasticsearch.hostname"), viper.GetString("elasticsearch.port")))
-
-	if trace {
-		cfg = elasticsearch.Config{
-			Addresses: esAddresses,
-			Username:  esUsername,
-			Password:  esPassword,
-			CACert:    cert,
-			Logger: &estransport.ColorLogger{
-				Output:             os.Stdout,
-				EnableRequestBody:  true,
-				EnableResponseBody: true,
-			},
+	if options.IndexReport {
+		if esClient == nil {
+			logger.ErrorLogger.Error("Error indexing the run report, elasticsearch is not configured.", zap.String("id", report.ID))
+			return
 		}
-	} else {
-		cfg = elasticsearch.Config{
-			Addresses: esAddresses,
-			Username:  esUsername,
-			Password:  esPassword,
-			CACert:    cert,
+		if rerr := indexRunReport(esClient, es, report); rerr != nil {
+			logger.ErrorLogger.Error("Error indexing the run report.", zap.String("index", es.RunsIndexName), zap.Error(rerr))
 		}
 	}
+}
+
+// newIndexer validates the configuration, creates the index when missing or when recreateIndex is set and opens the
+// bulk indexer, flushed at least every flushInterval, and the cos client.
+func newIndexer(trace bool, recreateIndex bool, flushInterval time.Duration) (*indexer, error) {
+	cfg := config.Load()
+	if err := config.Error(cfg.ValidateIndexing()); err != nil {
+		logger.ErrorLogger.Error("Error in the configuration.", zap.Error(err))
+		return nil, err
+	}
 
-	esClient, err := elasticsearch.NewClient(cfg)
+	var (
+		sourceBucketName  = cfg.COS.SourceBucketName
+		indexedBucketName = cfg.COS.IndexedBucketName
+		esIndexName       = cfg.Elasticsearch.IndexName
+		esIndexMapping    = cfg.Elasticsearch.IndexMapping
+		esAddress         = cfg.Elasticsearch.Address()
+	)
+
+	esClient, err := openElasticsearch(&cfg.Elasticsearch, trace)
 	if err != nil {
-		logger.ErrorLogger.Error("Error creating elasticsearch client.", zap.String("error: ", err.Error()))
-		return fmt.Errorf("elasticsearch.NewClient: %v", err)
+		return nil, err
 	}
 
 	res, err := esClient.Info()
 	if err != nil || res.IsError() {
-		logger.ErrorLogger.Error("Error in getting Client Info", zap.String("error: ", err.Error()))
-		return fmt.Errorf("esClient.Info: %v", err)
+		logger.ErrorLogger.Error("Error in getting Client Info", zap.String("address", esAddress), zap.Error(err))
+		return nil, fmt.Errorf("esClient.Info: %v", err)
 	}
 
 	body, _ := ioutil.ReadAll(res.Body)
 	serverVersion := gjson.GetBytes(body, "version.number")
-	logger.SystemLogger.Debug("Client Info", zap.String("Client version:", elasticsearch.Version), zap.String("Server version:", serverVersion.String()))
+	logger.SystemLogger.Debug("Client Info", zap.String("client_version", elasticsearch.Version), zap.String("server_version", serverVersion.String()))
 
 	res, err = esClient.Indices.Exists([]string{esIndexName})
 
 	if res.Status() == "200 OK" && recreateIndex {
 		res, err = esClient.Indices.Delete([]string{esIndexName}, esClient.Indices.Delete.WithIgnoreUnavailable(true))
 		if err != nil || res.IsError() {
-			logger.ErrorLogger.Error("Cannot delete index", zap.String("error: ", err.Error()))
-			return fmt.Errorf("esClient.Indices.Delete: %v", err)
+			logger.ErrorLogger.Error("Cannot delete index", zap.String("index", esIndexName), zap.Error(err))
+			return nil, fmt.Errorf("esClient.Indices.Delete: %v", err)
 		}
 		res.Body.Close()
-		logger.SystemLogger.Debug(fmt.Sprintf("Deleted index: %s", esIndexName))
+		logger.SystemLogger.Debug("Deleted index.", zap.String("index", esIndexName))
 	}
 
 	if res.Status() != "200 OK" || recreateIndex {
@@ -222,16 +316,25 @@ func bulkIndex(trace bool, recreateIndex bool) error {
 
 		res, err = esClient.Indices.Create(esIndexName, esClient.Indices.Create.WithBody(bytes.NewReader(indexMapping)))
 		if err != nil {
-			logger.ErrorLogger.Error("Cannot create index", zap.String("error: ", err.Error()))
-			return fmt.Errorf("esClient.Indices.Create: %v", err)
+			logger.ErrorLogger.Error("Cannot create index", zap.String("index", esIndexName), zap.Error(err))
+			return nil, fmt.Errorf("esClient.Indices.Create: %v", err)
 		}
 		if res.IsError() {
-			logger.ErrorLogger.Error("Cannot create index", zap.String("error: ", res.String()))
-			return fmt.Errorf("esClient.Indices.Create: %v", res)
+			logger.ErrorLogger.Error("Cannot create index", zap.String("index", esIndexName), zap.String("response", res.String()))
+			return nil, fmt.Errorf("esClient.Indices.Create: %v", res)
 		}
-		logger.SystemLogger.Debug(fmt.Sprintf("Created a new index: %s", esIndexName))
+		logger.SystemLogger.Debug("Created a new index.", zap.String("index", esIndexName))
 
 		res.Body.Close()
+	} else if err := updateMapping(esClient, esIndexName, esIndexMapping); err != nil {
+		logger.ErrorLogger.Error("Cannot update the index mapping", zap.String("index", esIndexName), zap.Error(err))
+		return nil, err
+	}
+
+	iocs, err := loadIOCMatcher(cfg.IOC)
+	if err != nil {
+		logger.ErrorLogger.Error("Error loading the ioc lists.", zap.Error(err))
+		return nil, err
 	}
 
 	bi, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
@@ -239,240 +342,561 @@ func bulkIndex(trace bool, recreateIndex bool) error {
 		Client:        esClient,
 		NumWorkers:    runtime.NumCPU(),
 		FlushBytes:    int(5e+6),
-		FlushInterval: 30 * time.Second,
+		FlushInterval: flushInterval,
+		OnFlushStart: func(ctx context.Context) context.Context {
+			ctx, _ = tracing.Start(ctx, "elasticsearch.bulk_flush", tracing.Index.String(esIndexName))
+			return context.WithValue(ctx, flushStartKey{}, time.Now())
+		},
+		OnFlushEnd: func(ctx context.Context) {
+			if start, ok := ctx.Value(flushStartKey{}).(time.Time); ok {
+				metrics.FlushDuration.Observe(time.Since(start).Seconds())
+			}
+			tracing.End(ctx)
+		},
 	})
 
 	if err != nil {
-		logger.ErrorLogger.Error("Error creating the indexer.", zap.String("error: ", err.Error()))
-		return fmt.Errorf("esutil.NewBulkIndexer: %v", err)
+		logger.ErrorLogger.Error("Error creating the indexer.", zap.String("index", esIndexName), zap.Error(err))
+		return nil, fmt.Errorf("esutil.NewBulkIndexer: %v", err)
 	}
 
-	conf := aws.NewConfig().
-		WithRegion(bucketsLocation).
-		WithEndpoint(serviceEndpoint).
-		WithCredentials(ibmiam.NewStaticCredentials(aws.NewConfig(), authEndpoint, apiKey, serviceInstanceID)).
-		WithS3ForcePathStyle(true)
+	cosClient := openCOS(cfg)
 
-	sess := session.Must(session.NewSession(&aws.Config{
-		MaxRetries: aws.Int(3),
-	}))
+	shutdownTracing, err := tracing.Init(cfg.Tracing)
+	if err != nil {
+		logger.ErrorLogger.Error("Error configuring the tracing.", zap.Error(err))
+		return nil, err
+	}
 
-	cosClient := s3.New(sess, conf)
+	return &indexer{
+		shutdownTracing:   shutdownTracing,
+		cosClient:         cosClient,
+		esClient:          esClient,
+		bi:                bi,
+		iocs:              iocs,
+		sourceBucketName:  sourceBucketName,
+		indexedBucketName: indexedBucketName,
+		esIndexName:       esIndexName,
+		es:                &cfg.Elasticsearch,
+		start:             time.Now().UTC(),
+		pending:           map[string]bool{},
+		stageErrors:       map[string]int{},
+		quarantined:       map[string]*quarantine{},
+	}, nil
+}
 
+// indexBucket lists the source bucket and indexes every object not already pending in the bulk indexer. It returns
+// early, after the object being read, when stop is closed.
+func (ix *indexer) indexBucket(stop <-chan struct{}) error {
 	continuationToken := ""
 	previousKey := ""
-
-	start := time.Now().UTC()
-
-	var objectsCount int64
-	objectsCount = 0
-	var objectsFlowlogsEmptyCount int64
-	objectsFlowlogsEmptyCount = 0
+	page := 0
 
 	for {
+		page++
+		ctx, span := tracing.Start(context.Background(), "cos.list_page", tracing.Bucket.String(ix.sourceBucketName), tracing.Page.Int(page))
+
 		listInput := &s3.ListObjectsV2Input{
-			Bucket:            aws.String(sourceBucketName),
+			Bucket:            aws.String(ix.sourceBucketName),
 			MaxKeys:           aws.Int64(25),
 			ContinuationToken: aws.String(continuationToken),
 			StartAfter:        aws.String(previousKey),
 		}
 
-		objects, err := cosClient.ListObjectsV2(listInput)
+		objects, err := ix.cosClient.ListObjectsV2(listInput)
 		if err != nil {
-			logger.ErrorLogger.Error("Error in getting bucket objects.", zap.String("error: ", err.Error()))
+			logger.ErrorLogger.Error("Error in getting bucket objects.", zap.String("bucket", ix.sourceBucketName), zap.Int("page", page), zap.Error(err))
+			tracing.Fail(span, err)
+			span.End()
 			return fmt.Errorf("cosClient.ListObjectsV2: %v", err)
 		}
+		span.SetAttributes(tracing.Objects.Int(len(objects.Contents)))
 
-		logger.SystemLogger.Info(fmt.Sprintf("Adding 25 or less objects to bulk index from: %s", sourceBucketName))
+		logger.SystemLogger.Info("Adding 25 or less objects to bulk index.", zap.String("bucket", ix.sourceBucketName), zap.Int("page", page), zap.Int("objects", len(objects.Contents)))
 
 		for _, object := range objects.Contents {
-			objectsCount++
-			key := *object.Key
+			select {
+			case <-stop:
+				span.End()
+				return nil
+			default:
+			}
 
-			// This section is used to handle a suspected bug in the cos sdk whereas the Copyobject fails if using the key string as is, it needs to be transformed to have the : double encoded.
-			tmpKey1 := strings.Replace(key, "=", "-equal-", -1)
-			tmpKey2 := strings.Replace(tmpKey1, "/", "-slash-", -1)
-			tmpKey3 := url.QueryEscape(tmpKey2)
-			tmpKey4 := strings.Replace(tmpKey3, "-equal-", "=", -1)
-			tmpKey := strings.Replace(tmpKey4, "-slash-", "/", -1)
+			atomic.AddInt64(&ix.listedCount, 1)
 
-			sha256DocumentID := fmt.Sprintf("%x", sha256.Sum256([]byte(key)))
+			ix.indexObject(ctx, *object.Key, nil)
+		}
+		span.End()
 
-			logger.SystemLogger.Debug(fmt.Sprintf("[%s] Read from COS bucket.", sha256DocumentID))
+		logger.SystemLogger.Debug("Added 25 or less objects to bulk index.", zap.String("bucket", ix.sourceBucketName), zap.Int("page", page))
 
-			objectInput := s3.GetObjectInput{
-				Bucket: aws.String(sourceBucketName),
-				Key:    aws.String(key),
-			}
+		if *objects.IsTruncated {
+			continuationToken = *objects.NextContinuationToken
+		} else {
+			break
+		}
+	}
 
-			res, err := cosClient.GetObject(&objectInput)
-			if err != nil {
-				logger.ErrorLogger.Error(fmt.Sprintf("[%s] ERROR: %s", *aws.String(key), err))
-				continue
-			}
+	return nil
+}
 
-			flowlog, _ := ioutil.ReadAll(res.Body)
-
-			version := gjson.GetBytes(flowlog, "version").String()
-			collectorCrn := gjson.GetBytes(flowlog, "collector_crn").String()
-			attachedEndpointType := gjson.GetBytes(flowlog, "attached_endpoint_type").String()
-			networkInterfaceID := gjson.GetBytes(flowlog, "network_interface_id").String()
-			instanceCrn := gjson.GetBytes(flowlog, "instance_crn").String()
-			vpcCrn := gjson.GetBytes(flowlog, "vpc_crn").String()
-			captureStartTime := gjson.GetBytes(flowlog, "capture_start_time").String()
-			captureEndTime := gjson.GetBytes(flowlog, "capture_end_time").String()
-			state := gjson.GetBytes(flowlog, "state").String()
-			numberOfFlowLogs := gjson.GetBytes(flowlog, "number_of_flow_logs").Int()
-
-			flowlogs := gjson.GetBytes(flowlog, "flow_logs")
-			flowlogsCount := gjson.GetBytes(flowlog, "flow_logs.#").Int()
-
-			if flowlogsCount == 0 {
-				objectsFlowlogsEmptyCount++
-				copyObjectInput := s3.CopyObjectInput{
-					Bucket:     aws.String(indexedBucketName),
-					CopySource: aws.String(sourceBucketName + "/" + tmpKey),
-					Key:        aws.String(tmpKey),
-				}
-				_, err := cosClient.CopyObject(&copyObjectInput)
-				if err != nil {
-					logger.ErrorLogger.Error(fmt.Sprintf("[%s] ERROR copying object: from %s to %s with key %s and tmpkey %s error %s", sha256DocumentID, sourceBucketName, indexedBucketName, key, tmpKey, err))
-					// logger.ErrorLogger.Error(fmt.Sprintf("[%s] ERROR copying object: %s", sha256DocumentID, err))
-				} else {
-					logger.SystemLogger.Debug(fmt.Sprintf("[%s] Copied to: %s.", sha256DocumentID, indexedBucketName))
-
-					deleteObjectInput := s3.DeleteObjectInput{
-						Bucket: aws.String(sourceBucketName),
-						Key:    aws.String(key),
-					}
+// isPending reports whether the documents of an object are still in the bulk indexer.
+func (ix *indexer) isPending(key string) bool {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	return ix.pending[key]
+}
 
-					logger.SystemLogger.Debug(fmt.Sprintf("[%s] Deleting object: %s also known as %s", sha256DocumentID, key, tmpKey))
+func (ix *indexer) setPending(key string, pending bool) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if pending {
+		ix.pending[key] = true
+	} else {
+		delete(ix.pending, key)
+	}
+}
 
-					_, err = cosClient.DeleteObject(&deleteObjectInput)
-					if err != nil {
-						logger.ErrorLogger.Error(fmt.Sprintf("[%s] ERROR deleting object: %s", sha256DocumentID, err))
-					}
-					logger.SystemLogger.Debug(fmt.Sprintf("[%s] Deleted object %s from: %s.", sha256DocumentID, key, sourceBucketName))
-				}
-			} else {
-				var count int64
-				count = 0
-				flowlogs.ForEach(func(_, value gjson.Result) bool {
-					count++
-
-					sha256DocumentIDCount := fmt.Sprintf("%s-%d", sha256DocumentID, count)
-
-					rawJSON := []byte(`[` + strings.Replace(string(value.String()), ":\"\"", ":null", -1) + `]`)
-					var flowLogs []FlowLogs
-					json.Unmarshal(rawJSON, &flowLogs)
-
-					flowlog3 := CosObject{
-						Version:              &version,
-						CollectorCrn:         &collectorCrn,
-						AttachedEndpointType: &attachedEndpointType,
-						NetworkInterfaceID:   &networkInterfaceID,
-						InstanceCrn:          &instanceCrn,
-						VpcCrn:               &vpcCrn,
-						CaptureStartTime:     &captureStartTime,
-						CaptureEndTime:       &captureEndTime,
-						State:                &state,
-						NumberOfFlowLogs:     &numberOfFlowLogs,
-						FlowLogs:             &flowLogs,
-					}
-					b, _ := json.Marshal(flowlog3)
-
-					bierr := bi.Add(
-						context.Background(),
-						esutil.BulkIndexerItem{
-							Action:     "index",
-							DocumentID: sha256DocumentIDCount,
-							Body:       bytes.NewReader(b),
-
-							OnSuccess: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
-								atomic.AddUint64(&countSuccessful, 1)
-								logger.SystemLogger.Info(fmt.Sprintf("[%s] Successfully added %s to index.", sha256DocumentID, sha256DocumentIDCount))
-
-								logger.SystemLogger.Debug(fmt.Sprintf("[%s] item id: [%s] - res id: [%s] ", sha256DocumentID, item.DocumentID, res.DocumentID))
-
-								docID := strings.Split(item.DocumentID, "-")[1]
-								docIDInt, _ := strconv.ParseInt(docID, 10, 64)
-
-								if docIDInt == flowlogsCount {
-
-									copyObjectInput := s3.CopyObjectInput{
-										Bucket:     aws.String(indexedBucketName),
-										CopySource: aws.String(sourceBucketName + "/" + tmpKey),
-										Key:        aws.String(tmpKey),
-									}
-									_, err := cosClient.CopyObject(&copyObjectInput)
-									if err != nil {
-										// logger.ErrorLogger.Error(fmt.Sprintf("[%s] ERROR copying object: from %s to %s with key %s and tmpkey %s error %s", sha256DocumentID, sourceBucketName, indexedBucketName, key, tmpKey, err))
-										logger.ErrorLogger.Error(fmt.Sprintf("[%s] ERROR copying object: %s", sha256DocumentID, err))
-									} else {
-										logger.SystemLogger.Debug(fmt.Sprintf("[%s] Copied to: %s.", sha256DocumentID, indexedBucketName))
-
-										deleteObjectInput := s3.DeleteObjectInput{
-											Bucket: aws.String(sourceBucketName),
-											Key:    aws.String(key),
-										}
-
-										logger.SystemLogger.Debug(fmt.Sprintf("[%s] Deleting object: %s also known as %s", sha256DocumentID, key, tmpKey))
-
-										_, err = cosClient.DeleteObject(&deleteObjectInput)
-										if err != nil {
-											logger.ErrorLogger.Error(fmt.Sprintf("[%s] ERROR deleting object: %s", sha256DocumentID, err))
-										}
-										logger.SystemLogger.Debug(fmt.Sprintf("[%s] Deleted object %s from: %s.", sha256DocumentID, key, sourceBucketName))
-									}
-								}
-
-							},
-
-							OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
-								atomic.AddUint64(&countFailures, 1)
-
-								if err != nil {
-									logger.ErrorLogger.Error(fmt.Sprintf("[%s] ERROR: %s", sha256DocumentID, err))
-								} else {
-									logger.ErrorLogger.Error(fmt.Sprintf("[%s] ERROR: %s: %s", sha256DocumentID, res.Error.Type, res.Error.Reason))
-								}
-							},
-						},
-					)
-
-					if bierr != nil {
-						logger.ErrorLogger.Error(fmt.Sprintf("[%s] Unexpected error. %s", sha256DocumentID, err.Error()))
-					}
-					return true // keep iterating
-				})
+// isQuarantined reports whether an object failed recently and is to be skipped until its backoff ends.
+func (ix *indexer) isQuarantined(key string) bool {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	q, ok := ix.quarantined[key]
+	return ok && time.Now().Before(q.retryAt)
+}
+
+// release removes an object from the quarantine once it is moved to the indexed bucket.
+func (ix *indexer) release(key string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	delete(ix.quarantined, key)
+}
+
+// addDeadLetter quarantines an object, the dead letter is only published the first time the object fails so a watch
+// retrying it does not notify at each poll.
+func (ix *indexer) addDeadLetter(key string, stage string, err string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.stageErrors[stage]++
+
+	q, ok := ix.quarantined[key]
+	if !ok {
+		q = &quarantine{}
+		ix.quarantined[key] = q
+	}
+	q.failures++
+	backoff := quarantineBackoff
+	for i := 1; i < q.failures && backoff < maxQuarantineBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxQuarantineBackoff {
+		backoff = maxQuarantineBackoff
+	}
+	q.retryAt = time.Now().Add(backoff)
+	if ok {
+		return
+	}
+
+	letter := deadLetter{Bucket: ix.sourceBucketName, Key: key, Stage: stage, Error: err}
+	ix.deadLetters = append(ix.deadLetters, letter)
+	ix.deadLettersCount++
+	if len(ix.sampleErrors) < maxSampleErrors {
+		ix.sampleErrors = append(ix.sampleErrors, letter)
+	}
+	metrics.DeadLetters.WithLabelValues(stage).Inc()
+}
+
+// objectFields identifies an object in the logs, the object id is the prefix of the ids of its documents.
+func (ix *indexer) objectFields(key string, objectID string) []zap.Field {
+	return []zap.Field{
+		zap.String("bucket", ix.sourceBucketName),
+		zap.String("key", key),
+		zap.String("object_id", objectID),
+		zap.String("index", ix.esIndexName),
+	}
+}
+
+// indexObject reads an object and adds one document per flow to the bulk indexer, the object is archived when the
+// last of its documents is indexed, or right away when it holds no flow. The document ids derive from the key so
+// indexing an object again overwrites its documents. report, when not nil, is called once with the outcome.
+func (ix *indexer) indexObject(ctx context.Context, key string, report func(objectResult)) {
+	sha256DocumentID := fmt.Sprintf("%x", sha256.Sum256([]byte(key)))
+
+	// The span of the object ends with its outcome, once its last document is flushed.
+	ctx, span := tracing.Start(ctx, "flowlogs.index_object",
+		tracing.Bucket.String(ix.sourceBucketName), tracing.Key.String(key), tracing.DocumentID.String(sha256DocumentID))
+
+	result := objectResult{Bucket: ix.sourceBucketName, Key: key, DocumentID: sha256DocumentID}
+	finish := func(status string, reason string) {
+		result.Status = status
+		result.Error = reason
+		span.SetAttributes(tracing.Status.String(status), tracing.Documents.Int64(result.Documents))
+		if reason != "" {
+			tracing.Fail(span, errors.New(reason))
+		}
+		span.End()
+		if report != nil {
+			report(result)
+		}
+	}
+
+	if ix.isPending(key) {
+		finish("pending", "")
+		return
+	}
+	if ix.isQuarantined(key) {
+		finish("quarantined", "")
+		return
+	}
+
+	atomic.AddInt64(&ix.objectsCount, 1)
+	metrics.Objects.Inc()
+
+	systemLog := logger.SystemLogger.With(ix.objectFields(key, sha256DocumentID)...)
+	errorLog := logger.ErrorLogger.With(ix.objectFields(key, sha256DocumentID)...)
+
+	systemLog.Debug("Read from COS bucket.")
+
+	objectInput := s3.GetObjectInput{
+		Bucket: aws.String(ix.sourceBucketName),
+		Key:    aws.String(key),
+	}
+
+	_, getSpan := tracing.Start(ctx, "cos.get_object", tracing.Bucket.String(ix.sourceBucketName), tracing.Key.String(key))
+	getStart := time.Now()
+	res, err := ix.cosClient.GetObject(&objectInput)
+	if err != nil {
+		metrics.GetDuration.Observe(time.Since(getStart).Seconds())
+		// An object already archived, i.e. a notification delivered twice, is not an error.
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			getSpan.End()
+			systemLog.Info("Object not found, already indexed.")
+			finish("missing", "")
+			return
+		}
+		tracing.Fail(getSpan, err)
+		getSpan.End()
+		errorLog.Error("Error reading object.", zap.Error(err))
+		ix.addDeadLetter(key, "get", err.Error())
+		finish("failed", err.Error())
+		return
+	}
+
+	flowlog, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	metrics.GetDuration.Observe(time.Since(getStart).Seconds())
+	getSpan.End()
+	atomic.AddInt64(&ix.bytesRead, int64(len(flowlog)))
+
+	_, transformSpan := tracing.Start(ctx, "flowlogs.transform", tracing.DocumentID.String(sha256DocumentID))
+
+	version := gjson.GetBytes(flowlog, "version").String()
+	collectorCrn := gjson.GetBytes(flowlog, "collector_crn").String()
+	attachedEndpointType := gjson.GetBytes(flowlog, "attached_endpoint_type").String()
+	networkInterfaceID := gjson.GetBytes(flowlog, "network_interface_id").String()
+	instanceCrn := gjson.GetBytes(flowlog, "instance_crn").String()
+	vpcCrn := gjson.GetBytes(flowlog, "vpc_crn").String()
+	captureStartTime := gjson.GetBytes(flowlog, "capture_start_time").String()
+	captureEndTime := gjson.GetBytes(flowlog, "capture_end_time").String()
+	state := gjson.GetBytes(flowlog, "state").String()
+	numberOfFlowLogs := gjson.GetBytes(flowlog, "number_of_flow_logs").Int()
+
+	flowlogs := gjson.GetBytes(flowlog, "flow_logs")
+	flowlogsCount := gjson.GetBytes(flowlog, "flow_logs.#").Int()
+
+	type document struct {
+		id   string
+		body []byte
+	}
+	var documents []document
+	transform := func(documentID string, flowLogs *[]FlowLogs) {
+		flowlog3 := CosObject{
+			Version:              &version,
+			CollectorCrn:         &collectorCrn,
+			AttachedEndpointType: &attachedEndpointType,
+			NetworkInterfaceID:   &networkInterfaceID,
+			InstanceCrn:          &instanceCrn,
+			VpcCrn:               &vpcCrn,
+			CaptureStartTime:     &captureStartTime,
+			CaptureEndTime:       &captureEndTime,
+			State:                &state,
+			NumberOfFlowLogs:     &numberOfFlowLogs,
+			FlowLogs:             flowLogs,
+			DocumentID:           &documentID,
+			ObjectID:             &sha256DocumentID,
+		}
+		if flowLogs == nil {
+			empty := true
+			flowlog3.Empty = &empty
+		}
+		ix.iocs.tagFlows(&flowlog3)
+		b, _ := json.Marshal(flowlog3)
+		documents = append(documents, document{id: documentID, body: b})
+	}
+
+	// An object holding no flow is recorded by a single empty document, so its capture window is known to the audit.
+	if flowlogsCount == 0 {
+		atomic.AddInt64(&ix.emptyCount, 1)
+		metrics.EmptyObjects.Inc()
+		transform(fmt.Sprintf("%s-0", sha256DocumentID), nil)
+	} else {
+		var count int64
+		flowlogs.ForEach(func(_, value gjson.Result) bool {
+			count++
+
+			rawJSON := []byte(`[` + strings.Replace(string(value.String()), ":\"\"", ":null", -1) + `]`)
+			var flowLogs []FlowLogs
+			json.Unmarshal(rawJSON, &flowLogs)
+
+			transform(fmt.Sprintf("%s-%d", sha256DocumentID, count), &flowLogs)
+			return true // keep iterating
+		})
+	}
+	documentsCount := int64(len(documents))
+	transformSpan.SetAttributes(tracing.Documents.Int64(documentsCount))
+	transformSpan.End()
+
+	// The object stays pending until each of its documents is flushed, the last one archives it unless one failed,
+	// in which case it is dead-lettered and left in the source bucket.
+	ix.setPending(key, true)
+	result.Documents = documentsCount
+	remaining := documentsCount
+	var failed int64
+	var firstReason atomic.Value
+	done := func(reason string) {
+		if reason != "" && atomic.AddInt64(&failed, 1) == 1 {
+			firstReason.Store(reason)
+			ix.addDeadLetter(key, "index", reason)
+		}
+		if atomic.AddInt64(&remaining, -1) != 0 {
+			return
+		}
+		result.Failed = atomic.LoadInt64(&failed)
+		if result.Failed == 0 {
+			if flowlogsCount > 0 {
+				atomic.AddInt64(&ix.indexedCount, 1)
 			}
+			result.Archived = ix.archive(ctx, key)
+		}
+		ix.setPending(key, false)
+		if result.Failed > 0 {
+			finish("failed", firstReason.Load().(string))
+			return
 		}
+		if flowlogsCount == 0 {
+			finish("empty", "")
+			return
+		}
+		finish("indexed", "")
+	}
 
-		logger.SystemLogger.Debug(fmt.Sprintf("Added 25 or less objects to bulk index from: %s", sourceBucketName))
+	for _, doc := range documents {
+		documentID := doc.id
+		_, enqueueSpan := tracing.Start(ctx, "elasticsearch.enqueue", tracing.Index.String(ix.esIndexName), tracing.DocumentID.String(documentID))
+		bierr := ix.bi.Add(
+			context.Background(),
+			esutil.BulkIndexerItem{
+				Action:     "index",
+				DocumentID: documentID,
+				Body:       bytes.NewReader(doc.body),
+
+				// The callbacks get the context of the bulk_flush span, the events go to the span of the object.
+				OnSuccess: func(flushCtx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
+					atomic.AddUint64(&ix.countSuccessful, 1)
+					metrics.DocumentsFlushed.Inc()
+					metrics.QueueDepth.Dec()
+					systemLog.Info("Successfully added document to index.", zap.String("document_id", documentID))
+
+					systemLog.Debug("Bulk response item.", zap.String("document_id", item.DocumentID), zap.String("response_id", res.DocumentID))
+
+					tracing.AddEvent(ctx, "indexed", tracing.DocumentID.String(documentID))
+					done("")
+				},
+
+				OnFailure: func(flushCtx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+					atomic.AddUint64(&ix.countFailures, 1)
+					metrics.DocumentsFailed.Inc()
+					metrics.QueueDepth.Dec()
+
+					reason := ""
+					if err != nil {
+						errorLog.Error("Error indexing document.", zap.String("document_id", documentID), zap.Error(err))
+						reason = err.Error()
+					} else {
+						errorLog.Error("Error indexing document.", zap.String("document_id", documentID), zap.String("error_type", res.Error.Type), zap.String("error_reason", res.Error.Reason))
+						reason = fmt.Sprintf("%s: %s", res.Error.Type, res.Error.Reason)
+					}
 
-		if *objects.IsTruncated {
-			continuationToken = *objects.NextContinuationToken
+					tracing.AddEvent(ctx, "failed", tracing.DocumentID.String(documentID))
+					done(reason)
+				},
+			},
+		)
+
+		if bierr != nil {
+			tracing.Fail(enqueueSpan, bierr)
+			enqueueSpan.End()
+			errorLog.Error("Error adding document to the bulk indexer.", zap.String("document_id", documentID), zap.Error(bierr))
+			done(bierr.Error())
 		} else {
-			break
+			enqueueSpan.End()
+			metrics.QueueDepth.Inc()
 		}
 	}
+}
 
-	if err := bi.Close(context.Background()); err != nil {
-		logger.ErrorLogger.Error("Unexpected error.", zap.String("error: ", err.Error()))
+// archive copies an object to the indexed bucket and deletes it from the source bucket.
+func (ix *indexer) archive(ctx context.Context, key string) bool {
+	_, span := tracing.Start(ctx, "cos.archive", tracing.Bucket.String(ix.indexedBucketName), tracing.Key.String(key))
+	defer span.End()
+
+	// This section is used to handle a suspected bug in the cos sdk whereas the Copyobject fails if using the key string as is, it needs to be transformed to have the : double encoded.
+	tmpKey1 := strings.Replace(key, "=", "-equal-", -1)
+	tmpKey2 := strings.Replace(tmpKey1, "/", "-slash-", -1)
+	tmpKey3 := url.QueryEscape(tmpKey2)
+	tmpKey4 := strings.Replace(tmpKey3, "-equal-", "=", -1)
+	tmpKey := strings.Replace(tmpKey4, "-slash-", "/", -1)
+
+	sha256DocumentID := fmt.Sprintf("%x", sha256.Sum256([]byte(key)))
+	systemLog := logger.SystemLogger.With(ix.objectFields(key, sha256DocumentID)...)
+	errorLog := logger.ErrorLogger.With(ix.objectFields(key, sha256DocumentID)...)
+
+	copyObjectInput := s3.CopyObjectInput{
+		Bucket:     aws.String(ix.indexedBucketName),
+		CopySource: aws.String(ix.sourceBucketName + "/" + tmpKey),
+		Key:        aws.String(tmpKey),
+	}
+	_, err := ix.cosClient.CopyObject(&copyObjectInput)
+	if err != nil {
+		errorLog.Error("Error copying object.", zap.String("indexed_bucket", ix.indexedBucketName), zap.String("tmp_key", tmpKey), zap.Error(err))
+		ix.addDeadLetter(key, "archive", err.Error())
+		tracing.Fail(span, err)
+		return false
+	}
+	systemLog.Debug("Copied object.", zap.String("indexed_bucket", ix.indexedBucketName))
+	ix.release(key)
+	atomic.AddInt64(&ix.archivedCount, 1)
+	metrics.ArchivedObjects.Inc()
+
+	deleteObjectInput := s3.DeleteObjectInput{
+		Bucket: aws.String(ix.sourceBucketName),
+		Key:    aws.String(key),
 	}
 
-	biStats := bi.Stats()
+	systemLog.Debug("Deleting object.", zap.String("tmp_key", tmpKey))
 
-	duration := time.Since(start)
-	logger.SystemLogger.Info(fmt.Sprintf("Indexed [%s] objects [%s] empty flowlogs objects [%s] documents with [%s] errors in %s (%s docs/sec) with success count %s and failures count %s",
-		humanize.Comma(int64(objectsCount)),
-		humanize.Comma(int64(objectsFlowlogsEmptyCount)),
-		humanize.Comma(int64(biStats.NumFlushed)),
-		humanize.Comma(int64(biStats.NumFailed)),
-		duration.Truncate(time.Millisecond),
-		humanize.Comma(int64(1000.0/float64(duration/time.Millisecond)*float64(biStats.NumFlushed))),
-		humanize.Comma(int64(countSuccessful)),
-		humanize.Comma(int64(countFailures))))
+	_, err = ix.cosClient.DeleteObject(&deleteObjectInput)
+	if err != nil {
+		errorLog.Error("Error deleting object.", zap.Error(err))
+		return true
+	}
+	systemLog.Debug("Deleted object.")
+	return true
+}
 
-	return nil
+// publishDeadLetters sends the dead letters collected since the last call to the notifiers.
+func (ix *indexer) publishDeadLetters() {
+	ix.mu.Lock()
+	letters := ix.deadLetters
+	ix.deadLetters = nil
+	ix.mu.Unlock()
+
+	var events []notifier.Event
+	for _, letter := range letters {
+		events = append(events, notifier.NewEvent(notifier.EventDeadLetter, letter))
+	}
+	if len(events) > 0 {
+		notifier.Publish(events)
+	}
+}
+
+// close flushes the bulk indexer, which waits for the callbacks and so for the archive moves in flight, then logs and
+// publishes the summary of the run and returns its report.
+func (ix *indexer) close() *runReport {
+	if err := ix.bi.Close(context.Background()); err != nil {
+		logger.ErrorLogger.Error("Error closing the bulk indexer.", zap.String("index", ix.esIndexName), zap.Error(err))
+	}
+
+	biStats := ix.bi.Stats()
+
+	duration := time.Since(ix.start)
+	metrics.LastRunTimestamp.SetToCurrentTime()
+	metrics.LastRunDuration.Set(duration.Seconds())
+
+	// A run can last less than the clock resolution, the rate is then left at 0.
+	var documentsPerSecond int64
+	if duration.Seconds() > 0 {
+		documentsPerSecond = int64(float64(biStats.NumFlushed) / duration.Seconds())
+	}
+	logger.SystemLogger.Info("Indexed the objects.",
+		zap.String("bucket", ix.sourceBucketName),
+		zap.String("index", ix.esIndexName),
+		zap.Int64("objects", atomic.LoadInt64(&ix.objectsCount)),
+		zap.Int64("empty_objects", atomic.LoadInt64(&ix.emptyCount)),
+		zap.Uint64("documents_flushed", biStats.NumFlushed),
+		zap.Uint64("documents_failed", biStats.NumFailed),
+		zap.Duration("duration", duration.Truncate(time.Millisecond)),
+		zap.Int64("documents_per_second", documentsPerSecond),
+		zap.Uint64("success_count", atomic.LoadUint64(&ix.countSuccessful)),
+		zap.Uint64("failure_count", atomic.LoadUint64(&ix.countFailures)))
+
+	ix.mu.Lock()
+	deadLettersCount := ix.deadLettersCount
+	report := &runReport{
+		ID:              runID(ix.start, config.Profile(), ix.sourceBucketName),
+		Timestamp:       ix.start.Add(duration).Format(time.RFC3339),
+		Start:           ix.start.Format(time.RFC3339),
+		End:             ix.start.Add(duration).Format(time.RFC3339),
+		DurationSeconds: duration.Seconds(),
+		Status:          runStatusOk,
+		Profile:         config.Profile(),
+		Bucket:          ix.sourceBucketName,
+		Index:           ix.esIndexName,
+		Objects: runObjects{
+			Listed:      atomic.LoadInt64(&ix.listedCount),
+			Read:        atomic.LoadInt64(&ix.objectsCount),
+			Empty:       atomic.LoadInt64(&ix.emptyCount),
+			Indexed:     atomic.LoadInt64(&ix.indexedCount),
+			Archived:    atomic.LoadInt64(&ix.archivedCount),
+			Quarantined: int64(len(ix.quarantined)),
+		},
+		Errors:             map[string]int{},
+		SampleErrors:       append([]deadLetter{}, ix.sampleErrors...),
+		BytesRead:          atomic.LoadInt64(&ix.bytesRead),
+		DocumentsPerSecond: documentsPerSecond,
+		BulkIndexer: runBulkStats{
+			Added:    biStats.NumAdded,
+			Flushed:  biStats.NumFlushed,
+			Failed:   biStats.NumFailed,
+			Indexed:  biStats.NumIndexed,
+			Created:  biStats.NumCreated,
+			Updated:  biStats.NumUpdated,
+			Deleted:  biStats.NumDeleted,
+			Requests: biStats.NumRequests,
+		},
+	}
+	for stage, count := range ix.stageErrors {
+		report.Errors[stage] = count
+	}
+	ix.mu.Unlock()
+	if report.Objects.Quarantined > 0 || report.BulkIndexer.Failed > 0 {
+		report.Status = runStatusPartial
+	}
+
+	notifier.Publish([]notifier.Event{
+		notifier.NewEvent(notifier.EventIndexRun, indexRunSummary{
+			Bucket:             ix.sourceBucketName,
+			Index:              ix.esIndexName,
+			Objects:            atomic.LoadInt64(&ix.objectsCount),
+			EmptyObjects:       atomic.LoadInt64(&ix.emptyCount),
+			DocumentsFlushed:   biStats.NumFlushed,
+			DocumentsFailed:    biStats.NumFailed,
+			DeadLetters:        deadLettersCount,
+			DurationSeconds:    duration.Seconds(),
+			DocumentsPerSecond: documentsPerSecond,
+		}),
+	})
+	ix.publishDeadLetters()
+	ix.shutdownTracing()
+	return report
 }
@@ -0,0 +1,217 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+// InventoryOptions holds the settings of the inventory.
+type InventoryOptions struct {
+	Filter     string
+	From       string
+	To         string
+	StaleAfter time.Duration
+	Format     string
+}
+
+// inventoryItem is a collector, vpc, instance or interface seen in the flow logs.
+type inventoryItem struct {
+	Type                 string  `json:"type"`
+	ID                   string  `json:"id"`
+	VpcCrn               string  `json:"vpc_crn,omitempty"`
+	InstanceCrn          string  `json:"instance_crn,omitempty"`
+	AttachedEndpointType string  `json:"attached_endpoint_type,omitempty"`
+	FirstCapture         string  `json:"first_capture"`
+	LastCapture          string  `json:"last_capture"`
+	Flows                int64   `json:"flows"`
+	Bytes                int64   `json:"bytes"`
+	Rejected             int64   `json:"rejected"`
+	RejectedShare        float64 `json:"rejected_share"`
+	Stale                bool    `json:"stale"`
+}
+
+var inventoryColumns = []string{
+	"type", "id", "vpc_crn", "instance_crn", "attached_endpoint_type", "first_capture", "last_capture", "flows", "bytes",
+	"rejected", "rejected_share", "stale",
+}
+
+func (i *inventoryItem) csvRow() []string {
+	return []string{
+		i.Type, i.ID, i.VpcCrn, i.InstanceCrn, i.AttachedEndpointType, i.FirstCapture, i.LastCapture,
+		strconv.FormatInt(i.Flows, 10), strconv.FormatInt(i.Bytes, 10), strconv.FormatInt(i.Rejected, 10),
+		strconv.FormatFloat(i.RejectedShare, 'f', 4, 64), strconv.FormatBool(i.Stale),
+	}
+}
+
+func (i *inventoryItem) add(o *inventoryItem) {
+	if i.FirstCapture == "" || o.FirstCapture < i.FirstCapture {
+		i.FirstCapture = o.FirstCapture
+	}
+	if o.LastCapture > i.LastCapture {
+		i.LastCapture = o.LastCapture
+	}
+	i.Flows += o.Flows
+	i.Bytes += o.Bytes
+	i.Rejected += o.Rejected
+}
+
+// inventory lists the items of each type.
+type inventory struct {
+	Collectors []*inventoryItem `json:"collectors"`
+	Vpcs       []*inventoryItem `json:"vpcs"`
+	Instances  []*inventoryItem `json:"instances"`
+	Interfaces []*inventoryItem `json:"interfaces"`
+}
+
+// Inventory function, returns the exit code of the command: 0 on success and 1 when it failed.
+func Inventory(options InventoryOptions, trace bool) int {
+	err := listInventory(options, trace)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+func listInventory(options InventoryOptions, trace bool) error {
+	if err := validateFormat(options.Format); err != nil {
+		return err
+	}
+
+	filter, err := ParseFilter(options.Filter, options.From, options.To)
+	if err != nil {
+		return err
+	}
+	// The objects holding no flow are recorded by an empty document, without it a collector whose last objects were
+	// empty would have an older last capture and be reported stale.
+	filter.EmptyObjects = true
+
+	esClient, esIndexName, err := newElasticsearchClient(trace)
+	if err != nil {
+		return err
+	}
+
+	sources := []interface{}{
+		compositeSource("collector_crn", filterFields["collector_crn"]),
+		compositeSource("vpc_crn", filterFields["vpc_crn"]),
+		compositeSource("instance_crn", filterFields["instance_crn"]),
+		compositeSource("network_interface_id", filterFields["network_interface_id"]),
+		compositeSource("attached_endpoint_type", filterFields["attached_endpoint_type"]),
+	}
+	aggs := trafficAggs()
+	aggs["first_capture"] = map[string]interface{}{"min": map[string]interface{}{"field": "capture_start_time"}}
+	aggs["last_capture"] = map[string]interface{}{"max": map[string]interface{}{"field": "capture_end_time"}}
+	aggs["empty"] = map[string]interface{}{"filter": map[string]interface{}{"term": map[string]interface{}{"empty": true}}}
+
+	collectors := map[string]*inventoryItem{}
+	vpcs := map[string]*inventoryItem{}
+	instances := map[string]*inventoryItem{}
+	interfaces := map[string]*inventoryItem{}
+
+	rollup := func(items map[string]*inventoryItem, itemType string, id string, vpcCrn string, from *inventoryItem) *inventoryItem {
+		item, ok := items[id]
+		if !ok {
+			item = &inventoryItem{Type: itemType, ID: id, VpcCrn: vpcCrn}
+			items[id] = item
+		}
+		item.add(from)
+		return item
+	}
+
+	err = compositeBuckets(esClient, esIndexName, filter.Query(), sources, aggs, func(bucket gjson.Result) error {
+		flows, bytes, _, _, rejected := trafficValues(bucket)
+		// The empty documents are not flows.
+		flows -= bucket.Get("empty.doc_count").Int()
+		seen := &inventoryItem{
+			FirstCapture: bucket.Get("first_capture.value_as_string").String(),
+			LastCapture:  bucket.Get("last_capture.value_as_string").String(),
+			Flows:        flows,
+			Bytes:        bytes,
+			Rejected:     rejected,
+		}
+
+		collectorCrn := bucket.Get("key.collector_crn").String()
+		vpcCrn := bucket.Get("key.vpc_crn").String()
+		instanceCrn := bucket.Get("key.instance_crn").String()
+		interfaceID := bucket.Get("key.network_interface_id").String()
+
+		collector := rollup(collectors, "collector", collectorCrn, vpcCrn, seen)
+		collector.AttachedEndpointType = bucket.Get("key.attached_endpoint_type").String()
+		rollup(vpcs, "vpc", vpcCrn, "", seen)
+		rollup(instances, "instance", instanceCrn, vpcCrn, seen)
+		rollup(interfaces, "interface", interfaceID, vpcCrn, seen).InstanceCrn = instanceCrn
+		return nil
+	})
+	if err != nil {
+		logger.ErrorLogger.Error("Error getting response from search", zap.Error(err))
+		return err
+	}
+
+	// A collector is stale when its last capture is older than the most recent capture of all collectors by more than
+	// StaleAfter, so a pause of the indexing does not flag every collector.
+	var newest time.Time
+	for _, c := range collectors {
+		if last, err := time.Parse(time.RFC3339, c.LastCapture); err == nil && last.After(newest) {
+			newest = last
+		}
+	}
+
+	result := inventory{}
+	sorted := func(items map[string]*inventoryItem) []*inventoryItem {
+		list := []*inventoryItem{}
+		for _, item := range items {
+			if item.Flows > 0 {
+				item.RejectedShare = float64(item.Rejected) / float64(item.Flows)
+			}
+			list = append(list, item)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+		return list
+	}
+	result.Collectors = sorted(collectors)
+	result.Vpcs = sorted(vpcs)
+	result.Instances = sorted(instances)
+	result.Interfaces = sorted(interfaces)
+
+	stale := 0
+	for _, c := range result.Collectors {
+		last, err := time.Parse(time.RFC3339, c.LastCapture)
+		c.Stale = err != nil || newest.Sub(last) > options.StaleAfter
+		if c.Stale {
+			stale++
+			logger.SystemLogger.Warn("Collector has not produced flow logs recently.", zap.String("collector_crn", c.ID), zap.String("last_capture", c.LastCapture))
+		}
+	}
+
+	logger.SystemLogger.Info("Listed the inventory.", zap.Int("collectors", len(result.Collectors)), zap.Int("stale", stale),
+		zap.Int("vpcs", len(result.Vpcs)), zap.Int("instances", len(result.Instances)), zap.Int("interfaces", len(result.Interfaces)))
+
+	var rows []csvRower
+	for _, items := range [][]*inventoryItem{result.Collectors, result.Vpcs, result.Instances, result.Interfaces} {
+		for _, item := range items {
+			rows = append(rows, item)
+		}
+	}
+	return printResults(options.Format, inventoryColumns, result, rows)
+}
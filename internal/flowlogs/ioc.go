@@ -0,0 +1,350 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/config"
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"go.uber.org/zap"
+)
+
+// IOCMatch is added to each indexed flow when IOC lists are configured, Matched is false when neither the initiator
+// nor the target ip is listed. Confidence is the highest confidence of the matching indicators.
+type IOCMatch struct {
+	Matched    bool     `json:"matched"`
+	Sources    []string `json:"sources,omitempty"`
+	Confidence int      `json:"confidence,omitempty"`
+	Indicators []string `json:"indicators,omitempty"`
+	IPs        []string `json:"ips,omitempty"`
+}
+
+type iocIndicator struct {
+	source     string
+	indicator  string
+	confidence int
+}
+
+// iocMatcher finds the indicators containing an ip. The networks are grouped by prefix length so a lookup costs one
+// map access per distinct prefix length rather than a scan of every indicator.
+type iocMatcher struct {
+	networks   map[int]map[string][]iocIndicator
+	prefixes   []int
+	indicators []iocIndicator
+	count      int
+}
+
+func newIOCMatcher() *iocMatcher {
+	return &iocMatcher{networks: map[int]map[string][]iocIndicator{}}
+}
+
+// add registers an ip or CIDR block, a plain ip is a /32 or /128 network.
+func (m *iocMatcher) add(value string, indicator iocIndicator) error {
+	value = strings.TrimSpace(value)
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return fmt.Errorf("invalid indicator %q, expecting an ip or a CIDR block", value)
+		}
+		if ip.To4() != nil {
+			value += "/32"
+		} else {
+			value += "/128"
+		}
+	}
+
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return fmt.Errorf("invalid indicator %q, expecting an ip or a CIDR block", value)
+	}
+
+	// The prefix lengths of the IPv6 networks are offset by 1000 to keep them apart from the IPv4 ones.
+	ones, bits := network.Mask.Size()
+	prefix := ones
+	if bits == 128 {
+		prefix += 1000
+	}
+	if _, ok := m.networks[prefix]; !ok {
+		m.networks[prefix] = map[string][]iocIndicator{}
+		m.prefixes = append(m.prefixes, prefix)
+		sort.Ints(m.prefixes)
+	}
+
+	indicator.indicator = network.String()
+	m.networks[prefix][network.IP.String()] = append(m.networks[prefix][network.IP.String()], indicator)
+	m.indicators = append(m.indicators, indicator)
+	m.count++
+	return nil
+}
+
+// lookup returns the indicators containing the ip.
+func (m *iocMatcher) lookup(value string) []iocIndicator {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil
+	}
+
+	bits, offset := 32, 0
+	if ip.To4() == nil {
+		bits, offset = 128, 1000
+	} else {
+		ip = ip.To4()
+	}
+
+	var found []iocIndicator
+	for _, prefix := range m.prefixes {
+		if (prefix >= 1000) != (bits == 128) {
+			continue
+		}
+		masked := ip.Mask(net.CIDRMask(prefix-offset, bits))
+		found = append(found, m.networks[prefix][masked.String()]...)
+	}
+	return found
+}
+
+// match returns the tag of a flow between the two ips.
+func (m *iocMatcher) match(initiatorIP string, targetIP string) *IOCMatch {
+	tag := &IOCMatch{}
+	sources := map[string]bool{}
+	indicators := map[string]bool{}
+
+	for _, ip := range []string{initiatorIP, targetIP} {
+		found := m.lookup(ip)
+		if len(found) == 0 {
+			continue
+		}
+
+		tag.Matched = true
+		tag.IPs = append(tag.IPs, ip)
+		for _, indicator := range found {
+			if !sources[indicator.source] {
+				sources[indicator.source] = true
+				tag.Sources = append(tag.Sources, indicator.source)
+			}
+			if !indicators[indicator.indicator] {
+				indicators[indicator.indicator] = true
+				tag.Indicators = append(tag.Indicators, indicator.indicator)
+			}
+			if indicator.confidence > tag.Confidence {
+				tag.Confidence = indicator.confidence
+			}
+		}
+	}
+	return tag
+}
+
+// tagFlows sets the ioc tag on each flow of the object.
+func (m *iocMatcher) tagFlows(object *CosObject) {
+	if m == nil || object.FlowLogs == nil || len(*object.FlowLogs) == 0 {
+		return
+	}
+
+	flow := (*object.FlowLogs)[0]
+	var initiatorIP, targetIP string
+	if flow.InitiatorIP != nil {
+		initiatorIP = *flow.InitiatorIP
+	}
+	if flow.TargetIP != nil {
+		targetIP = *flow.TargetIP
+	}
+	object.IOC = m.match(initiatorIP, targetIP)
+}
+
+// loadIOCMatcher reads the lists configured under ioc.lists, it returns nil when no list is configured.
+func loadIOCMatcher(ioc config.IOC) (*iocMatcher, error) {
+	if err := config.Error(ioc.Validate()); err != nil {
+		return nil, err
+	}
+	if len(ioc.Lists) == 0 {
+		return nil, nil
+	}
+
+	matcher := newIOCMatcher()
+	for _, list := range ioc.Lists {
+		before := matcher.count
+		if err := loadIOCList(matcher, list); err != nil {
+			return nil, fmt.Errorf("ioc list %s: %v", list.Name, err)
+		}
+		logger.SystemLogger.Info("Loaded ioc list.", zap.String("list", list.Name), zap.String("file", list.File), zap.Int("indicators", matcher.count-before))
+	}
+	return matcher, nil
+}
+
+func loadIOCList(matcher *iocMatcher, list config.IOCList) error {
+	f, err := os.Open(list.File)
+	if err != nil {
+		return fmt.Errorf("os.Open: %v", err)
+	}
+	defer f.Close()
+
+	format := list.Format
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(list.File)) {
+		case ".json":
+			format = "stix"
+		case ".csv":
+			format = "csv"
+		default:
+			format = "plain"
+		}
+	}
+
+	switch format {
+	case "plain":
+		return loadPlainIOCList(matcher, list, f)
+	case "csv":
+		return loadCSVIOCList(matcher, list, f)
+	case "stix":
+		return loadSTIXIOCList(matcher, list, f)
+	}
+	return fmt.Errorf("invalid format %s, expecting plain, csv or stix", format)
+}
+
+// loadPlainIOCList reads one ip or CIDR block per line, blank lines and lines starting with # are ignored.
+func loadPlainIOCList(matcher *iocMatcher, list config.IOCList, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		value := strings.TrimSpace(scanner.Text())
+		if value == "" || strings.HasPrefix(value, "#") {
+			continue
+		}
+		if err := matcher.add(value, iocIndicator{source: list.Name, confidence: list.Confidence}); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("scanner.Scan: %v", err)
+	}
+	return nil
+}
+
+// loadCSVIOCList reads a CSV file whose header names an indicator (or ip) column and an optional confidence column,
+// a file without header has the indicator in the first column and the confidence in the second.
+func loadCSVIOCList(matcher *iocMatcher, list config.IOCList, r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+
+	indicatorColumn, confidenceColumn := 0, 1
+	line := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("csv.Read: %v", err)
+		}
+		line++
+
+		if line == 1 && validateIPOrCIDR(strings.TrimSpace(record[0])) != nil {
+			indicatorColumn, confidenceColumn = -1, -1
+			for i, name := range record {
+				switch strings.ToLower(strings.TrimSpace(name)) {
+				case "indicator", "ip", "value":
+					indicatorColumn = i
+				case "confidence":
+					confidenceColumn = i
+				}
+			}
+			if indicatorColumn < 0 {
+				return fmt.Errorf("no indicator, ip or value column in the header")
+			}
+			continue
+		}
+
+		if indicatorColumn >= len(record) {
+			return fmt.Errorf("line %d: missing indicator", line)
+		}
+
+		confidence := list.Confidence
+		if confidenceColumn >= 0 && confidenceColumn < len(record) && strings.TrimSpace(record[confidenceColumn]) != "" {
+			confidence, err = strconv.Atoi(strings.TrimSpace(record[confidenceColumn]))
+			if err != nil || confidence < 0 || confidence > 100 {
+				return fmt.Errorf("line %d: invalid confidence %q, expecting 0 to 100", line, record[confidenceColumn])
+			}
+		}
+
+		if err := matcher.add(record[indicatorColumn], iocIndicator{source: list.Name, confidence: confidence}); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+}
+
+// stixAddressPattern extracts the addresses compared for equality in a STIX pattern.
+var stixAddressPattern = regexp.MustCompile(`ipv[46]-addr:value\s*=\s*'([^']+)'`)
+
+// loadSTIXIOCList reads the ip addresses of the indicators of a STIX 2.1 bundle. Revoked and expired indicators are
+// skipped, the confidence of an indicator overrides the one of the list.
+func loadSTIXIOCList(matcher *iocMatcher, list config.IOCList, r io.Reader) error {
+	var bundle struct {
+		Type    string `json:"type"`
+		Objects []struct {
+			Type        string `json:"type"`
+			ID          string `json:"id"`
+			Pattern     string `json:"pattern"`
+			PatternType string `json:"pattern_type"`
+			ValidUntil  string `json:"valid_until"`
+			Revoked     bool   `json:"revoked"`
+			Confidence  *int   `json:"confidence"`
+		} `json:"objects"`
+	}
+	if err := json.NewDecoder(r).Decode(&bundle); err != nil {
+		return fmt.Errorf("json.Decode: %v", err)
+	}
+	if bundle.Type != "bundle" {
+		return fmt.Errorf("invalid STIX bundle, type is %q", bundle.Type)
+	}
+
+	now := time.Now().UTC()
+	for _, object := range bundle.Objects {
+		if object.Type != "indicator" || object.Revoked || (object.PatternType != "" && object.PatternType != "stix") {
+			continue
+		}
+		if object.ValidUntil != "" {
+			if validUntil, err := time.Parse(time.RFC3339, object.ValidUntil); err == nil && validUntil.Before(now) {
+				continue
+			}
+		}
+
+		confidence := list.Confidence
+		if object.Confidence != nil {
+			confidence = *object.Confidence
+		}
+
+		for _, address := range stixAddressPattern.FindAllStringSubmatch(object.Pattern, -1) {
+			if err := matcher.add(address[1], iocIndicator{source: list.Name, confidence: confidence}); err != nil {
+				return fmt.Errorf("indicator %s: %v", object.ID, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/config"
+)
+
+func TestLoadIOCLists(t *testing.T) {
+	list := config.IOCList{Name: "feed", Confidence: 50}
+	indicator := func(value string, confidence int) iocIndicator {
+		return iocIndicator{source: "feed", indicator: value, confidence: confidence}
+	}
+
+	tests := []struct {
+		name       string
+		load       func(matcher *iocMatcher, list config.IOCList, r io.Reader) error
+		content    string
+		indicators []iocIndicator
+		wantErr    bool
+	}{
+		{
+			name:       "plain",
+			load:       loadPlainIOCList,
+			content:    "# feed\n203.0.113.7\n\n198.51.100.0/24\n2001:db8::1\n",
+			indicators: []iocIndicator{indicator("203.0.113.7/32", 50), indicator("198.51.100.0/24", 50), indicator("2001:db8::1/128", 50)},
+		},
+		{
+			name:       "plain host bits cleared",
+			load:       loadPlainIOCList,
+			content:    "198.51.100.9/24\n",
+			indicators: []iocIndicator{indicator("198.51.100.0/24", 50)},
+		},
+		{name: "plain invalid", load: loadPlainIOCList, content: "203.0.113.7\nexample.com\n", wantErr: true},
+		{
+			name:       "csv without header",
+			load:       loadCSVIOCList,
+			content:    "203.0.113.7,90\n198.51.100.0/24\n",
+			indicators: []iocIndicator{indicator("203.0.113.7/32", 90), indicator("198.51.100.0/24", 50)},
+		},
+		{
+			name:       "csv with header",
+			load:       loadCSVIOCList,
+			content:    "first_seen,confidence,ip\n2020-12-01,80,203.0.113.7\n2020-12-02,,203.0.113.8\n",
+			indicators: []iocIndicator{indicator("203.0.113.7/32", 80), indicator("203.0.113.8/32", 50)},
+		},
+		{name: "csv header without indicator", load: loadCSVIOCList, content: "first_seen,confidence\n", wantErr: true},
+		{name: "csv invalid confidence", load: loadCSVIOCList, content: "203.0.113.7,101\n", wantErr: true},
+		{
+			name: "stix",
+			load: loadSTIXIOCList,
+			content: `{"type": "bundle", "objects": [
+				{"type": "indicator", "id": "indicator--1", "pattern_type": "stix", "confidence": 70,
+					"pattern": "[ipv4-addr:value = '203.0.113.7'] OR [ipv4-addr:value = '198.51.100.0/24']"},
+				{"type": "indicator", "id": "indicator--2", "pattern": "[ipv6-addr:value = '2001:db8::1']"},
+				{"type": "indicator", "id": "indicator--3", "revoked": true, "pattern": "[ipv4-addr:value = '192.0.2.1']"},
+				{"type": "indicator", "id": "indicator--4", "valid_until": "2000-01-01T00:00:00Z", "pattern": "[ipv4-addr:value = '192.0.2.2']"},
+				{"type": "indicator", "id": "indicator--5", "pattern_type": "snort", "pattern": "alert ip 192.0.2.3 any"},
+				{"type": "malware", "id": "malware--1"}
+			]}`,
+			indicators: []iocIndicator{indicator("203.0.113.7/32", 70), indicator("198.51.100.0/24", 70), indicator("2001:db8::1/128", 50)},
+		},
+		{name: "stix not a bundle", load: loadSTIXIOCList, content: `{"type": "indicator"}`, wantErr: true},
+		{name: "stix invalid json", load: loadSTIXIOCList, content: `{"type": `, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matcher := newIOCMatcher()
+			err := test.load(matcher, list, strings.NewReader(test.content))
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("load = %v, want an error", matcher.indicators)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("load: %v", err)
+			}
+			if !reflect.DeepEqual(matcher.indicators, test.indicators) {
+				t.Errorf("indicators = %+v, want %+v", matcher.indicators, test.indicators)
+			}
+		})
+	}
+}
+
+func TestIOCMatcherMatch(t *testing.T) {
+	matcher := newIOCMatcher()
+	for _, add := range []struct {
+		value      string
+		source     string
+		confidence int
+	}{
+		{"203.0.113.7", "feed", 90},
+		{"203.0.113.0/24", "blocks", 40},
+		{"10.0.0.0/8", "internal", 10},
+		{"2001:db8::/32", "blocks", 60},
+	} {
+		if err := matcher.add(add.value, iocIndicator{source: add.source, confidence: add.confidence}); err != nil {
+			t.Fatalf("add(%s): %v", add.value, err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		initiator string
+		target    string
+		want      IOCMatch
+	}{
+		{name: "no match", initiator: "192.0.2.1", target: "198.51.100.1", want: IOCMatch{}},
+		{
+			name:      "host and block",
+			initiator: "192.0.2.1",
+			target:    "203.0.113.7",
+			want:      IOCMatch{Matched: true, Sources: []string{"blocks", "feed"}, Confidence: 90, Indicators: []string{"203.0.113.0/24", "203.0.113.7/32"}, IPs: []string{"203.0.113.7"}},
+		},
+		{
+			name:      "both ips",
+			initiator: "10.1.2.3",
+			target:    "203.0.113.8",
+			want:      IOCMatch{Matched: true, Sources: []string{"internal", "blocks"}, Confidence: 40, Indicators: []string{"10.0.0.0/8", "203.0.113.0/24"}, IPs: []string{"10.1.2.3", "203.0.113.8"}},
+		},
+		{
+			name:      "ipv6",
+			initiator: "2001:db8:1::5",
+			want:      IOCMatch{Matched: true, Sources: []string{"blocks"}, Confidence: 60, Indicators: []string{"2001:db8::/32"}, IPs: []string{"2001:db8:1::5"}},
+		},
+		{name: "outside the ipv6 block and invalid ip", initiator: "2001:db9::1", target: "not an ip", want: IOCMatch{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := matcher.match(test.initiator, test.target); !reflect.DeepEqual(*got, test.want) {
+				t.Errorf("match = %+v, want %+v", *got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// csvRower is implemented by the results that can be written as CSV.
+type csvRower interface {
+	csvRow() []string
+}
+
+// printResults writes the results to stdout as a JSON array or, when format is csv, as CSV rows preceded by the header.
+func printResults(format string, header []string, results interface{}, rows []csvRower) error {
+	switch format {
+	case "json":
+		b, err := json.Marshal(results)
+		if err != nil {
+			return fmt.Errorf("json.Marshal: %v", err)
+		}
+		fmt.Println(string(b))
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write(header)
+		for _, row := range rows {
+			w.Write(row.csvRow())
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return fmt.Errorf("csv.Write: %v", err)
+		}
+	default:
+		return fmt.Errorf("invalid format %s, expecting json or csv", format)
+	}
+	return nil
+}
+
+func validateFormat(format string) error {
+	if format != "json" && format != "csv" {
+		return fmt.Errorf("invalid format %s, expecting json or csv", format)
+	}
+	return nil
+}
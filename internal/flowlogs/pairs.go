@@ -0,0 +1,321 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+// pairSubjects maps the names accepted for the talking side of a pair to their field.
+var pairSubjects = map[string]string{
+	"instance":  "instance_crn.keyword",
+	"initiator": "flow_logs.initiator_ip.keyword",
+}
+
+// PairsOptions holds the settings of the new communication pairs detection.
+type PairsOptions struct {
+	Filter       string
+	BaselineFrom string
+	From         string
+	To           string
+	By           string
+	Store        string
+	BaselineFile string
+	Relearn      bool
+	DryRun       bool
+}
+
+// pairEntry is a (subject, target, port, protocol) tuple, the subject is an instance crn or an initiator ip.
+type pairEntry struct {
+	By                string `json:"by"`
+	Subject           string `json:"subject"`
+	TargetIP          string `json:"target_ip"`
+	TargetPort        int64  `json:"target_port"`
+	TransportProtocol int64  `json:"transport_protocol"`
+	FirstSeen         string `json:"first_seen"`
+	LastSeen          string `json:"last_seen"`
+	Flows             int64  `json:"flows"`
+}
+
+func (p pairEntry) key() string {
+	return fmt.Sprintf("%s|%s|%s|%d|%d", p.By, p.Subject, p.TargetIP, p.TargetPort, p.TransportProtocol)
+}
+
+// merge returns the pair seen again, keeping when the stored pair was first seen.
+func (p pairEntry) merge(stored pairEntry) pairEntry {
+	if stored.FirstSeen != "" && stored.FirstSeen < p.FirstSeen {
+		p.FirstSeen = stored.FirstSeen
+	}
+	if stored.LastSeen > p.LastSeen {
+		p.LastSeen = stored.LastSeen
+	}
+	return p
+}
+
+// pairStore persists the baseline of the pairs already seen.
+type pairStore interface {
+	load(by string) (map[string]pairEntry, error)
+	save(entries []pairEntry) error
+}
+
+// localPairStore keeps the baseline in a JSON file.
+type localPairStore struct {
+	filename string
+}
+
+func (s *localPairStore) load(by string) (map[string]pairEntry, error) {
+	baseline := map[string]pairEntry{}
+
+	b, err := ioutil.ReadFile(s.filename)
+	if os.IsNotExist(err) {
+		return baseline, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ioutil.ReadFile: %v", err)
+	}
+
+	var entries []pairEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("invalid baseline in %s: %v", s.filename, err)
+	}
+	for _, entry := range entries {
+		if entry.By == by {
+			baseline[entry.key()] = entry
+		}
+	}
+	return baseline, nil
+}
+
+func (s *localPairStore) save(entries []pairEntry) error {
+	var all []pairEntry
+
+	b, err := ioutil.ReadFile(s.filename)
+	if err == nil {
+		if err := json.Unmarshal(b, &all); err != nil {
+			return fmt.Errorf("invalid baseline in %s: %v", s.filename, err)
+		}
+	}
+
+	byKey := map[string]int{}
+	for i, entry := range all {
+		byKey[entry.key()] = i
+	}
+	for _, entry := range entries {
+		if i, ok := byKey[entry.key()]; ok {
+			all[i] = entry.merge(all[i])
+		} else {
+			all = append(all, entry)
+		}
+	}
+
+	b, _ = json.MarshalIndent(all, "", "  ")
+	tmp := s.filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return fmt.Errorf("ioutil.WriteFile: %v", err)
+	}
+	if err := os.Rename(tmp, s.filename); err != nil {
+		return fmt.Errorf("os.Rename: %v", err)
+	}
+	return nil
+}
+
+// esPairStore keeps the baseline in a dedicated elasticsearch index, the document id is the sha256 of the tuple.
+type esPairStore struct {
+	esClient     *elasticsearch.Client
+	esIndexName  string
+	indexMapping string
+}
+
+func (s *esPairStore) load(by string) (map[string]pairEntry, error) {
+	if err := ensureIndex(s.esClient, s.esIndexName, s.indexMapping); err != nil {
+		return nil, err
+	}
+
+	baseline := map[string]pairEntry{}
+	query := map[string]interface{}{"bool": map[string]interface{}{"filter": termClause("by", by)}}
+
+	sort := []interface{}{
+		map[string]interface{}{"first_seen": "asc"},
+		map[string]interface{}{"subject": "asc"},
+		map[string]interface{}{"target_ip": "asc"},
+		map[string]interface{}{"target_port": "asc"},
+		map[string]interface{}{"transport_protocol": "asc"},
+	}
+	err := scanHitsBy(s.esClient, s.esIndexName, query, sort, nil, func(hit gjson.Result) error {
+		var entry pairEntry
+		if err := json.Unmarshal([]byte(hit.Get("_source").Raw), &entry); err != nil {
+			return fmt.Errorf("json.Unmarshal: %v", err)
+		}
+		baseline[entry.key()] = entry
+		return nil
+	})
+	return baseline, err
+}
+
+func (s *esPairStore) save(entries []pairEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	stored, err := s.load(entries[0].By)
+	if err != nil {
+		return err
+	}
+
+	documents := map[string]interface{}{}
+	for _, entry := range entries {
+		if old, ok := stored[entry.key()]; ok {
+			entry = entry.merge(old)
+		}
+		documents[fmt.Sprintf("%x", sha256.Sum256([]byte(entry.key())))] = entry
+	}
+	return bulkWrite(s.esClient, s.esIndexName, documents)
+}
+
+// DetectNewPairs function, returns the exit code of the command: 0 on success and 1 when it failed.
+func DetectNewPairs(options PairsOptions, trace bool) int {
+	err := detectNewPairs(options, trace)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+func detectNewPairs(options PairsOptions, trace bool) error {
+	if _, ok := pairSubjects[options.By]; !ok {
+		return fmt.Errorf("invalid subject %s, expecting instance or initiator", options.By)
+	}
+	if options.From == "" {
+		return fmt.Errorf("the start of the evaluation window is required")
+	}
+
+	learning, err := ParseFilter(options.Filter, options.BaselineFrom, options.From)
+	if err != nil {
+		return err
+	}
+	evaluation, _ := ParseFilter(options.Filter, options.From, options.To)
+
+	esClient, es, err := newElasticsearch(trace)
+	if err != nil {
+		return err
+	}
+	esIndexName := es.IndexName
+
+	var store pairStore
+	switch options.Store {
+	case "local":
+		filename := options.BaselineFile
+		if filename == "" {
+			filename = fmt.Sprintf("new-pairs-%s.json", options.By)
+		}
+		store = &localPairStore{filename: filename}
+	case "elasticsearch":
+		store = &esPairStore{esClient: esClient, esIndexName: es.PairsIndexName, indexMapping: es.PairsIndexMapping}
+	default:
+		return fmt.Errorf("invalid store %s, expecting local or elasticsearch", options.Store)
+	}
+
+	baseline, err := store.load(options.By)
+	if err != nil {
+		logger.ErrorLogger.Error("Error loading the baseline", zap.Error(err))
+		return err
+	}
+
+	if len(baseline) == 0 || options.Relearn {
+		learned, err := seenPairs(esClient, esIndexName, learning, options.By)
+		if err != nil {
+			logger.ErrorLogger.Error("Error getting response from search", zap.Error(err))
+			return err
+		}
+		logger.SystemLogger.Info("Learned the baseline pairs.", zap.Int("pairs", len(learned)), zap.String("from", options.BaselineFrom), zap.String("to", options.From))
+
+		for _, entry := range learned {
+			baseline[entry.key()] = entry
+		}
+		if !options.DryRun {
+			if err := store.save(learned); err != nil {
+				logger.ErrorLogger.Error("Error saving the baseline", zap.Error(err))
+				return err
+			}
+		}
+	}
+
+	seen, err := seenPairs(esClient, esIndexName, evaluation, options.By)
+	if err != nil {
+		logger.ErrorLogger.Error("Error getting response from search", zap.Error(err))
+		return err
+	}
+
+	newPairs := []pairEntry{}
+	for _, entry := range seen {
+		if _, ok := baseline[entry.key()]; !ok {
+			newPairs = append(newPairs, entry)
+		}
+	}
+	sort.Slice(newPairs, func(i, j int) bool { return newPairs[i].FirstSeen < newPairs[j].FirstSeen })
+
+	// The new pairs join the baseline so the next run only reports the pairs that appeared since.
+	if !options.DryRun && len(newPairs) > 0 {
+		if err := store.save(newPairs); err != nil {
+			logger.ErrorLogger.Error("Error saving the baseline", zap.Error(err))
+			return err
+		}
+	}
+
+	logger.SystemLogger.Info("Found new pairs.", zap.Int("new_pairs", len(newPairs)), zap.Int("pairs", len(seen)), zap.String("from", options.From), zap.String("to", options.To))
+
+	return printResults("json", nil, newPairs, nil)
+}
+
+// seenPairs returns every tuple seen in the flows matching the filter along with when it was first and last seen.
+func seenPairs(esClient *elasticsearch.Client, esIndexName string, filter *Filter, by string) ([]pairEntry, error) {
+	sources := []interface{}{
+		compositeSource("subject", pairSubjects[by]),
+		compositeSource("target_ip", "flow_logs.target_ip.keyword"),
+		compositeSource("target_port", "flow_logs.target_port"),
+		compositeSource("transport_protocol", "flow_logs.transport_protocol"),
+	}
+	aggs := map[string]interface{}{
+		"first_seen": map[string]interface{}{"min": map[string]interface{}{"field": "capture_start_time"}},
+		"last_seen":  map[string]interface{}{"max": map[string]interface{}{"field": "capture_end_time"}},
+	}
+
+	var entries []pairEntry
+	err := compositeBuckets(esClient, esIndexName, filter.Query(), sources, aggs, func(bucket gjson.Result) error {
+		entries = append(entries, pairEntry{
+			By:                by,
+			Subject:           bucket.Get("key.subject").String(),
+			TargetIP:          bucket.Get("key.target_ip").String(),
+			TargetPort:        bucket.Get("key.target_port").Int(),
+			TransportProtocol: bucket.Get("key.transport_protocol").Int(),
+			FirstSeen:         bucket.Get("first_seen.value_as_string").String(),
+			LastSeen:          bucket.Get("last_seen.value_as_string").String(),
+			Flows:             bucket.Get("doc_count").Int(),
+		})
+		return nil
+	})
+	return entries, err
+}
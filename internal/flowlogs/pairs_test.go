@@ -0,0 +1,104 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPairEntryMerge(t *testing.T) {
+	seen := pairEntry{FirstSeen: "2020-12-02T00:00:00Z", LastSeen: "2020-12-03T00:00:00Z", Flows: 5}
+
+	tests := []struct {
+		name   string
+		stored pairEntry
+		want   pairEntry
+	}{
+		{name: "not stored", want: seen},
+		{
+			name:   "first seen earlier",
+			stored: pairEntry{FirstSeen: "2020-12-01T00:00:00Z", LastSeen: "2020-12-01T00:00:00Z", Flows: 2},
+			want:   pairEntry{FirstSeen: "2020-12-01T00:00:00Z", LastSeen: "2020-12-03T00:00:00Z", Flows: 5},
+		},
+		{
+			name:   "stored range within the new one",
+			stored: pairEntry{FirstSeen: "2020-12-02T10:00:00Z", LastSeen: "2020-12-02T12:00:00Z"},
+			want:   seen,
+		},
+		{
+			name:   "last seen later",
+			stored: pairEntry{FirstSeen: "2020-12-02T00:00:00Z", LastSeen: "2020-12-04T00:00:00Z"},
+			want:   pairEntry{FirstSeen: "2020-12-02T00:00:00Z", LastSeen: "2020-12-04T00:00:00Z", Flows: 5},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := seen.merge(test.stored); got != test.want {
+				t.Errorf("merge = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLocalPairStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pairs")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	store := &localPairStore{filename: filepath.Join(dir, "baseline.json")}
+
+	baseline, err := store.load("instance")
+	if err != nil || len(baseline) != 0 {
+		t.Fatalf("load without a file = %v, %v, want an empty baseline", baseline, err)
+	}
+
+	ssh := pairEntry{By: "instance", Subject: "crn:a", TargetIP: "10.0.0.1", TargetPort: 22, TransportProtocol: 6, FirstSeen: "2020-12-02T00:00:00Z", LastSeen: "2020-12-02T00:00:00Z"}
+	dns := pairEntry{By: "initiator", Subject: "10.0.0.2", TargetIP: "10.0.0.1", TargetPort: 53, TransportProtocol: 17, FirstSeen: "2020-12-02T00:00:00Z", LastSeen: "2020-12-02T00:00:00Z"}
+	if err := store.save([]pairEntry{ssh, dns}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	again := ssh
+	again.FirstSeen = "2020-12-05T00:00:00Z"
+	again.LastSeen = "2020-12-05T00:00:00Z"
+	if err := store.save([]pairEntry{again}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	baseline, err = store.load("instance")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	want := ssh
+	want.LastSeen = again.LastSeen
+	if !reflect.DeepEqual(baseline, map[string]pairEntry{ssh.key(): want}) {
+		t.Errorf("load instance = %+v, want only %+v", baseline, want)
+	}
+
+	baseline, err = store.load("initiator")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !reflect.DeepEqual(baseline, map[string]pairEntry{dns.key(): dns}) {
+		t.Errorf("load initiator = %+v, want only %+v", baseline, dns)
+	}
+}
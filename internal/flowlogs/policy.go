@@ -0,0 +1,337 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+// policyRemotesSize is the number of remote addresses listed for each blocked instance and port.
+const policyRemotesSize = 10
+
+// protocolNumbers maps the protocol names accepted in the rules to their IANA number.
+var protocolNumbers = map[string]int64{
+	"icmp": 1,
+	"tcp":  6,
+	"udp":  17,
+}
+
+// PolicyOptions holds the settings of a policy simulation.
+type PolicyOptions struct {
+	RulesFile string
+	Filter    string
+	From      string
+	To        string
+	Format    string
+}
+
+// policyRule is read from the rules file. Like a network ACL the rules are evaluated in order and the first matching
+// rule decides, a security group is described with allow rules only and the default deny action.
+type policyRule struct {
+	Name      string `json:"name"`
+	Direction string `json:"direction"`
+	Protocol  string `json:"protocol"`
+	PortMin   int64  `json:"port_min"`
+	PortMax   int64  `json:"port_max"`
+	Remote    string `json:"remote"`
+	Action    string `json:"action"`
+	// Flows is the number of observed flows a recommended rule allows.
+	Flows int64 `json:"flows,omitempty"`
+
+	protocol int64
+	remote   *net.IPNet
+}
+
+type policy struct {
+	Rules         []policyRule `json:"rules"`
+	DefaultAction string       `json:"default_action"`
+}
+
+// matches reports whether the rule applies to traffic in direction, with the protocol, target port and remote ip.
+func (r *policyRule) matches(direction string, protocol int64, port int64, remote net.IP) bool {
+	if r.Direction != "" && r.Direction != direction {
+		return false
+	}
+	if r.protocol != 0 && r.protocol != protocol {
+		return false
+	}
+	if r.protocol != protocolNumbers["icmp"] && (r.PortMin != 0 || r.PortMax != 0) && (port < r.PortMin || port > r.PortMax) {
+		return false
+	}
+	return remote != nil && r.remote.Contains(remote)
+}
+
+// evaluate returns the action and the name of the rule deciding it, default when no rule matches.
+func (p *policy) evaluate(direction string, protocol int64, port int64, remote net.IP) (string, string) {
+	for _, rule := range p.Rules {
+		if rule.matches(direction, protocol, port, remote) {
+			return rule.Action, rule.Name
+		}
+	}
+	return p.DefaultAction, "default"
+}
+
+// blockedTraffic aggregates the accepted flows of an instance and port that the simulated rules would deny.
+type blockedTraffic struct {
+	InstanceCrn       string   `json:"instance_crn"`
+	Direction         string   `json:"direction"`
+	TransportProtocol int64    `json:"transport_protocol"`
+	TargetPort        int64    `json:"target_port"`
+	Rule              string   `json:"rule"`
+	Flows             int64    `json:"flows"`
+	Bytes             int64    `json:"bytes"`
+	FirstSeen         string   `json:"first_seen"`
+	LastSeen          string   `json:"last_seen"`
+	DistinctRemotes   int      `json:"distinct_remotes"`
+	Remotes           []string `json:"remotes"`
+
+	remotes map[string]bool
+}
+
+var blockedTrafficColumns = []string{
+	"instance_crn", "direction", "transport_protocol", "target_port", "rule", "flows", "bytes", "first_seen", "last_seen",
+	"distinct_remotes", "remotes",
+}
+
+func (b *blockedTraffic) csvRow() []string {
+	return []string{
+		b.InstanceCrn, b.Direction, strconv.FormatInt(b.TransportProtocol, 10), strconv.FormatInt(b.TargetPort, 10), b.Rule,
+		strconv.FormatInt(b.Flows, 10), strconv.FormatInt(b.Bytes, 10), b.FirstSeen, b.LastSeen,
+		strconv.Itoa(b.DistinctRemotes), strings.Join(b.Remotes, "|"),
+	}
+}
+
+// SimulatePolicy function, returns the exit code of the command: 0 on success and 1 when it failed.
+func SimulatePolicy(options PolicyOptions, trace bool) int {
+	err := simulatePolicy(options, trace)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+func simulatePolicy(options PolicyOptions, trace bool) error {
+	if err := validateFormat(options.Format); err != nil {
+		return err
+	}
+
+	p, err := loadPolicy(options.RulesFile)
+	if err != nil {
+		return err
+	}
+
+	filter, err := ParseFilter(options.Filter, options.From, options.To)
+	if err != nil {
+		return err
+	}
+
+	esClient, esIndexName, err := newElasticsearchClient(trace)
+	if err != nil {
+		return err
+	}
+
+	// Only the accepted flows are evaluated, the rejected ones are already blocked today.
+	query := map[string]interface{}{
+		"bool": map[string]interface{}{
+			"filter": []interface{}{
+				filter.Query(),
+				termClause(filterFields["action"], "accepted"),
+			},
+		},
+	}
+	sources := []interface{}{
+		compositeSource("instance_crn", "instance_crn.keyword"),
+		compositeSource("direction", "flow_logs.direction.keyword"),
+		compositeSource("transport_protocol", "flow_logs.transport_protocol"),
+		compositeSource("target_port", "flow_logs.target_port"),
+		compositeSource("initiator_ip", "flow_logs.initiator_ip.keyword"),
+		compositeSource("target_ip", "flow_logs.target_ip.keyword"),
+	}
+	aggs := map[string]interface{}{
+		"bytes":      trafficAggs()["bytes"],
+		"first_seen": map[string]interface{}{"min": map[string]interface{}{"field": "capture_start_time"}},
+		"last_seen":  map[string]interface{}{"max": map[string]interface{}{"field": "capture_end_time"}},
+	}
+
+	blocked := map[string]*blockedTraffic{}
+	var evaluated, denied int64
+
+	err = compositeBuckets(esClient, esIndexName, query, sources, aggs, func(bucket gjson.Result) error {
+		direction := bucket.Get("key.direction").String()
+		protocol := bucket.Get("key.transport_protocol").Int()
+		port := bucket.Get("key.target_port").Int()
+
+		// The remote end is the initiator of inbound traffic and the target of outbound traffic.
+		remote := bucket.Get("key.target_ip").String()
+		if direction == "inbound" {
+			remote = bucket.Get("key.initiator_ip").String()
+		}
+
+		flows := bucket.Get("doc_count").Int()
+		evaluated += flows
+
+		action, rule := p.evaluate(direction, protocol, port, net.ParseIP(remote))
+		if action != "deny" {
+			return nil
+		}
+		denied += flows
+
+		instance := bucket.Get("key.instance_crn").String()
+		key := fmt.Sprintf("%s|%s|%d|%d|%s", instance, direction, protocol, port, rule)
+		b, ok := blocked[key]
+		if !ok {
+			b = &blockedTraffic{
+				InstanceCrn:       instance,
+				Direction:         direction,
+				TransportProtocol: protocol,
+				TargetPort:        port,
+				Rule:              rule,
+				FirstSeen:         bucket.Get("first_seen.value_as_string").String(),
+				LastSeen:          bucket.Get("last_seen.value_as_string").String(),
+				remotes:           map[string]bool{},
+			}
+			blocked[key] = b
+		}
+
+		b.Flows += flows
+		b.Bytes += bucket.Get("bytes.value").Int()
+		if firstSeen := bucket.Get("first_seen.value_as_string").String(); firstSeen < b.FirstSeen {
+			b.FirstSeen = firstSeen
+		}
+		if lastSeen := bucket.Get("last_seen.value_as_string").String(); lastSeen > b.LastSeen {
+			b.LastSeen = lastSeen
+		}
+		b.remotes[remote] = true
+		return nil
+	})
+	if err != nil {
+		logger.ErrorLogger.Error("Error getting response from search", zap.Error(err))
+		return err
+	}
+
+	results := []*blockedTraffic{}
+	for _, b := range blocked {
+		b.DistinctRemotes = len(b.remotes)
+		for remote := range b.remotes {
+			b.Remotes = append(b.Remotes, remote)
+		}
+		sort.Strings(b.Remotes)
+		if len(b.Remotes) > policyRemotesSize {
+			b.Remotes = b.Remotes[:policyRemotesSize]
+		}
+		results = append(results, b)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Flows != results[j].Flows {
+			return results[i].Flows > results[j].Flows
+		}
+		return results[i].InstanceCrn < results[j].InstanceCrn
+	})
+
+	logger.SystemLogger.Info("Simulated the rules.", zap.String("file", options.RulesFile), zap.Int64("accepted_flows", evaluated),
+		zap.Int64("blocked_flows", denied))
+
+	var rows []csvRower
+	for _, b := range results {
+		rows = append(rows, b)
+	}
+	return printResults(options.Format, blockedTrafficColumns, results, rows)
+}
+
+// loadPolicy reads and validates the rules file, reporting the first invalid rule.
+func loadPolicy(rulesFile string) (*policy, error) {
+	b, err := ioutil.ReadFile(rulesFile)
+	if err != nil {
+		return nil, fmt.Errorf("ioutil.ReadFile: %v", err)
+	}
+
+	var p policy
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("invalid rules in %s: %v", rulesFile, err)
+	}
+	if len(p.Rules) == 0 {
+		return nil, fmt.Errorf("no rules found in %s", rulesFile)
+	}
+
+	if p.DefaultAction == "" {
+		p.DefaultAction = "deny"
+	}
+	if p.DefaultAction != "allow" && p.DefaultAction != "deny" {
+		return nil, fmt.Errorf("invalid default_action %s, expecting allow or deny", p.DefaultAction)
+	}
+
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+
+		if rule.Direction != "" && rule.Direction != "inbound" && rule.Direction != "outbound" {
+			return nil, fmt.Errorf("rule %s: invalid direction %s, expecting inbound or outbound", rule.Name, rule.Direction)
+		}
+		if rule.Action != "allow" && rule.Action != "deny" {
+			return nil, fmt.Errorf("rule %s: invalid action %q, expecting allow or deny", rule.Name, rule.Action)
+		}
+
+		switch protocol := strings.ToLower(rule.Protocol); protocol {
+		case "", "all":
+			rule.protocol = 0
+		default:
+			number, ok := protocolNumbers[protocol]
+			if !ok {
+				if number, err = strconv.ParseInt(protocol, 10, 64); err != nil || number < 0 || number > 255 {
+					return nil, fmt.Errorf("rule %s: invalid protocol %s, expecting all, tcp, udp, icmp or a protocol number", rule.Name, rule.Protocol)
+				}
+			}
+			rule.protocol = number
+		}
+
+		if rule.PortMax == 0 {
+			rule.PortMax = rule.PortMin
+		}
+		if rule.PortMin < 0 || rule.PortMax > 65535 || rule.PortMin > rule.PortMax {
+			return nil, fmt.Errorf("rule %s: invalid port range %d-%d", rule.Name, rule.PortMin, rule.PortMax)
+		}
+
+		remote := rule.Remote
+		if remote == "" {
+			remote = "0.0.0.0/0"
+		}
+		if net.ParseIP(remote) != nil {
+			if strings.Contains(remote, ":") {
+				remote += "/128"
+			} else {
+				remote += "/32"
+			}
+		}
+		if _, rule.remote, err = net.ParseCIDR(remote); err != nil {
+			return nil, fmt.Errorf("rule %s: invalid remote %s, expecting an ip or a CIDR block", rule.Name, rule.Remote)
+		}
+	}
+
+	return &p, nil
+}
@@ -0,0 +1,78 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPolicyEvaluate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	rulesFile := filepath.Join(dir, "rules.json")
+	rules := `{"rules": [
+		{"name": "deny-bastion", "direction": "inbound", "protocol": "tcp", "port_min": 22, "remote": "203.0.113.7", "action": "deny"},
+		{"name": "ssh", "direction": "inbound", "protocol": "tcp", "port_min": 22, "remote": "203.0.113.0/24", "action": "allow"},
+		{"name": "web", "direction": "inbound", "protocol": "tcp", "port_min": 80, "port_max": 443, "action": "allow"},
+		{"name": "ping", "protocol": "icmp", "port_min": 8, "action": "allow"},
+		{"direction": "outbound", "action": "allow"}
+	]}`
+	if err := ioutil.WriteFile(rulesFile, []byte(rules), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile: %v", err)
+	}
+	p, err := loadPolicy(rulesFile)
+	if err != nil {
+		t.Fatalf("loadPolicy: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		direction string
+		protocol  int64
+		port      int64
+		remote    string
+		action    string
+		rule      string
+	}{
+		{name: "first matching rule decides", direction: "inbound", protocol: 6, port: 22, remote: "203.0.113.7", action: "deny", rule: "deny-bastion"},
+		{name: "remote in the block", direction: "inbound", protocol: 6, port: 22, remote: "203.0.113.8", action: "allow", rule: "ssh"},
+		{name: "remote outside the block", direction: "inbound", protocol: 6, port: 22, remote: "198.51.100.1", action: "deny", rule: "default"},
+		{name: "port in the range", direction: "inbound", protocol: 6, port: 100, remote: "198.51.100.1", action: "allow", rule: "web"},
+		{name: "port above the range", direction: "inbound", protocol: 6, port: 444, remote: "198.51.100.1", action: "deny", rule: "default"},
+		{name: "other protocol", direction: "inbound", protocol: 17, port: 80, remote: "198.51.100.1", action: "deny", rule: "default"},
+		{name: "icmp ignores the port", direction: "inbound", protocol: 1, remote: "198.51.100.1", action: "allow", rule: "ping"},
+		{name: "unnamed rule", direction: "outbound", protocol: 17, port: 53, remote: "10.0.0.1", action: "allow", rule: "rule-5"},
+		{name: "ipv6 outside the default remote", direction: "inbound", protocol: 6, port: 80, remote: "2001:db8::1", action: "deny", rule: "default"},
+		{name: "no remote", direction: "inbound", protocol: 6, port: 80, action: "deny", rule: "default"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			action, rule := p.evaluate(test.direction, test.protocol, test.port, net.ParseIP(test.remote))
+			if action != test.action || rule != test.rule {
+				t.Errorf("evaluate = %s, %s, want %s, %s", action, rule, test.action, test.rule)
+			}
+		})
+	}
+}
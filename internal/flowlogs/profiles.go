@@ -0,0 +1,152 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/config"
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"go.uber.org/zap"
+)
+
+// ProfilesOptions holds the settings of an index run across the profiles.
+type ProfilesOptions struct {
+	Parallel int
+}
+
+// IndexProfiles runs index once per profile of the configuration file, each in its own process so every profile
+// keeps its own configuration, logs, metrics and report. args are the flags passed to each index run. It returns the
+// worst exit code of the runs, 1 over 2 over 0.
+func IndexProfiles(options ProfilesOptions, args []string) int {
+	profiles := config.Profiles()
+	if len(profiles) == 0 {
+		fmt.Println("no profiles found in the configuration file")
+		return 1
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		fmt.Println(fmt.Errorf("os.Executable: %v", err))
+		return 1
+	}
+
+	parallel := options.Parallel
+	if parallel <= 0 || parallel > len(profiles) {
+		parallel = len(profiles)
+	}
+	logger.SystemLogger.Info("Indexing the profiles.", zap.Strings("profiles", profiles), zap.Int("parallel", parallel))
+
+	var (
+		wg    sync.WaitGroup
+		outMu sync.Mutex
+		slots = make(chan struct{}, parallel)
+		codes = make([]int, len(profiles))
+	)
+	for i, name := range profiles {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, name string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			codes[i] = indexProfile(executable, name, args, &outMu)
+		}(i, name)
+	}
+	wg.Wait()
+
+	code := 0
+	for i, name := range profiles {
+		fmt.Printf("%s: exit code %d\n", name, codes[i])
+		switch {
+		case codes[i] == 0:
+		case codes[i] == 2 && code == 0:
+			code = 2
+		case codes[i] != 2:
+			code = 1
+		}
+	}
+	return code
+}
+
+// indexProfile runs index for the profile and returns its exit code, its output is prefixed with the profile name.
+func indexProfile(executable string, name string, args []string, outMu *sync.Mutex) int {
+	start := time.Now()
+	logger.SystemLogger.Info("Started indexing the profile.", zap.String("profile", name))
+
+	stdout := &prefixWriter{prefix: "[" + name + "] ", out: os.Stdout, mu: outMu}
+	stderr := &prefixWriter{prefix: "[" + name + "] ", out: os.Stderr, mu: outMu}
+	cmd := exec.Command(executable, append([]string{"index", "--profile", name}, args...)...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	stdout.flush()
+	stderr.flush()
+
+	code := 0
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		code = exitErr.ExitCode()
+	case err != nil:
+		code = 1
+		logger.ErrorLogger.Error("Error running index for the profile.", zap.String("profile", name), zap.Error(err))
+	}
+
+	logger.SystemLogger.Info("Finished indexing the profile.", zap.String("profile", name), zap.Int("exit_code", code),
+		zap.Duration("duration", time.Since(start)))
+	return code
+}
+
+// prefixWriter writes the complete lines of a run to out with the prefix, under mu so the lines of the runs in
+// parallel do not interleave.
+type prefixWriter struct {
+	prefix string
+	out    io.Writer
+	mu     *sync.Mutex
+	buf    []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.writeLine(w.buf[:i+1])
+		w.buf = w.buf[i+1:]
+	}
+}
+
+func (w *prefixWriter) flush() {
+	if len(w.buf) > 0 {
+		w.writeLine(append(w.buf, '\n'))
+		w.buf = nil
+	}
+}
+
+func (w *prefixWriter) writeLine(line []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fmt.Fprintf(w.out, "%s%s", w.prefix, line)
+}
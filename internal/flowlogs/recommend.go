@@ -0,0 +1,323 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+// recommendSubjects maps the names accepted for the subject of the recommendations to their field.
+var recommendSubjects = map[string]string{
+	"interface": "network_interface_id",
+	"instance":  "instance_crn",
+}
+
+// RecommendOptions holds the settings of the least-privilege rules recommendation.
+type RecommendOptions struct {
+	By           string
+	Filter       string
+	From         string
+	To           string
+	Prefix       int
+	Threshold    int
+	EphemeralMin int64
+}
+
+// recommendation is the rule set of a subject, it has the layout of the policy simulate rules file.
+type recommendation struct {
+	By            string       `json:"by"`
+	Subject       string       `json:"subject"`
+	InstanceCrn   string       `json:"instance_crn,omitempty"`
+	Flows         int64        `json:"flows"`
+	DefaultAction string       `json:"default_action"`
+	Rules         []policyRule `json:"rules"`
+}
+
+// recommendedRuleSet is the output of the recommendation.
+type recommendedRuleSet struct {
+	From     string            `json:"from,omitempty"`
+	To       string            `json:"to,omitempty"`
+	Filter   string            `json:"filter,omitempty"`
+	Subjects []*recommendation `json:"subjects"`
+}
+
+// serviceKey identifies the traffic allowed by a rule before the ports are grouped into ranges.
+type serviceKey struct {
+	direction string
+	protocol  int64
+	remote    string
+}
+
+// reverseDirection is the direction of a connection seen from its reply.
+var reverseDirection = map[string]string{"inbound": "outbound", "outbound": "inbound"}
+
+// observedPorts holds the flows seen from each remote ip, per port.
+type observedPorts map[int64]map[string]int64
+
+// RecommendRules function, returns the exit code of the command: 0 on success and 1 when it failed.
+func RecommendRules(options RecommendOptions, trace bool) int {
+	err := recommendRules(options, trace)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+func recommendRules(options RecommendOptions, trace bool) error {
+	subjectField, ok := recommendSubjects[options.By]
+	if !ok {
+		return fmt.Errorf("invalid subject %s, expecting interface or instance", options.By)
+	}
+	if options.Prefix < 1 || options.Prefix > 32 {
+		return fmt.Errorf("invalid prefix %d, expecting 1 to 32", options.Prefix)
+	}
+	if options.Threshold < 1 {
+		return fmt.Errorf("invalid threshold %d", options.Threshold)
+	}
+
+	filter, err := ParseFilter(options.Filter, options.From, options.To)
+	if err != nil {
+		return err
+	}
+
+	esClient, esIndexName, err := newElasticsearchClient(trace)
+	if err != nil {
+		return err
+	}
+
+	query := map[string]interface{}{
+		"bool": map[string]interface{}{
+			"filter": []interface{}{
+				filter.Query(),
+				termClause(filterFields["action"], "accepted"),
+			},
+		},
+	}
+	sources := []interface{}{
+		compositeSource("subject", filterFields[subjectField]),
+		compositeSource("instance_crn", filterFields["instance_crn"]),
+		compositeSource("direction", filterFields["direction"]),
+		compositeSource("transport_protocol", filterFields["transport_protocol"]),
+		compositeSource("target_port", filterFields["target_port"]),
+		compositeSource("initiator_port", filterFields["initiator_port"]),
+		compositeSource("was_initiated", filterFields["was_initiated"]),
+		compositeSource("initiator_ip", "flow_logs.initiator_ip.keyword"),
+		compositeSource("target_ip", "flow_logs.target_ip.keyword"),
+	}
+
+	observed := map[string]map[serviceKey]observedPorts{}
+	recommendations := map[string]*recommendation{}
+	var skipped int64
+
+	err = compositeBuckets(esClient, esIndexName, query, sources, nil, func(bucket gjson.Result) error {
+		subject := bucket.Get("key.subject").String()
+		direction := bucket.Get("key.direction").String()
+		protocol := bucket.Get("key.transport_protocol").Int()
+		port := bucket.Get("key.target_port").Int()
+		flows := bucket.Get("doc_count").Int()
+
+		remote := bucket.Get("key.target_ip").String()
+		if direction == "inbound" {
+			remote = bucket.Get("key.initiator_ip").String()
+		}
+
+		// A target port in the ephemeral range is only trusted as a service port when the connection was seen starting,
+		// otherwise the flow is likely the reply side of a connection: the service port is the initiator port and the
+		// connection goes the other way. Flows with both ports in the range are ignored.
+		if protocol == protocolNumbers["icmp"] {
+			port = 0
+		} else if port >= options.EphemeralMin && !bucket.Get("key.was_initiated").Bool() {
+			port = bucket.Get("key.initiator_port").Int()
+			if port >= options.EphemeralMin {
+				skipped += flows
+				return nil
+			}
+			direction = reverseDirection[direction]
+		}
+
+		r, ok := recommendations[subject]
+		if !ok {
+			r = &recommendation{By: subjectField, Subject: subject, DefaultAction: "deny"}
+			if subjectField != "instance_crn" {
+				r.InstanceCrn = bucket.Get("key.instance_crn").String()
+			}
+			recommendations[subject] = r
+			observed[subject] = map[serviceKey]observedPorts{}
+		}
+		r.Flows += flows
+
+		service := serviceKey{direction: direction, protocol: protocol}
+		if observed[subject][service] == nil {
+			observed[subject][service] = observedPorts{}
+		}
+		if observed[subject][service][port] == nil {
+			observed[subject][service][port] = map[string]int64{}
+		}
+		observed[subject][service][port][remote] += flows
+		return nil
+	})
+	if err != nil {
+		logger.ErrorLogger.Error("Error getting response from search", zap.Error(err))
+		return err
+	}
+
+	// The remote ips of each port are collapsed into CIDR blocks, then the ports allowed from the same block are grouped
+	// into ranges.
+	ports := map[string]map[serviceKey]map[int64]int64{}
+	for subject, services := range observed {
+		ports[subject] = map[serviceKey]map[int64]int64{}
+		for service, byPort := range services {
+			for port, ips := range byPort {
+				for block, flows := range collapseRemotes(ips, options.Prefix, options.Threshold) {
+					key := serviceKey{direction: service.direction, protocol: service.protocol, remote: block}
+					if ports[subject][key] == nil {
+						ports[subject][key] = map[int64]int64{}
+					}
+					ports[subject][key][port] += flows
+				}
+			}
+		}
+	}
+
+	results := []*recommendation{}
+	for subject, r := range recommendations {
+		for key, flowsByPort := range ports[subject] {
+			for _, portRange := range portRanges(flowsByPort) {
+				rule := policyRule{
+					Direction: key.direction,
+					Protocol:  protocolName(key.protocol),
+					PortMin:   portRange[0],
+					PortMax:   portRange[1],
+					Remote:    key.remote,
+					Action:    "allow",
+					Flows:     portRange[2],
+				}
+				rule.Name = recommendedRuleName(rule)
+				r.Rules = append(r.Rules, rule)
+			}
+		}
+
+		sort.Slice(r.Rules, func(i, j int) bool {
+			a, b := r.Rules[i], r.Rules[j]
+			if a.Direction != b.Direction {
+				return a.Direction < b.Direction
+			}
+			if a.Protocol != b.Protocol {
+				return a.Protocol < b.Protocol
+			}
+			if a.PortMin != b.PortMin {
+				return a.PortMin < b.PortMin
+			}
+			return a.Remote < b.Remote
+		})
+		results = append(results, r)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Subject < results[j].Subject })
+
+	logger.SystemLogger.Info("Recommended rules.", zap.Int("subjects", len(results)), zap.Int64("skipped_ephemeral_flows", skipped))
+
+	return printResults("json", nil, recommendedRuleSet{From: options.From, To: options.To, Filter: options.Filter, Subjects: results}, nil)
+}
+
+// collapseRemotes returns the CIDR blocks allowing the remote ips along with their flows: the ips of a /prefix block
+// seen at least threshold times are replaced by the block, the others are kept as /32.
+func collapseRemotes(ips map[string]int64, prefix int, threshold int) map[string]int64 {
+	blocks := map[string][]string{}
+	for ip := range ips {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			continue
+		}
+
+		if parsed.To4() == nil {
+			blocks[ip+"/128"] = append(blocks[ip+"/128"], ip)
+			continue
+		}
+		block := (&net.IPNet{IP: parsed.To4().Mask(net.CIDRMask(prefix, 32)), Mask: net.CIDRMask(prefix, 32)}).String()
+		blocks[block] = append(blocks[block], ip)
+	}
+
+	collapsed := map[string]int64{}
+	for block, members := range blocks {
+		if len(members) >= threshold {
+			for _, ip := range members {
+				collapsed[block] += ips[ip]
+			}
+			continue
+		}
+		for _, ip := range members {
+			if net.ParseIP(ip).To4() != nil {
+				collapsed[ip+"/32"] += ips[ip]
+			} else {
+				collapsed[ip+"/128"] += ips[ip]
+			}
+		}
+	}
+	return collapsed
+}
+
+// portRanges groups contiguous ports into [low, high, flows] ranges.
+func portRanges(flowsByPort map[int64]int64) [][3]int64 {
+	var ports []int64
+	for port := range flowsByPort {
+		ports = append(ports, port)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+
+	var ranges [][3]int64
+	for _, port := range ports {
+		if n := len(ranges); n > 0 && ranges[n-1][1]+1 == port {
+			ranges[n-1][1] = port
+			ranges[n-1][2] += flowsByPort[port]
+			continue
+		}
+		ranges = append(ranges, [3]int64{port, port, flowsByPort[port]})
+	}
+	return ranges
+}
+
+// protocolName returns the name of a protocol number as accepted in the rules.
+func protocolName(protocol int64) string {
+	for name, number := range protocolNumbers {
+		if number == protocol {
+			return name
+		}
+	}
+	return strconv.FormatInt(protocol, 10)
+}
+
+func recommendedRuleName(rule policyRule) string {
+	ports := ""
+	if rule.PortMin != 0 {
+		ports = fmt.Sprintf("-%d", rule.PortMin)
+		if rule.PortMax != rule.PortMin {
+			ports += fmt.Sprintf("-%d", rule.PortMax)
+		}
+	}
+	remote := "to"
+	if rule.Direction == "inbound" {
+		remote = "from"
+	}
+	return fmt.Sprintf("allow-%s-%s%s-%s-%s", rule.Direction, rule.Protocol, ports, remote, rule.Remote)
+}
@@ -0,0 +1,97 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCollapseRemotes(t *testing.T) {
+	tests := []struct {
+		name      string
+		ips       map[string]int64
+		prefix    int
+		threshold int
+		want      map[string]int64
+	}{
+		{
+			name:      "below the threshold",
+			ips:       map[string]int64{"10.0.1.5": 2, "10.0.1.6": 3},
+			prefix:    24,
+			threshold: 3,
+			want:      map[string]int64{"10.0.1.5/32": 2, "10.0.1.6/32": 3},
+		},
+		{
+			name:      "collapsed into the block",
+			ips:       map[string]int64{"10.0.1.5": 2, "10.0.1.6": 3, "10.0.1.200": 1, "10.0.2.1": 4},
+			prefix:    24,
+			threshold: 3,
+			want:      map[string]int64{"10.0.1.0/24": 6, "10.0.2.1/32": 4},
+		},
+		{
+			name:      "ipv6 kept as is",
+			ips:       map[string]int64{"2001:db8::1": 1, "2001:db8::2": 1},
+			prefix:    24,
+			threshold: 2,
+			want:      map[string]int64{"2001:db8::1/128": 1, "2001:db8::2/128": 1},
+		},
+		{
+			name:      "invalid ip ignored",
+			ips:       map[string]int64{"not an ip": 1, "192.168.0.1": 1},
+			prefix:    16,
+			threshold: 2,
+			want:      map[string]int64{"192.168.0.1/32": 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := collapseRemotes(test.ips, test.prefix, test.threshold); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("collapseRemotes = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPortRanges(t *testing.T) {
+	tests := []struct {
+		name  string
+		ports map[int64]int64
+		want  [][3]int64
+	}{
+		{name: "none"},
+		{name: "single port", ports: map[int64]int64{443: 5}, want: [][3]int64{{443, 443, 5}}},
+		{
+			name:  "contiguous ports grouped",
+			ports: map[int64]int64{8080: 1, 8081: 2, 8082: 3},
+			want:  [][3]int64{{8080, 8082, 6}},
+		},
+		{
+			name:  "gaps split the ranges",
+			ports: map[int64]int64{22: 1, 80: 2, 443: 3, 444: 4, 446: 5},
+			want:  [][3]int64{{22, 22, 1}, {80, 80, 2}, {443, 444, 7}, {446, 446, 5}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := portRanges(test.ports); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("portRanges = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"strconv"
+
+	"github.com/tidwall/gjson"
+)
+
+// flowRecord is a single indexed flow along with the metadata of the COS object it was read from.
+type flowRecord struct {
+	ID                             string `json:"id"`
+	Version                        string `json:"version"`
+	CollectorCrn                   string `json:"collector_crn"`
+	AttachedEndpointType           string `json:"attached_endpoint_type"`
+	NetworkInterfaceID             string `json:"network_interface_id"`
+	InstanceCrn                    string `json:"instance_crn"`
+	VpcCrn                         string `json:"vpc_crn"`
+	CaptureStartTime               string `json:"capture_start_time"`
+	CaptureEndTime                 string `json:"capture_end_time"`
+	State                          string `json:"state"`
+	StartTime                      string `json:"start_time"`
+	EndTime                        string `json:"end_time"`
+	ConnectionStartTime            string `json:"connection_start_time"`
+	Direction                      string `json:"direction"`
+	Action                         string `json:"action"`
+	InitiatorIP                    string `json:"initiator_ip"`
+	TargetIP                       string `json:"target_ip"`
+	InitiatorPort                  int64  `json:"initiator_port"`
+	TargetPort                     int64  `json:"target_port"`
+	TransportProtocol              int64  `json:"transport_protocol"`
+	EtherType                      string `json:"ether_type"`
+	WasInitiated                   bool   `json:"was_initiated"`
+	WasTerminated                  bool   `json:"was_terminated"`
+	BytesFromInitiator             int64  `json:"bytes_from_initiator"`
+	PacketsFromInitiator           int64  `json:"packets_from_initiator"`
+	BytesFromTarget                int64  `json:"bytes_from_target"`
+	PacketsFromTarget              int64  `json:"packets_from_target"`
+	CumulativeBytesFromInitiator   int64  `json:"cumulative_bytes_from_initiator"`
+	CumulativePacketsFromInitiator int64  `json:"cumulative_packets_from_initiator"`
+	CumulativeBytesFromTarget      int64  `json:"cumulative_bytes_from_target"`
+	CumulativePacketsFromTarget    int64  `json:"cumulative_packets_from_target"`
+}
+
+// flowRecordColumns is the header used when writing flow records as CSV, in the same order as csvRow.
+var flowRecordColumns = []string{
+	"id", "version", "collector_crn", "attached_endpoint_type", "network_interface_id", "instance_crn", "vpc_crn",
+	"capture_start_time", "capture_end_time", "state", "start_time", "end_time", "connection_start_time",
+	"direction", "action", "initiator_ip", "target_ip", "initiator_port", "target_port", "transport_protocol",
+	"ether_type", "was_initiated", "was_terminated", "bytes_from_initiator", "packets_from_initiator",
+	"bytes_from_target", "packets_from_target", "cumulative_bytes_from_initiator", "cumulative_packets_from_initiator",
+	"cumulative_bytes_from_target", "cumulative_packets_from_target",
+}
+
+// newFlowRecord builds a flow record from a search hit, each indexed document holds a single flow in flow_logs.
+func newFlowRecord(hit gjson.Result) flowRecord {
+	source := hit.Get("_source")
+	flow := source.Get("flow_logs.0")
+
+	return flowRecord{
+		ID:                             hit.Get("_id").String(),
+		Version:                        source.Get("version").String(),
+		CollectorCrn:                   source.Get("collector_crn").String(),
+		AttachedEndpointType:           source.Get("attached_endpoint_type").String(),
+		NetworkInterfaceID:             source.Get("network_interface_id").String(),
+		InstanceCrn:                    source.Get("instance_crn").String(),
+		VpcCrn:                         source.Get("vpc_crn").String(),
+		CaptureStartTime:               source.Get("capture_start_time").String(),
+		CaptureEndTime:                 source.Get("capture_end_time").String(),
+		State:                          source.Get("state").String(),
+		StartTime:                      flow.Get("start_time").String(),
+		EndTime:                        flow.Get("end_time").String(),
+		ConnectionStartTime:            flow.Get("connection_start_time").String(),
+		Direction:                      flow.Get("direction").String(),
+		Action:                         flow.Get("action").String(),
+		InitiatorIP:                    flow.Get("initiator_ip").String(),
+		TargetIP:                       flow.Get("target_ip").String(),
+		InitiatorPort:                  flow.Get("initiator_port").Int(),
+		TargetPort:                     flow.Get("target_port").Int(),
+		TransportProtocol:              flow.Get("transport_protocol").Int(),
+		EtherType:                      flow.Get("ether_type").String(),
+		WasInitiated:                   flow.Get("was_initiated").Bool(),
+		WasTerminated:                  flow.Get("was_terminated").Bool(),
+		BytesFromInitiator:             flow.Get("bytes_from_initiator").Int(),
+		PacketsFromInitiator:           flow.Get("packets_from_initiator").Int(),
+		BytesFromTarget:                flow.Get("bytes_from_target").Int(),
+		PacketsFromTarget:              flow.Get("packets_from_target").Int(),
+		CumulativeBytesFromInitiator:   flow.Get("cumulative_bytes_from_initiator").Int(),
+		CumulativePacketsFromInitiator: flow.Get("cumulative_packets_from_initiator").Int(),
+		CumulativeBytesFromTarget:      flow.Get("cumulative_bytes_from_target").Int(),
+		CumulativePacketsFromTarget:    flow.Get("cumulative_packets_from_target").Int(),
+	}
+}
+
+func (r flowRecord) csvRow() []string {
+	return []string{
+		r.ID, r.Version, r.CollectorCrn, r.AttachedEndpointType, r.NetworkInterfaceID, r.InstanceCrn, r.VpcCrn,
+		r.CaptureStartTime, r.CaptureEndTime, r.State, r.StartTime, r.EndTime, r.ConnectionStartTime,
+		r.Direction, r.Action, r.InitiatorIP, r.TargetIP,
+		strconv.FormatInt(r.InitiatorPort, 10), strconv.FormatInt(r.TargetPort, 10), strconv.FormatInt(r.TransportProtocol, 10),
+		r.EtherType, strconv.FormatBool(r.WasInitiated), strconv.FormatBool(r.WasTerminated),
+		strconv.FormatInt(r.BytesFromInitiator, 10), strconv.FormatInt(r.PacketsFromInitiator, 10),
+		strconv.FormatInt(r.BytesFromTarget, 10), strconv.FormatInt(r.PacketsFromTarget, 10),
+		strconv.FormatInt(r.CumulativeBytesFromInitiator, 10), strconv.FormatInt(r.CumulativePacketsFromInitiator, 10),
+		strconv.FormatInt(r.CumulativeBytesFromTarget, 10), strconv.FormatInt(r.CumulativePacketsFromTarget, 10),
+	}
+}
@@ -0,0 +1,158 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/config"
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/metrics"
+	"github.com/elastic/go-elasticsearch/v7"
+	"go.uber.org/zap"
+)
+
+const (
+	runStatusOk      = "ok"
+	runStatusPartial = "partial"
+	runStatusFailed  = "failed"
+
+	// runIDFormat formats the start time of a run in its id.
+	runIDFormat = "20060102T150405Z"
+	// maxSampleErrors bounds the dead letters kept in a run report.
+	maxSampleErrors = 10
+)
+
+// runReport describes an indexing run. The status is partial when objects were left in the source bucket or documents
+// failed and failed when the bucket could not be listed.
+type runReport struct {
+	ID                 string         `json:"id"`
+	Timestamp          string         `json:"@timestamp"`
+	Start              string         `json:"start"`
+	End                string         `json:"end"`
+	DurationSeconds    float64        `json:"duration_seconds"`
+	Status             string         `json:"status"`
+	Error              string         `json:"error,omitempty"`
+	Profile            string         `json:"profile,omitempty"`
+	Bucket             string         `json:"bucket"`
+	Index              string         `json:"index"`
+	Objects            runObjects     `json:"objects"`
+	Errors             map[string]int `json:"errors"`
+	SampleErrors       []deadLetter   `json:"sample_errors"`
+	BytesRead          int64          `json:"bytes_read"`
+	DocumentsPerSecond int64          `json:"documents_per_second"`
+	BulkIndexer        runBulkStats   `json:"bulk_indexer"`
+}
+
+// runObjects counts the objects of a run at each stage, the quarantined objects are left in the source bucket.
+type runObjects struct {
+	Listed      int64 `json:"listed"`
+	Read        int64 `json:"read"`
+	Empty       int64 `json:"empty"`
+	Indexed     int64 `json:"indexed"`
+	Archived    int64 `json:"archived"`
+	Quarantined int64 `json:"quarantined"`
+}
+
+// runBulkStats holds the statistics of the bulk indexer.
+type runBulkStats struct {
+	Added    uint64 `json:"added"`
+	Flushed  uint64 `json:"flushed"`
+	Failed   uint64 `json:"failed"`
+	Indexed  uint64 `json:"indexed"`
+	Created  uint64 `json:"created"`
+	Updated  uint64 `json:"updated"`
+	Deleted  uint64 `json:"deleted"`
+	Requests uint64 `json:"requests"`
+}
+
+// runID returns the id of a run, used as file name and document id: the start time followed by the profile, when
+// set, and the bucket, so the runs of the profiles started within the same second do not overwrite each other. The
+// characters not allowed in a file name are replaced by _.
+func runID(start time.Time, profile string, bucket string) string {
+	parts := []string{start.Format(runIDFormat)}
+	if profile != "" {
+		parts = append(parts, profile)
+	}
+	id := strings.Join(append(parts, bucket), "-")
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, id)
+}
+
+// failedRunReport returns the report of a run that failed before listing the bucket.
+func failedRunReport(start time.Time, err error) *runReport {
+	cfg := config.Load()
+	end := time.Now().UTC()
+	metrics.LastRunTimestamp.SetToCurrentTime()
+	metrics.LastRunDuration.Set(end.Sub(start).Seconds())
+
+	return &runReport{
+		ID:              runID(start, config.Profile(), cfg.COS.SourceBucketName),
+		Timestamp:       end.Format(time.RFC3339),
+		Start:           start.Format(time.RFC3339),
+		End:             end.Format(time.RFC3339),
+		DurationSeconds: end.Sub(start).Seconds(),
+		Status:          runStatusFailed,
+		Error:           err.Error(),
+		Profile:         config.Profile(),
+		Bucket:          cfg.COS.SourceBucketName,
+		Index:           cfg.Elasticsearch.IndexName,
+		Errors:          map[string]int{"setup": 1},
+		SampleErrors:    []deadLetter{},
+	}
+}
+
+// writeRunReport writes the report to index-run-<id>.json in dir, an empty dir disables it.
+func writeRunReport(dir string, report *runReport) error {
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("os.MkdirAll: %v", err)
+	}
+
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json.MarshalIndent: %v", err)
+	}
+
+	file := filepath.Join(dir, fmt.Sprintf("index-run-%s.json", report.ID))
+	if err := ioutil.WriteFile(file, b, 0644); err != nil {
+		return fmt.Errorf("ioutil.WriteFile: %v", err)
+	}
+
+	logger.SystemLogger.Info("Wrote the report of the run.", zap.String("file", file), zap.String("status", report.Status))
+	return nil
+}
+
+// indexRunReport adds the report to the runs index, elasticsearch.runsIndexName or the flow logs index suffixed with
+// -runs.
+func indexRunReport(esClient *elasticsearch.Client, es *config.Elasticsearch, report *runReport) error {
+	if err := ensureIndex(esClient, es.RunsIndexName, es.RunsIndexMapping); err != nil {
+		return err
+	}
+	return bulkWrite(esClient, es.RunsIndexName, map[string]interface{}{report.ID: report})
+}
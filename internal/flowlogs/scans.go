@@ -0,0 +1,165 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+// ScanOptions holds the settings of the port scan and horizontal sweep detection.
+type ScanOptions struct {
+	Filter         string
+	From           string
+	To             string
+	Window         string
+	Type           string
+	Threshold      int64
+	RejectedWeight float64
+}
+
+// scanFinding is an initiator contacting many ports of one host (vertical_scan) or one port of many hosts
+// (horizontal_sweep) within a window. The score counts the distinct ports or hosts reached by accepted flows
+// plus the ones reached by rejected flows multiplied by the rejected weight, a finding is reported when the
+// score exceeds the threshold.
+type scanFinding struct {
+	Type             string  `json:"type"`
+	Window           string  `json:"window"`
+	InitiatorIP      string  `json:"initiator_ip"`
+	TargetIP         string  `json:"target_ip,omitempty"`
+	TargetPort       *int64  `json:"target_port,omitempty"`
+	Distinct         int64   `json:"distinct"`
+	DistinctAccepted int64   `json:"distinct_accepted"`
+	DistinctRejected int64   `json:"distinct_rejected"`
+	Flows            int64   `json:"flows"`
+	Rejected         int64   `json:"rejected"`
+	Score            float64 `json:"score"`
+}
+
+// DetectScans function, returns the exit code of the command: 0 on success and 1 when it failed.
+func DetectScans(options ScanOptions, trace bool) int {
+	err := detectScans(options, trace)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+func detectScans(options ScanOptions, trace bool) error {
+	if options.Type != "all" && options.Type != "vertical" && options.Type != "horizontal" {
+		return fmt.Errorf("invalid type %s, expecting all, vertical or horizontal", options.Type)
+	}
+	if options.Threshold <= 0 {
+		return fmt.Errorf("invalid threshold %d", options.Threshold)
+	}
+	if options.RejectedWeight < 0 {
+		return fmt.Errorf("invalid rejected weight %v", options.RejectedWeight)
+	}
+
+	filter, err := ParseFilter(options.Filter, options.From, options.To)
+	if err != nil {
+		return err
+	}
+
+	esClient, esIndexName, err := newElasticsearchClient(trace)
+	if err != nil {
+		return err
+	}
+
+	findings := []scanFinding{}
+
+	if options.Type != "horizontal" {
+		sources := []interface{}{
+			compositeWindow("window", "capture_start_time", options.Window),
+			compositeSource("initiator_ip", "flow_logs.initiator_ip.keyword"),
+			compositeSource("target_ip", "flow_logs.target_ip.keyword"),
+		}
+		err := compositeBuckets(esClient, esIndexName, filter.Query(), sources, distinctAggs("flow_logs.target_port"), func(bucket gjson.Result) error {
+			if finding, ok := newScanFinding("vertical_scan", bucket, options); ok {
+				finding.TargetIP = bucket.Get("key.target_ip").String()
+				findings = append(findings, finding)
+			}
+			return nil
+		})
+		if err != nil {
+			logger.ErrorLogger.Error("Error getting response from search", zap.Error(err))
+			return err
+		}
+	}
+
+	if options.Type != "vertical" {
+		sources := []interface{}{
+			compositeWindow("window", "capture_start_time", options.Window),
+			compositeSource("initiator_ip", "flow_logs.initiator_ip.keyword"),
+			compositeSource("target_port", "flow_logs.target_port"),
+		}
+		err := compositeBuckets(esClient, esIndexName, filter.Query(), sources, distinctAggs("flow_logs.target_ip.keyword"), func(bucket gjson.Result) error {
+			if finding, ok := newScanFinding("horizontal_sweep", bucket, options); ok {
+				port := bucket.Get("key.target_port").Int()
+				finding.TargetPort = &port
+				findings = append(findings, finding)
+			}
+			return nil
+		})
+		if err != nil {
+			logger.ErrorLogger.Error("Error getting response from search", zap.Error(err))
+			return err
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Score > findings[j].Score })
+
+	logger.SystemLogger.Info("Found port scans and sweeps.", zap.Int("findings", len(findings)))
+
+	return printResults("json", nil, findings, nil)
+}
+
+// distinctAggs returns the sub aggregations counting the distinct values of field for all, accepted and rejected flows.
+func distinctAggs(field string) map[string]interface{} {
+	distinct := map[string]interface{}{"cardinality": map[string]interface{}{"field": field}}
+	return map[string]interface{}{
+		"distinct": distinct,
+		"accepted": map[string]interface{}{
+			"filter": termClause("flow_logs.action.keyword", "accepted"),
+			"aggs":   map[string]interface{}{"distinct": distinct},
+		},
+		"rejected": map[string]interface{}{
+			"filter": termClause("flow_logs.action.keyword", "rejected"),
+			"aggs":   map[string]interface{}{"distinct": distinct},
+		},
+	}
+}
+
+func newScanFinding(findingType string, bucket gjson.Result, options ScanOptions) (scanFinding, bool) {
+	finding := scanFinding{
+		Type:             findingType,
+		Window:           bucket.Get("key.window").String(),
+		InitiatorIP:      bucket.Get("key.initiator_ip").String(),
+		Distinct:         bucket.Get("distinct.value").Int(),
+		DistinctAccepted: bucket.Get("accepted.distinct.value").Int(),
+		DistinctRejected: bucket.Get("rejected.distinct.value").Int(),
+		Flows:            bucket.Get("doc_count").Int(),
+		Rejected:         bucket.Get("rejected.doc_count").Int(),
+	}
+	finding.Score = float64(finding.DistinctAccepted) + options.RejectedWeight*float64(finding.DistinctRejected)
+
+	return finding, finding.Score > float64(options.Threshold)
+}
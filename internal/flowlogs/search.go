@@ -18,19 +18,14 @@ package flowlogs
 import (
 	"bytes"
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
-	"os"
 	"strings"
 
 	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
 	"github.com/elastic/go-elasticsearch/v7"
-	"github.com/elastic/go-elasticsearch/v7/estransport"
 	"github.com/manifoldco/promptui"
-	"github.com/spf13/viper"
 	"github.com/tidwall/gjson"
 
 	"go.uber.org/zap"
@@ -42,80 +37,39 @@ type queryResult struct {
 }
 
 // Search function
-func Search(queryName string, trace bool) string {
-	search(queryName, trace)
+func Search(queryName string, filterExpression string, from string, to string, metric string, trace bool) string {
+	search(queryName, filterExpression, from, to, metric, trace)
 	return "done"
 }
 
-func search(queryName string, trace bool) (result *map[string]interface{}) {
+func search(queryName string, filterExpression string, from string, to string, metric string, trace bool) (result *map[string]interface{}) {
 
 	result = nil
 
-	var (
-		esIndexName = viper.GetString("elasticsearch.indexName")
-		esAddresses []string
-		esUsername  = viper.GetString("elasticsearch.username")
-		esPassword  = viper.GetString("elasticsearch.password")
-		esCert      = viper.GetString("elasticsearch.certificate.certificate_base64")
-		cfg         elasticsearch.Config
-	)
-
-	if !validateKey(esUsername) {
-		log.Fatalln("elasticsearch.username or ELASTICSEARCH_USERNAME not provided ")
-	}
-	if !validateKey(esPassword) {
-		log.Fatalln("elasticsearch.password or ELASTICSEARCH_PASSWORD not provided ")
-	}
-	if !validateKey(esCert) {
-		log.Fatalln("elasticsearch.certificate.certificate_base64 or ELASTICSEARCH_CERTIFICATE_CERTIFICATE_BASE64 not provided ")
-	}
-	if !validateKey(esIndexName) {
-		log.Fatalln("elasticsearch.indexName or ELASTICSEARCH_INDEXNAME not provided ")
+	if metric != "" && metric != "flows" && metric != "bytes" {
+		fmt.Printf("invalid metric %s, expecting flows or bytes\n", metric)
+		return
 	}
 
-	cert, err := base64.StdEncoding.DecodeString(esCert)
+	filter, err := ParseFilter(filterExpression, from, to)
 	if err != nil {
-		logger.ErrorLogger.Error("Error decoding certificate for elasticsearch.", zap.String("error: ", err.Error()))
+		fmt.Println(err)
 		return
 	}
 
-	esAddresses = append(esAddresses, fmt.Sprintf("https://%s:%s", viper.GetString("elasticsearch.hostname"), viper.GetString("elasticsearch.port")))
-
-	if trace {
-		cfg = elasticsearch.Config{
-			Addresses: esAddresses,
-			Username:  esUsername,
-			Password:  esPassword,
-			CACert:    cert,
-			Logger: &estransport.ColorLogger{
-				Output:             os.Stdout,
-				EnableRequestBody:  true,
-				EnableResponseBody: true,
-			},
-		}
-	} else {
-		cfg = elasticsearch.Config{
-			Addresses: esAddresses,
-			Username:  esUsername,
-			Password:  esPassword,
-			CACert:    cert,
-		}
-	}
-
-	esClient, err := elasticsearch.NewClient(cfg)
+	esClient, esIndexName, err := newElasticsearchClient(trace)
 	if err != nil {
-		logger.ErrorLogger.Error("Error creating elasticsearch client.", zap.String("error: ", err.Error()))
 		return
 	}
 
 	res, err := esClient.Info()
 	if err != nil {
-		logger.ErrorLogger.Error("Error in getting Client Info", zap.String("error: ", err.Error()))
+		logger.ErrorLogger.Error("Error in getting Client Info", zap.Error(err))
 		return
 	}
 
 	if res.IsError() {
-		logger.ErrorLogger.Error("Error in getting Client Info", zap.String("error: ", res.String()))
+		logger.ErrorLogger.Error("Error in getting Client Info", zap.String("response", res.String()))
 	}
 	// else {
 	// 	body, _ := ioutil.ReadAll(res.Body)
@@ -123,7 +77,7 @@ func search(queryName string, trace bool) (result *map[string]interface{}) {
 	// 	logger.SystemLogger.Info("Client Info", zap.String("Client version:", elasticsearch.Version), zap.String("Server version:", serverVersion.String()))
 	// }
 
-	queries, _ := ioutil.ReadFile("config/queries.json")
+	queries := loadQueries()
 
 	if queryName == "" {
 		queryList := gjson.GetBytes(queries, "queries.#.name")
@@ -138,20 +92,76 @@ func search(queryName string, trace bool) (result *map[string]interface{}) {
 		_, queryName, err = prompt.Run()
 
 		if err != nil {
-			logger.ErrorLogger.Error("Error prompting for query", zap.String("error: ", err.Error()))
+			logger.ErrorLogger.Error("Error prompting for query", zap.Error(err))
 			return
 		}
 	}
 
-	query := gjson.GetBytes(queries, "queries.#(name==\""+queryName+"\").command")
+	var commandResult []byte
+	if metric != "" {
+		commandResult, err = searchMetric(esClient, esIndexName, savedQuery(queries, queryName), filter)
+	} else {
+		commandResult, err = runSavedQuery(esClient, esIndexName, savedQuery(queries, queryName), filter)
+	}
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println(string(commandResult))
+
+	return
+
+}
+
+// metricResult holds the values an alert rule can compare to its threshold.
+type metricResult struct {
+	Query string `json:"query"`
+	Flows int64  `json:"flows"`
+	Bytes int64  `json:"bytes"`
+}
+
+// metricAggs returns the aggregation summing the bytes of both directions of the flows, named value.
+func metricAggs() map[string]interface{} {
+	return map[string]interface{}{
+		"value": map[string]interface{}{"sum": map[string]interface{}{"script": map[string]interface{}{"source": bytesScript, "lang": "painless"}}},
+	}
+}
+
+// searchMetric counts the flows matching the query of a saved query narrowed by the filter and sums their bytes.
+func searchMetric(esClient *elasticsearch.Client, esIndexName string, saved gjson.Result, filter *Filter) ([]byte, error) {
+	command, _ := narrowedCommand(saved, filter).(map[string]interface{})
+	body := map[string]interface{}{
+		"size":             0,
+		"track_total_hits": true,
+		"query":            command["query"],
+		"aggs":             metricAggs(),
+	}
+
+	response, err := searchBody(esClient, esIndexName, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(metricResult{
+		Query: saved.Get("name").String(),
+		Flows: gjson.GetBytes(response, "hits.total.value").Int(),
+		Bytes: gjson.GetBytes(response, "aggregations.value.value").Int(),
+	}, "", "  ")
+}
+
+// runSavedQuery runs the command of a saved query narrowed by the filter and returns the values listed in its output
+// as queryResult, or the response body when it has no output.
+func runSavedQuery(esClient *elasticsearch.Client, esIndexName string, saved gjson.Result, filter *Filter) ([]byte, error) {
+	query := narrowedCommand(saved, filter)
 	var buf bytes.Buffer
 
-	if err := json.NewEncoder(&buf).Encode(query.Value()); err != nil {
-		logger.ErrorLogger.Error("Error encoding query", zap.String("error: ", err.Error()))
-		return
+	if err := json.NewEncoder(&buf).Encode(query); err != nil {
+		logger.ErrorLogger.Error("Error encoding query", zap.String("query", saved.Get("name").String()), zap.Error(err))
+		return nil, fmt.Errorf("json.Encode: %v", err)
 	}
 
-	res, err = esClient.Search(
+	res, err := esClient.Search(
 		esClient.Search.WithContext(context.Background()),
 		esClient.Search.WithIndex(esIndexName),
 		esClient.Search.WithBody(&buf),
@@ -159,51 +169,89 @@ func search(queryName string, trace bool) (result *map[string]interface{}) {
 		esClient.Search.WithPretty(),
 	)
 	if err != nil {
-		logger.ErrorLogger.Error("Error getting response from search", zap.String("error: ", err.Error()))
-		return
+		logger.ErrorLogger.Error("Error getting response from search", zap.String("index", esIndexName), zap.String("query", saved.Get("name").String()), zap.Error(err))
+		return nil, fmt.Errorf("esClient.Search: %v", err)
 	}
 	defer res.Body.Close()
 
 	body, _ := ioutil.ReadAll(res.Body)
-	var qr []queryResult
+	if res.IsError() {
+		logger.ErrorLogger.Error("Error getting response from search", zap.String("index", esIndexName), zap.String("query", saved.Get("name").String()), zap.String("response", string(body)))
+		return nil, fmt.Errorf("esClient.Search: %s", body)
+	}
 
-	output := gjson.GetBytes(queries, "queries.#(name==\""+queryName+"\").output")
-	var commandResult []byte
-	if output.String() != "" {
-		output.ForEach(func(key, value gjson.Result) bool {
-			name := gjson.Get(value.String(), "name").String()
-			valueof := gjson.Get(value.String(), "valueof").String()
+	var qr []queryResult
 
-			if strings.Contains(valueof, "#") {
-				before := valueof[0:strings.Index(valueof, ".#")]
-				after := valueof[strings.LastIndex(valueof, "#.")+2 : len(valueof)]
+	output := saved.Get("output")
+	if output.String() == "" {
+		return body, nil
+	}
 
-				buckets := gjson.GetBytes(body, before)
-				buckets.ForEach(func(key, value gjson.Result) bool {
-					r := queryResult{Name: name, Value: gjson.Get(value.String(), after).String()}
-					qr = append(qr, r)
+	output.ForEach(func(key, value gjson.Result) bool {
+		name := gjson.Get(value.String(), "name").String()
+		valueof := gjson.Get(value.String(), "valueof").String()
 
-					return true
-				})
+		if strings.Contains(valueof, "#") {
+			before := valueof[0:strings.Index(valueof, ".#")]
+			after := valueof[strings.LastIndex(valueof, "#.")+2 : len(valueof)]
 
-			} else {
-				r := queryResult{Name: name, Value: gjson.GetBytes(body, valueof).String()}
+			buckets := gjson.GetBytes(body, before)
+			buckets.ForEach(func(key, value gjson.Result) bool {
+				r := queryResult{Name: name, Value: gjson.Get(value.String(), after).String()}
 				qr = append(qr, r)
-			}
-			return true // keep iterating
-		})
 
-		commandResult, err = json.Marshal(qr)
-		if err != nil {
-			logger.ErrorLogger.Error("Error in marshalling results", zap.String("error: ", err.Error()))
-			return
+				return true
+			})
+
+		} else {
+			r := queryResult{Name: name, Value: gjson.GetBytes(body, valueof).String()}
+			qr = append(qr, r)
 		}
-	} else {
-		commandResult = body
+		return true // keep iterating
+	})
+
+	commandResult, err := json.Marshal(qr)
+	if err != nil {
+		logger.ErrorLogger.Error("Error in marshalling results", zap.String("query", saved.Get("name").String()), zap.Error(err))
+		return nil, fmt.Errorf("json.Marshal: %v", err)
 	}
+	return commandResult, nil
+}
 
-	fmt.Println(string(commandResult))
+// loadQueries returns the content of the saved queries file.
+func loadQueries() []byte {
+	queries, _ := ioutil.ReadFile("config/queries.json")
+	return queries
+}
 
-	return
+// savedQuery returns the definition of the named query, it does not exist when the query is not found. The name is
+// compared to each query rather than put in a gjson path, where a name from a request could change the path.
+func savedQuery(queries []byte, queryName string) gjson.Result {
+	var saved gjson.Result
+	gjson.GetBytes(queries, "queries").ForEach(func(_, query gjson.Result) bool {
+		if query.Get("name").String() == queryName {
+			saved = query
+			return false
+		}
+		return true
+	})
+	return saved
+}
 
+// narrowedCommand returns the command of the saved query with its query restricted to the flows matching the filter,
+// which also leaves out the documents of the objects holding no flow.
+func narrowedCommand(saved gjson.Result, filter *Filter) interface{} {
+	command, ok := saved.Get("command").Value().(map[string]interface{})
+	if !ok || filter == nil {
+		return saved.Get("command").Value()
+	}
+
+	clauses := []interface{}{filter.Query()}
+	if query, ok := command["query"]; ok {
+		clauses = append([]interface{}{query}, clauses...)
+	}
+	command["query"] = map[string]interface{}{
+		"bool": map[string]interface{}{"filter": clauses},
+	}
+	return command
 }
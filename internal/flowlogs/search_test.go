@@ -0,0 +1,54 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import "testing"
+
+func TestSavedQuery(t *testing.T) {
+	queries := []byte(`{"queries": [
+		{"name": "top_25_target_ips", "description": "top"},
+		{"name": "rejected", "description": "rejected"}
+	]}`)
+
+	tests := []struct {
+		name        string
+		description string
+	}{
+		{name: "top_25_target_ips", description: "top"},
+		{name: "rejected", description: "rejected"},
+		{name: "missing"},
+		{name: ""},
+		{name: `rejected")`},
+		{name: `x"||name!="`},
+		{name: "*"},
+		{name: "rej*"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			saved := savedQuery(queries, test.name)
+			if test.description == "" {
+				if saved.Exists() {
+					t.Fatalf("savedQuery(%q) = %s, want not found", test.name, saved.Raw)
+				}
+				return
+			}
+			if got := saved.Get("description").String(); got != test.description {
+				t.Errorf("savedQuery(%q) description = %q, want %q", test.name, got, test.description)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/metrics"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+// maxEventBytes bounds the size of an event payload.
+const maxEventBytes = 1 << 20
+
+// ServeOptions holds the settings of the ingestion server.
+type ServeOptions struct {
+	Listen        string
+	FlushInterval time.Duration
+	ResultTimeout time.Duration
+}
+
+// objectEvent is an object-created notification reduced to its bucket and key.
+type objectEvent struct {
+	Bucket string
+	Key    string
+}
+
+type eventsResponse struct {
+	Results []objectResult `json:"results"`
+}
+
+// Serve function, returns the exit code of the command: 0 on success and 1 when it failed.
+func Serve(options ServeOptions, trace bool) int {
+	err := serve(options, trace)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+// serve indexes the objects named in the events posted to /events until SIGINT or SIGTERM, the requests in progress
+// are then completed and the bulk indexer flushed before returning.
+func serve(options ServeOptions, trace bool) error {
+	ix, err := newIndexer(trace, false, options.FlushInterval)
+	if err != nil {
+		return err
+	}
+	defer ix.close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		handleEvents(ix, options.ResultTimeout, w, r)
+	})
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	server := &http.Server{Addr: options.Listen, Handler: mux}
+
+	// ListenAndServe returns as soon as Shutdown is called, done is closed once the requests in progress completed so
+	// the bulk indexer is not closed under them.
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-stop
+		logger.SystemLogger.Info("Stopping, completing the requests in progress.")
+		ctx, cancel := context.WithTimeout(context.Background(), options.ResultTimeout+5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			logger.ErrorLogger.Error("Error stopping the server.", zap.Error(err))
+		}
+	}()
+
+	logger.SystemLogger.Info("Accepting object events.", zap.String("bucket", ix.sourceBucketName), zap.String("listen", options.Listen))
+
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		logger.ErrorLogger.Error("Error starting the server.", zap.String("listen", options.Listen), zap.Error(err))
+		return fmt.Errorf("server.ListenAndServe: %v", err)
+	}
+	<-done
+	return nil
+}
+
+// handleEvents indexes each object of the payload and waits up to timeout for their documents to be flushed, the
+// objects still in the bulk indexer are reported as queued.
+func handleEvents(ix *indexer, timeout time.Duration, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxEventBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	events, err := parseObjectEvents(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type indexedResult struct {
+		index  int
+		result objectResult
+	}
+	results := make([]objectResult, len(events))
+	reported := make(chan indexedResult, len(events))
+	waiting := 0
+
+	for i, event := range events {
+		if event.Bucket != "" && event.Bucket != ix.sourceBucketName {
+			results[i] = objectResult{Bucket: event.Bucket, Key: event.Key, Status: "skipped",
+				Error: fmt.Sprintf("bucket %s is not the source bucket %s", event.Bucket, ix.sourceBucketName)}
+			continue
+		}
+
+		i := i
+		waiting++
+		results[i] = objectResult{Bucket: ix.sourceBucketName, Key: event.Key, Status: "queued"}
+		ix.indexObject(r.Context(), event.Key, func(result objectResult) {
+			reported <- indexedResult{index: i, result: result}
+		})
+	}
+
+	deadline := time.After(timeout)
+wait:
+	for ; waiting > 0; waiting-- {
+		select {
+		case r := <-reported:
+			results[r.index] = r.result
+		case <-deadline:
+			break wait
+		}
+	}
+
+	status := http.StatusOK
+	for _, result := range results {
+		logger.SystemLogger.Info("Object from event.", zap.String("bucket", result.Bucket), zap.String("key", result.Key),
+			zap.String("object_id", result.DocumentID), zap.String("index", ix.esIndexName), zap.String("status", result.Status))
+		// The documents are overwritten when an object is indexed again, a failure is returned so the sender retries.
+		if result.Status == "failed" || result.Status == "quarantined" {
+			status = http.StatusInternalServerError
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(eventsResponse{Results: results})
+}
+
+// parseObjectEvents accepts a single event or an array of events, each either {"bucket", "key"} as passed by a Cloud
+// Functions trigger, a COS notification {"notification": {"bucket_name", "object_name"}} or S3 style records
+// {"Records": [{"s3": {"bucket": {"name"}, "object": {"key"}}}]}.
+func parseObjectEvents(body []byte) ([]objectEvent, error) {
+	if !gjson.ValidBytes(body) {
+		return nil, errors.New("the event payload is not valid json")
+	}
+
+	var events []objectEvent
+	var parseErr error
+	parse := func(event gjson.Result) {
+		switch {
+		case event.Get("Records").Exists():
+			event.Get("Records").ForEach(func(_, record gjson.Result) bool {
+				// The keys of S3 style records are url encoded.
+				key, err := url.QueryUnescape(record.Get("s3.object.key").String())
+				if err != nil {
+					parseErr = fmt.Errorf("invalid object key %s: %v", record.Get("s3.object.key").String(), err)
+					return false
+				}
+				events = append(events, objectEvent{Bucket: record.Get("s3.bucket.name").String(), Key: key})
+				return true
+			})
+		case event.Get("notification.object_name").Exists():
+			events = append(events, objectEvent{
+				Bucket: event.Get("notification.bucket_name").String(),
+				Key:    event.Get("notification.object_name").String(),
+			})
+		default:
+			events = append(events, objectEvent{Bucket: event.Get("bucket").String(), Key: event.Get("key").String()})
+		}
+	}
+
+	payload := gjson.ParseBytes(body)
+	if payload.IsArray() {
+		payload.ForEach(func(_, event gjson.Result) bool {
+			parse(event)
+			return parseErr == nil
+		})
+	} else {
+		parse(payload)
+	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	if len(events) == 0 {
+		return nil, errors.New("the event payload names no object")
+	}
+	for _, event := range events {
+		if event.Key == "" {
+			return nil, errors.New("an event of the payload has no object key")
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,95 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseObjectEvents(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		events  []objectEvent
+		wantErr bool
+	}{
+		{
+			name:   "bucket and key",
+			body:   `{"bucket": "flowlogs", "key": "a/b.gz"}`,
+			events: []objectEvent{{Bucket: "flowlogs", Key: "a/b.gz"}},
+		},
+		{
+			name:   "cos notification",
+			body:   `{"notification": {"bucket_name": "flowlogs", "object_name": "a/b.gz"}}`,
+			events: []objectEvent{{Bucket: "flowlogs", Key: "a/b.gz"}},
+		},
+		{
+			name: "s3 records with encoded keys",
+			body: `{"Records": [{"s3": {"bucket": {"name": "flowlogs"}, "object": {"key": "vnic-id%3Dabc/hour%3D10.gz"}}},
+				{"s3": {"bucket": {"name": "flowlogs"}, "object": {"key": "c.gz"}}}]}`,
+			events: []objectEvent{{Bucket: "flowlogs", Key: "vnic-id=abc/hour=10.gz"}, {Bucket: "flowlogs", Key: "c.gz"}},
+		},
+		{
+			name:   "array of mixed events",
+			body:   `[{"bucket": "flowlogs", "key": "a.gz"}, {"notification": {"bucket_name": "other", "object_name": "b.gz"}}]`,
+			events: []objectEvent{{Bucket: "flowlogs", Key: "a.gz"}, {Bucket: "other", Key: "b.gz"}},
+		},
+		{
+			name:    "invalid json",
+			body:    `{"bucket": `,
+			wantErr: true,
+		},
+		{
+			name:    "empty array",
+			body:    `[]`,
+			wantErr: true,
+		},
+		{
+			name:    "missing key",
+			body:    `{"bucket": "flowlogs"}`,
+			wantErr: true,
+		},
+		{
+			name:    "one event of the array without key",
+			body:    `[{"bucket": "flowlogs", "key": "a.gz"}, {"bucket": "flowlogs"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid key encoding",
+			body:    `{"Records": [{"s3": {"bucket": {"name": "flowlogs"}, "object": {"key": "a%zz.gz"}}}]}`,
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			events, err := parseObjectEvents([]byte(test.body))
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("parseObjectEvents = %v, want an error", events)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseObjectEvents: %v", err)
+			}
+			if !reflect.DeepEqual(events, test.events) {
+				t.Errorf("parseObjectEvents = %v, want %v", events, test.events)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/config"
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+// sweepInstancesSize is the number of affected instances listed for an indicator.
+const sweepInstancesSize = 100
+
+// SweepOptions holds the settings of a retroactive sweep of the index for a list of indicators.
+type SweepOptions struct {
+	File       string
+	ListFormat string
+	Filter     string
+	From       string
+	To         string
+	BatchSize  int
+	HitsOnly   bool
+	Format     string
+}
+
+// sweepResult is the report line of an indicator, the instances are the ones that sent or received its traffic.
+type sweepResult struct {
+	Indicator  string   `json:"indicator"`
+	Confidence int      `json:"confidence"`
+	FirstSeen  string   `json:"first_seen,omitempty"`
+	LastSeen   string   `json:"last_seen,omitempty"`
+	Flows      int64    `json:"flows"`
+	Bytes      int64    `json:"bytes"`
+	Accepted   int64    `json:"accepted"`
+	Rejected   int64    `json:"rejected"`
+	Instances  []string `json:"instances"`
+}
+
+var sweepColumns = []string{"indicator", "confidence", "first_seen", "last_seen", "flows", "bytes", "accepted", "rejected", "instances"}
+
+func (r *sweepResult) csvRow() []string {
+	return []string{
+		r.Indicator, strconv.Itoa(r.Confidence), r.FirstSeen, r.LastSeen, strconv.FormatInt(r.Flows, 10),
+		strconv.FormatInt(r.Bytes, 10), strconv.FormatInt(r.Accepted, 10), strconv.FormatInt(r.Rejected, 10),
+		strings.Join(r.Instances, "|"),
+	}
+}
+
+// Sweep function, returns the exit code of the command: 0 on success and 1 when it failed.
+func Sweep(options SweepOptions, trace bool) int {
+	err := sweep(options, trace)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+func sweep(options SweepOptions, trace bool) error {
+	if err := validateFormat(options.Format); err != nil {
+		return err
+	}
+	if options.BatchSize <= 0 {
+		return fmt.Errorf("invalid batch size %d", options.BatchSize)
+	}
+
+	filter, err := ParseFilter(options.Filter, options.From, options.To)
+	if err != nil {
+		return err
+	}
+
+	// The list is read with the same loaders as the IOC lists tagged at index time.
+	matcher := newIOCMatcher()
+	list := config.IOCList{Name: filepath.Base(options.File), File: options.File, Format: options.ListFormat, Confidence: config.DefaultIOCConfidence}
+	if err := loadIOCList(matcher, list); err != nil {
+		return fmt.Errorf("%s: %v", options.File, err)
+	}
+
+	var indicators []iocIndicator
+	seen := map[string]int{}
+	for _, indicator := range matcher.indicators {
+		if i, ok := seen[indicator.indicator]; ok {
+			if indicator.confidence > indicators[i].confidence {
+				indicators[i].confidence = indicator.confidence
+			}
+			continue
+		}
+		seen[indicator.indicator] = len(indicators)
+		indicators = append(indicators, indicator)
+	}
+	if len(indicators) == 0 {
+		return fmt.Errorf("no indicators found in %s", options.File)
+	}
+
+	esClient, esIndexName, err := newElasticsearchClient(trace)
+	if err != nil {
+		return err
+	}
+
+	results := []*sweepResult{}
+	for start := 0; start < len(indicators); start += options.BatchSize {
+		end := start + options.BatchSize
+		if end > len(indicators) {
+			end = len(indicators)
+		}
+
+		batch, err := sweepBatch(esClient, esIndexName, filter, indicators[start:end])
+		if err != nil {
+			logger.ErrorLogger.Error("Error getting response from search", zap.Error(err))
+			return err
+		}
+		logger.SystemLogger.Info("Swept indicators.", zap.Int("swept", end), zap.Int("indicators", len(indicators)))
+
+		for _, r := range batch {
+			if r.Flows > 0 || !options.HitsOnly {
+				results = append(results, r)
+			}
+		}
+	}
+
+	hits := 0
+	for _, r := range results {
+		if r.Flows > 0 {
+			hits++
+		}
+	}
+	logger.SystemLogger.Info("Found indicators in the flow logs.", zap.Int("found", hits), zap.Int("indicators", len(indicators)))
+
+	var rows []csvRower
+	for _, r := range results {
+		rows = append(rows, r)
+	}
+	return printResults(options.Format, sweepColumns, results, rows)
+}
+
+// sweepBatch runs a single search for a batch of indicators: the query keeps the flows whose initiator or target ip
+// is in any of them, and a filters aggregation with one bucket per indicator computes its report line.
+func sweepBatch(esClient *elasticsearch.Client, esIndexName string, filter *Filter, indicators []iocIndicator) ([]*sweepResult, error) {
+	var values []string
+	buckets := map[string]interface{}{}
+	for i, indicator := range indicators {
+		values = append(values, indicator.indicator)
+		buckets[strconv.Itoa(i)] = map[string]interface{}{
+			"bool": map[string]interface{}{
+				"should": []interface{}{
+					termClause(filterFields["initiator_ip"], indicator.indicator),
+					termClause(filterFields["target_ip"], indicator.indicator),
+				},
+				"minimum_should_match": 1,
+			},
+		}
+	}
+
+	aggs := trafficAggs()
+	aggs["first_seen"] = map[string]interface{}{"min": map[string]interface{}{"field": "capture_start_time"}}
+	aggs["last_seen"] = map[string]interface{}{"max": map[string]interface{}{"field": "capture_end_time"}}
+	aggs["instances"] = map[string]interface{}{"terms": map[string]interface{}{"field": "instance_crn.keyword", "size": sweepInstancesSize}}
+
+	body := map[string]interface{}{
+		"size": 0,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					filter.Query(),
+					map[string]interface{}{
+						"bool": map[string]interface{}{
+							"should": []interface{}{
+								map[string]interface{}{"terms": map[string]interface{}{filterFields["initiator_ip"]: values}},
+								map[string]interface{}{"terms": map[string]interface{}{filterFields["target_ip"]: values}},
+							},
+							"minimum_should_match": 1,
+						},
+					},
+				},
+			},
+		},
+		"aggs": map[string]interface{}{
+			"indicators": map[string]interface{}{
+				"filters": map[string]interface{}{"filters": buckets},
+				"aggs":    aggs,
+			},
+		},
+	}
+
+	response, err := searchBody(esClient, esIndexName, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*sweepResult
+	for i, indicator := range indicators {
+		bucket := gjson.GetBytes(response, "aggregations.indicators.buckets."+strconv.Itoa(i))
+		flows, bytes, _, accepted, rejected := trafficValues(bucket)
+
+		r := &sweepResult{
+			Indicator:  indicator.indicator,
+			Confidence: indicator.confidence,
+			Flows:      flows,
+			Bytes:      bytes,
+			Accepted:   accepted,
+			Rejected:   rejected,
+			Instances:  []string{},
+		}
+		if flows > 0 {
+			r.FirstSeen = bucket.Get("first_seen.value_as_string").String()
+			r.LastSeen = bucket.Get("last_seen.value_as_string").String()
+			bucket.Get("instances.buckets.#.key").ForEach(func(_, key gjson.Result) bool {
+				r.Instances = append(r.Instances, key.String())
+				return true
+			})
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
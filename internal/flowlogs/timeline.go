@@ -0,0 +1,281 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"github.com/dustin/go-humanize"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+// timelineMetrics maps the metric names to the aggregation computing them for each bucket.
+var timelineMetrics = map[string]map[string]interface{}{
+	"flows":                nil,
+	"bytes_from_initiator": {"sum": map[string]interface{}{"field": "flow_logs.bytes_from_initiator"}},
+	"bytes_from_target":    {"sum": map[string]interface{}{"field": "flow_logs.bytes_from_target"}},
+	"rejected":             {"filter": map[string]interface{}{"term": map[string]interface{}{"flow_logs.action.keyword": "rejected"}}},
+}
+
+// timelineSplits maps the names accepted to split a timeline in several series to their field.
+var timelineSplits = map[string]string{
+	"direction": "flow_logs.direction.keyword",
+	"action":    "flow_logs.action.keyword",
+}
+
+// TimelineOptions holds the settings of a timeline.
+type TimelineOptions struct {
+	Filter    string
+	From      string
+	To        string
+	TimeField string
+	Interval  string
+	Metric    string
+	Split     string
+	Format    string
+	Chart     string
+}
+
+type timelinePoint struct {
+	Time   string `json:"time"`
+	Series string `json:"series,omitempty"`
+	Value  int64  `json:"value"`
+}
+
+func (p timelinePoint) csvRow() []string {
+	return []string{p.Time, p.Series, strconv.FormatInt(p.Value, 10)}
+}
+
+// Timeline function, returns the exit code of the command: 0 on success and 1 when it failed.
+func Timeline(options TimelineOptions, trace bool) int {
+	err := timeline(options, trace)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+func timeline(options TimelineOptions, trace bool) error {
+	metric, ok := timelineMetrics[options.Metric]
+	if !ok {
+		return fmt.Errorf("invalid metric %s, expecting flows, bytes_from_initiator, bytes_from_target or rejected", options.Metric)
+	}
+	if _, ok := timelineSplits[options.Split]; options.Split != "" && !ok {
+		return fmt.Errorf("invalid split %s, expecting direction or action", options.Split)
+	}
+	if options.TimeField != "capture_start_time" && options.TimeField != "start_time" {
+		return fmt.Errorf("invalid time field %s, expecting capture_start_time or start_time", options.TimeField)
+	}
+	if options.Chart != "sparkline" && options.Chart != "bar" && options.Chart != "none" {
+		return fmt.Errorf("invalid chart %s, expecting sparkline, bar or none", options.Chart)
+	}
+	if err := validateFormat(options.Format); err != nil {
+		return err
+	}
+
+	filter, err := ParseFilter(options.Filter, options.From, options.To)
+	if err != nil {
+		return err
+	}
+	filter.SetTimeField(options.TimeField)
+
+	esClient, esIndexName, err := newElasticsearchClient(trace)
+	if err != nil {
+		return err
+	}
+
+	// The metric is computed within the split when there is one, the value of each bucket is then read from the same path.
+	metricAggs := map[string]interface{}{}
+	if metric != nil {
+		metricAggs["metric"] = metric
+	}
+	bucketAggs := metricAggs
+	if options.Split != "" {
+		bucketAggs = map[string]interface{}{
+			"split": map[string]interface{}{
+				"terms": map[string]interface{}{"field": timelineSplits[options.Split], "size": 10},
+				"aggs":  metricAggs,
+			},
+		}
+	}
+
+	histogram := map[string]interface{}{
+		"field":          filter.TimeFieldPath(),
+		"fixed_interval": options.Interval,
+		"min_doc_count":  0,
+	}
+	if options.From != "" && options.To != "" {
+		histogram["extended_bounds"] = map[string]interface{}{"min": options.From, "max": options.To}
+	}
+
+	body := map[string]interface{}{
+		"size":  0,
+		"query": filter.Query(),
+		"aggs": map[string]interface{}{
+			"timeline": map[string]interface{}{
+				"date_histogram": histogram,
+				"aggs":           bucketAggs,
+			},
+		},
+	}
+
+	response, err := searchBody(esClient, esIndexName, body)
+	if err != nil {
+		logger.ErrorLogger.Error("Error getting response from search", zap.Error(err))
+		return err
+	}
+
+	points := []timelinePoint{}
+	var times []string
+	gjson.GetBytes(response, "aggregations.timeline.buckets").ForEach(func(_, bucket gjson.Result) bool {
+		time := bucket.Get("key_as_string").String()
+		times = append(times, time)
+		if options.Split == "" {
+			points = append(points, timelinePoint{Time: time, Value: timelineValue(options.Metric, bucket)})
+			return true
+		}
+		bucket.Get("split.buckets").ForEach(func(_, split gjson.Result) bool {
+			points = append(points, timelinePoint{Time: time, Series: split.Get("key").String(), Value: timelineValue(options.Metric, split)})
+			return true
+		})
+		return true
+	})
+
+	var rows []csvRower
+	for _, point := range points {
+		rows = append(rows, point)
+	}
+	if err := printResults(options.Format, []string{"time", "series", "value"}, points, rows); err != nil {
+		return err
+	}
+
+	// The charts are written to stderr so that stdout only holds the JSON or CSV output.
+	switch options.Chart {
+	case "sparkline":
+		renderSparklines(os.Stderr, options.Metric, times, points)
+	case "bar":
+		renderBars(os.Stderr, options.Metric, times, points)
+	}
+
+	return nil
+}
+
+func timelineValue(metric string, bucket gjson.Result) int64 {
+	switch metric {
+	case "flows":
+		return bucket.Get("doc_count").Int()
+	case "rejected":
+		return bucket.Get("metric.doc_count").Int()
+	default:
+		return bucket.Get("metric.value").Int()
+	}
+}
+
+// timelineSeries groups the points by series, a timeline without a split has a single series named after the metric.
+func timelineSeries(metric string, points []timelinePoint) ([]string, map[string]map[string]int64) {
+	var names []string
+	values := map[string]map[string]int64{}
+
+	for _, point := range points {
+		name := point.Series
+		if name == "" {
+			name = metric
+		}
+		if _, ok := values[name]; !ok {
+			values[name] = map[string]int64{}
+			names = append(names, name)
+		}
+		values[name][point.Time] = point.Value
+	}
+	sort.Strings(names)
+
+	return names, values
+}
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+func renderSparklines(w io.Writer, metric string, times []string, points []timelinePoint) {
+	names, values := timelineSeries(metric, points)
+	if len(times) == 0 {
+		return
+	}
+
+	var max int64
+	for _, name := range names {
+		for _, value := range values[name] {
+			if value > max {
+				max = value
+			}
+		}
+	}
+
+	width := 0
+	for _, name := range names {
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+
+	fmt.Fprintf(w, "%s to %s\n", times[0], times[len(times)-1])
+	for _, name := range names {
+		var line strings.Builder
+		var total int64
+		for _, time := range times {
+			value := values[name][time]
+			total += value
+			index := 0
+			if max > 0 {
+				index = int(value * int64(len(sparks)-1) / max)
+			}
+			line.WriteRune(sparks[index])
+		}
+		fmt.Fprintf(w, "%-*s %s total %s\n", width, name, line.String(), humanize.Comma(total))
+	}
+}
+
+func renderBars(w io.Writer, metric string, times []string, points []timelinePoint) {
+	const barWidth = 50
+
+	names, values := timelineSeries(metric, points)
+
+	var max int64
+	for _, name := range names {
+		for _, value := range values[name] {
+			if value > max {
+				max = value
+			}
+		}
+	}
+
+	for _, time := range times {
+		for _, name := range names {
+			value := values[name][time]
+			length := 0
+			if max > 0 {
+				length = int(value * barWidth / max)
+			}
+			fmt.Fprintf(w, "%s %-12s %s %s\n", time, name, strings.Repeat("█", length), humanize.Comma(value))
+		}
+	}
+}
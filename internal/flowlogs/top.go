@@ -0,0 +1,178 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+// Painless scripts adding up both directions of a flow, documents indexed from empty values do not have the fields,
+// the pair of a flow missing either ip is left out of the ranking.
+const (
+	bytesScript   = "(doc['flow_logs.bytes_from_initiator'].size() == 0 ? 0 : doc['flow_logs.bytes_from_initiator'].value) + (doc['flow_logs.bytes_from_target'].size() == 0 ? 0 : doc['flow_logs.bytes_from_target'].value)"
+	packetsScript = "(doc['flow_logs.packets_from_initiator'].size() == 0 ? 0 : doc['flow_logs.packets_from_initiator'].value) + (doc['flow_logs.packets_from_target'].size() == 0 ? 0 : doc['flow_logs.packets_from_target'].value)"
+	pairScript    = "doc['flow_logs.initiator_ip.keyword'].size() == 0 || doc['flow_logs.target_ip.keyword'].size() == 0 ? null : doc['flow_logs.initiator_ip.keyword'].value + ' -> ' + doc['flow_logs.target_ip.keyword'].value"
+)
+
+// topKeys maps the names accepted to rank the flows by to the terms aggregation source.
+var topKeys = map[string]map[string]interface{}{
+	"initiator_ip": {"field": "flow_logs.initiator_ip.keyword"},
+	"target_ip":    {"field": "flow_logs.target_ip.keyword"},
+	"target_port":  {"field": "flow_logs.target_port"},
+	"pair":         {"script": map[string]interface{}{"source": pairScript, "lang": "painless"}},
+}
+
+// topOrders maps the names accepted to rank the flows by to the sub aggregation used to order the terms.
+var topOrders = map[string]string{
+	"bytes":   "bytes",
+	"packets": "packets",
+	"flows":   "_count",
+}
+
+// TopOptions holds the settings of a top report.
+type TopOptions struct {
+	Filter string
+	From   string
+	To     string
+	By     string
+	Order  string
+	Size   int
+	Format string
+}
+
+type topRow struct {
+	Key      string `json:"key"`
+	Flows    int64  `json:"flows"`
+	Bytes    int64  `json:"bytes"`
+	Packets  int64  `json:"packets"`
+	Accepted int64  `json:"accepted"`
+	Rejected int64  `json:"rejected"`
+}
+
+func (r topRow) csvRow() []string {
+	return []string{
+		r.Key,
+		strconv.FormatInt(r.Flows, 10),
+		strconv.FormatInt(r.Bytes, 10),
+		strconv.FormatInt(r.Packets, 10),
+		strconv.FormatInt(r.Accepted, 10),
+		strconv.FormatInt(r.Rejected, 10),
+	}
+}
+
+// Top function, returns the exit code of the command: 0 on success and 1 when it failed.
+func Top(options TopOptions, trace bool) int {
+	err := top(options, trace)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+func top(options TopOptions, trace bool) error {
+	key, ok := topKeys[options.By]
+	if !ok {
+		return fmt.Errorf("invalid key %s, expecting initiator_ip, target_ip, pair or target_port", options.By)
+	}
+	order, ok := topOrders[options.Order]
+	if !ok {
+		return fmt.Errorf("invalid order %s, expecting bytes, packets or flows", options.Order)
+	}
+	if options.Size <= 0 {
+		return fmt.Errorf("invalid size %d", options.Size)
+	}
+	if err := validateFormat(options.Format); err != nil {
+		return err
+	}
+
+	filter, err := ParseFilter(options.Filter, options.From, options.To)
+	if err != nil {
+		return err
+	}
+
+	esClient, esIndexName, err := newElasticsearchClient(trace)
+	if err != nil {
+		return err
+	}
+
+	terms := map[string]interface{}{
+		"size":  options.Size,
+		"order": map[string]interface{}{order: "desc"},
+	}
+	for k, v := range key {
+		terms[k] = v
+	}
+
+	body := map[string]interface{}{
+		"size":  0,
+		"query": filter.Query(),
+		"aggs": map[string]interface{}{
+			"top": map[string]interface{}{
+				"terms": terms,
+				"aggs":  trafficAggs(),
+			},
+		},
+	}
+
+	response, err := searchBody(esClient, esIndexName, body)
+	if err != nil {
+		logger.ErrorLogger.Error("Error getting response from search", zap.Error(err))
+		return err
+	}
+
+	results := []topRow{}
+	var rows []csvRower
+	gjson.GetBytes(response, "aggregations.top.buckets").ForEach(func(_, bucket gjson.Result) bool {
+		row := topRow{Key: bucket.Get("key").String()}
+		row.Flows, row.Bytes, row.Packets, row.Accepted, row.Rejected = trafficValues(bucket)
+		results = append(results, row)
+		rows = append(rows, row)
+		return true
+	})
+
+	return printResults(options.Format, []string{"key", "flows", "bytes", "packets", "accepted", "rejected"}, results, rows)
+}
+
+// trafficAggs returns the sub aggregations computing the bytes, packets and accepted/rejected split of a bucket.
+func trafficAggs() map[string]interface{} {
+	return map[string]interface{}{
+		"bytes":   map[string]interface{}{"sum": map[string]interface{}{"script": map[string]interface{}{"source": bytesScript, "lang": "painless"}}},
+		"packets": map[string]interface{}{"sum": map[string]interface{}{"script": map[string]interface{}{"source": packetsScript, "lang": "painless"}}},
+		"actions": map[string]interface{}{
+			"filters": map[string]interface{}{
+				"filters": map[string]interface{}{
+					"accepted": termClause("flow_logs.action.keyword", "accepted"),
+					"rejected": termClause("flow_logs.action.keyword", "rejected"),
+				},
+			},
+		},
+	}
+}
+
+// trafficValues reads the flows, bytes, packets, accepted and rejected counts computed by trafficAggs in a bucket.
+func trafficValues(bucket gjson.Result) (int64, int64, int64, int64, int64) {
+	return bucket.Get("doc_count").Int(),
+		bucket.Get("bytes.value").Int(),
+		bucket.Get("packets.value").Int(),
+		bucket.Get("actions.buckets.accepted.doc_count").Int(),
+		bucket.Get("actions.buckets.rejected.doc_count").Int()
+}
@@ -0,0 +1,174 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/IBM/ibm-cos-sdk-go/aws"
+	"github.com/IBM/ibm-cos-sdk-go/service/s3"
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/config"
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/tidwall/gjson"
+)
+
+// connectTimeout bounds each request made by config validate --connect.
+const connectTimeout = 15 * time.Second
+
+// ValidateOptions holds the settings of the configuration validation.
+type ValidateOptions struct {
+	Connect bool
+}
+
+// ValidateConfig function, returns the exit code, 1 when the configuration has any problem.
+func ValidateConfig(options ValidateOptions, trace bool) int {
+	problems := validateConfig(options, trace)
+	if len(problems) > 0 {
+		fmt.Printf("Found %d problems in the configuration:\n", len(problems))
+		for _, p := range problems {
+			fmt.Println("  " + p)
+		}
+		return 1
+	}
+	fmt.Println("The configuration is valid.")
+	return 0
+}
+
+// validateConfig checks every key and, when options.Connect is set, reaches elasticsearch and the cos buckets whose
+// keys have no problem. Without a selected profile, the cos, elasticsearch and ibmcloud sections of each profile of
+// the configuration file are checked merged over the top level ones and their problems are prefixed with the profile.
+func validateConfig(options ValidateOptions, trace bool) []string {
+	var problems []string
+	add := func(list []config.Problem) {
+		for _, p := range list {
+			problems = append(problems, p.String())
+		}
+	}
+
+	cfg := config.Load()
+	add(cfg.API.Validate())
+	add(cfg.Logging.Validate())
+	add(cfg.Tracing.Validate())
+	add(cfg.ValidateNotifiers())
+	add(cfg.IOC.Validate())
+
+	profiles := config.Profiles()
+	if profile := config.Profile(); profile != "" {
+		profiles = []string{profile}
+	} else if len(profiles) == 0 {
+		return append(problems, validateTargets(cfg, options, trace)...)
+	}
+
+	for _, profile := range profiles {
+		profileCfg := cfg
+		if profile != config.Profile() {
+			var err error
+			if profileCfg, err = config.LoadProfile(profile); err != nil {
+				problems = append(problems, err.Error())
+				continue
+			}
+		}
+		if options.Connect {
+			fmt.Printf("Checking profile %s.\n", profile)
+		}
+		for _, p := range validateTargets(profileCfg, options, trace) {
+			problems = append(problems, fmt.Sprintf("profile %s: %s", profile, p))
+		}
+	}
+	return problems
+}
+
+// validateTargets checks the keys of elasticsearch and cos and, when options.Connect is set, reaches those whose keys
+// have no problem.
+func validateTargets(cfg *config.Config, options ValidateOptions, trace bool) []string {
+	var problems []string
+	add := func(list []config.Problem) {
+		for _, p := range list {
+			problems = append(problems, p.String())
+		}
+	}
+
+	esProblems := cfg.Elasticsearch.Validate()
+	cosProblems := cfg.ValidateCOS()
+	add(esProblems)
+	add(cfg.Elasticsearch.ValidateMappings())
+	add(cosProblems)
+
+	if !options.Connect {
+		return problems
+	}
+
+	if len(esProblems) > 0 {
+		fmt.Println("Skipped the elasticsearch connection, its keys have problems.")
+	} else if esClient, err := openElasticsearch(&cfg.Elasticsearch, trace); err != nil {
+		problems = append(problems, err.Error())
+	} else {
+		problems = append(problems, pingElasticsearch(esClient, cfg.Elasticsearch.Address(), cfg.Elasticsearch.IndexName)...)
+	}
+
+	if len(cosProblems) > 0 {
+		fmt.Println("Skipped the cos connection, its keys have problems.")
+		return problems
+	}
+	cosClient := openCOS(cfg)
+	for _, bucket := range []string{cfg.COS.SourceBucketName, cfg.COS.IndexedBucketName} {
+		ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+		_, err := cosClient.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+		cancel()
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("cos bucket %s at %s is not reachable: %v", bucket, cfg.COS.ServiceEndpoint, err))
+			continue
+		}
+		fmt.Printf("Reached cos bucket %s.\n", bucket)
+	}
+	return problems
+}
+
+// pingElasticsearch gets the cluster info and checks the flow logs index, a missing index is not a problem as index
+// creates it.
+func pingElasticsearch(esClient *elasticsearch.Client, address string, index string) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+
+	res, err := esClient.Info(esClient.Info.WithContext(ctx))
+	if err != nil {
+		return []string{fmt.Sprintf("elasticsearch at %s is not reachable: %v", address, err)}
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.IsError() {
+		return []string{fmt.Sprintf("elasticsearch at %s returned %s: %s", address, res.Status(), gjson.GetBytes(body, "error.reason").String())}
+	}
+	fmt.Printf("Reached elasticsearch %s at %s.\n", gjson.GetBytes(body, "version.number").String(), address)
+
+	res, err = esClient.Indices.Exists([]string{index}, esClient.Indices.Exists.WithContext(ctx))
+	if err != nil {
+		return []string{fmt.Sprintf("elasticsearch index %s could not be checked: %v", index, err)}
+	}
+	res.Body.Close()
+	switch res.StatusCode {
+	case 200:
+		fmt.Printf("Found index %s.\n", index)
+	case 404:
+		fmt.Printf("Index %s does not exist yet, index will create it.\n", index)
+	default:
+		return []string{fmt.Sprintf("elasticsearch index %s could not be checked: %s", index, res.Status())}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package flowlogs
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// WatchOptions holds the settings of the watch mode.
+type WatchOptions struct {
+	Interval      time.Duration
+	MetricsListen string
+}
+
+// Watch function, returns the exit code of the command like index does: 0 when every object was indexed, 1 when the
+// watch failed and 2 when objects were left in the source bucket.
+func Watch(options WatchOptions, trace bool) int {
+	report, err := watch(options, trace)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return exitCode(report)
+}
+
+// watch polls the source bucket until SIGINT or SIGTERM, the objects found are added to the same bulk indexer for the
+// life of the process. On shutdown the object being read is finished, then the bulk indexer is flushed and the archive
+// moves in flight complete before returning the report of the run.
+func watch(options WatchOptions, trace bool) (report *runReport, err error) {
+	ix, err := newIndexer(trace, false, 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		report = ix.close()
+		logger.SystemLogger.Info("Flushed the bulk indexer.", zap.String("bucket", ix.sourceBucketName), zap.String("status", report.Status),
+			zap.Uint64("failed", report.BulkIndexer.Failed), zap.Int64("quarantined", report.Objects.Quarantined))
+	}()
+
+	metrics.Serve(options.MetricsListen)
+
+	// The signals stay caught until the process exits, a second one exits at once without waiting for the flush.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	stop := make(chan struct{})
+	go func() {
+		<-signals
+		logger.SystemLogger.Info("Stopping, flushing the documents in the bulk indexer, signal again to exit without waiting.")
+		close(stop)
+		<-signals
+		logger.SystemLogger.Warn("Exiting without flushing the bulk indexer.", zap.String("bucket", ix.sourceBucketName))
+		os.Exit(1)
+	}()
+
+	logger.SystemLogger.Info("Watching the bucket.", zap.String("bucket", ix.sourceBucketName), zap.Duration("interval", options.Interval))
+
+	for {
+		if err := ix.indexBucket(stop); err != nil {
+			// A failed listing is retried at the next poll rather than stopping the watch.
+			logger.SystemLogger.Warn("Listing the bucket failed, retrying at the next interval.", zap.String("bucket", ix.sourceBucketName), zap.Error(err))
+		}
+		ix.publishDeadLetters()
+
+		select {
+		case <-stop:
+			logger.SystemLogger.Info("Stopped watching the bucket.", zap.String("bucket", ix.sourceBucketName))
+			return nil, nil
+		case <-time.After(options.Interval):
+		}
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"os"
 	"path"
 
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/config"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/natefinch/lumberjack.v2"
@@ -30,18 +31,51 @@ var SystemLogger *zap.Logger
 // ErrorLogger variable
 var ErrorLogger *zap.Logger
 
-// InitLogger function
-func InitLogger() {
+// InitLogger function, an invalid configuration is reported once the loggers are set up with the default levels,
+// format and rotation, so config validate can still list it.
+func InitLogger() error {
+	cfg := config.Load().Logging
+	err := config.Error(cfg.Validate())
+	if err != nil {
+		cfg.Level, cfg.ConsoleLevel, cfg.Format = "debug", "info", "json"
+		cfg.MaxSize, cfg.MaxBackups, cfg.MaxAge = 50, 500, 14
+	}
+
+	level := parseLevel(cfg.Level)
+	consoleLevel := parseLevel(cfg.ConsoleLevel)
+	encoder := getEncoder(cfg.Format)
+
+	// In stdout only mode, i.e. in a container, the system logs go to stdout and the errors to stderr.
+	if cfg.StdoutOnly {
+		SystemLogger = zap.New(zapcore.NewCore(encoder, zapcore.Lock(os.Stdout), level))
+		ErrorLogger = zap.New(zapcore.NewCore(encoder, zapcore.Lock(os.Stderr), level), zap.AddCaller(), zap.AddStacktrace(zap.ErrorLevel))
+		return err
+	}
+
 	systemCore := zapcore.NewTee(
-		zapcore.NewCore(getFileEncoder(), getLogWriter("system.log"), zapcore.DebugLevel),
-		zapcore.NewCore(getConsoleEncoder(), zapcore.AddSync(os.Stdout), zapcore.InfoLevel),
+		zapcore.NewCore(encoder, getLogWriter(cfg, "system.log"), level),
+		zapcore.NewCore(getConsoleEncoder(), zapcore.AddSync(os.Stdout), consoleLevel),
 	)
 	SystemLogger = zap.New(systemCore)
 	defer SystemLogger.Sync()
 
-	errorCore := zapcore.NewCore(getFileEncoder(), getLogWriter("error.log"), zapcore.DebugLevel)
+	errorCore := zapcore.NewCore(encoder, getLogWriter(cfg, "error.log"), level)
 	ErrorLogger = zap.New(errorCore, zap.AddCaller(), zap.AddStacktrace(zap.ErrorLevel))
 	defer ErrorLogger.Sync()
+	return err
+}
+
+func parseLevel(name string) zapcore.Level {
+	var level zapcore.Level
+	level.UnmarshalText([]byte(name))
+	return level
+}
+
+func getEncoder(format string) zapcore.Encoder {
+	if format == "console" {
+		return getConsoleEncoder()
+	}
+	return getFileEncoder()
 }
 
 func getFileEncoder() zapcore.Encoder {
@@ -58,17 +92,19 @@ func getConsoleEncoder() zapcore.Encoder {
 	return zapcore.NewConsoleEncoder(encoderConfig)
 }
 
-func getLogWriter(filename string) zapcore.WriteSyncer {
+func getLogWriter(cfg config.Logging, filename string) zapcore.WriteSyncer {
 	lumberJackLogger := &lumberjack.Logger{
-		Filename:   path.Join("./logs", filename),
-		MaxSize:    50,
-		MaxBackups: 500,
-		MaxAge:     14,
-		Compress:   true,
+		Filename:   path.Join(cfg.Dir, filename),
+		MaxSize:    cfg.MaxSize,
+		MaxBackups: cfg.MaxBackups,
+		MaxAge:     cfg.MaxAge,
+		Compress:   cfg.Compress,
 		LocalTime:  true,
 	}
 
-	lumberJackLogger.Rotate()
+	if cfg.RotateOnStart {
+		lumberJackLogger.Rotate()
+	}
 
 	return zapcore.AddSync(lumberJackLogger)
 }
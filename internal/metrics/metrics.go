@@ -0,0 +1,157 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+const namespace = "flowlogs"
+
+// Registry holds the indexing metrics only, so the textfile written in batch mode does not carry the go runtime
+// metrics of a process that exited.
+var Registry = prometheus.NewRegistry()
+
+// gatherer reads the Registry, labelled with the profile once SetProfile is called.
+var gatherer prometheus.Gatherer = Registry
+
+var (
+	// Objects counts the objects read from the source bucket.
+	Objects = newCounter("objects_total", "Number of flow log objects read from the source bucket.")
+	// EmptyObjects counts the objects holding no flow.
+	EmptyObjects = newCounter("empty_objects_total", "Number of flow log objects holding no flow.")
+	// ArchivedObjects counts the objects moved to the indexed bucket.
+	ArchivedObjects = newCounter("archived_objects_total", "Number of flow log objects moved to the indexed bucket.")
+	// DocumentsFlushed counts the documents indexed.
+	DocumentsFlushed = newCounter("documents_flushed_total", "Number of documents indexed.")
+	// DocumentsFailed counts the documents elasticsearch rejected.
+	DocumentsFailed = newCounter("documents_failed_total", "Number of documents that failed to index.")
+	// DeadLetters counts the objects left in the source bucket, by stage.
+	DeadLetters = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dead_letters_total",
+		Help:      "Number of objects left in the source bucket, by stage: get, index or archive.",
+	}, []string{"stage"})
+
+	// GetDuration observes the time to read an object from cos.
+	GetDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "cos_get_duration_seconds",
+		Help:      "Time to read a flow log object from the source bucket.",
+		Buckets:   prometheus.DefBuckets,
+	})
+	// FlushDuration observes the time of the bulk requests.
+	FlushDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "bulk_flush_duration_seconds",
+		Help:      "Time to flush a bulk request to elasticsearch.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	})
+
+	// QueueDepth is the number of documents added to the bulk indexer and not yet flushed.
+	QueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "bulk_queue_depth",
+		Help:      "Number of documents waiting in the bulk indexer.",
+	})
+	// LastRunTimestamp is the time the last indexing run ended.
+	LastRunTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_run_timestamp_seconds",
+		Help:      "Unix time the last indexing run ended.",
+	})
+	// LastRunDuration is the duration of the last indexing run.
+	LastRunDuration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_run_duration_seconds",
+		Help:      "Duration of the last indexing run.",
+	})
+	// LastRunFailed is 1 when the last indexing run failed, 0 otherwise.
+	LastRunFailed = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_run_failed",
+		Help:      "1 when the last indexing run failed, 0 otherwise.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(Objects, EmptyObjects, ArchivedObjects, DocumentsFlushed, DocumentsFailed, DeadLetters,
+		GetDuration, FlushDuration, QueueDepth, LastRunTimestamp, LastRunDuration, LastRunFailed)
+}
+
+func newCounter(name string, help string) prometheus.Counter {
+	return prometheus.NewCounter(prometheus.CounterOpts{Namespace: namespace, Name: name, Help: help})
+}
+
+// Handler serves the indexing metrics along with the go runtime and process metrics.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(prometheus.Gatherers{gatherer, prometheus.DefaultGatherer}, promhttp.HandlerOpts{})
+}
+
+// Serve exposes /metrics on listen in the background, an empty listen disables it.
+func Serve(listen string) {
+	if listen == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	go func() {
+		logger.SystemLogger.Info("Serving the metrics.", zap.String("listen", listen), zap.String("path", "/metrics"))
+		if err := http.ListenAndServe(listen, mux); err != nil {
+			logger.ErrorLogger.Error("Error serving the metrics.", zap.Error(err))
+		}
+	}()
+}
+
+// WriteTextfile writes the indexing metrics for the node exporter textfile collector, an empty path disables it.
+func WriteTextfile(path string) error {
+	if path == "" {
+		return nil
+	}
+	if err := prometheus.WriteToTextfile(path, gatherer); err != nil {
+		return fmt.Errorf("prometheus.WriteToTextfile: %v", err)
+	}
+	return nil
+}
+
+// SetProfile adds a profile label to the indexing metrics, so the textfiles written by each profile can be read by the
+// same collector.
+func SetProfile(name string) {
+	gatherer = profileGatherer{name: name}
+}
+
+type profileGatherer struct {
+	name string
+}
+
+func (g profileGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := Registry.Gather()
+	for _, family := range families {
+		for _, m := range family.Metric {
+			m.Label = append(m.Label, &dto.LabelPair{Name: proto.String("profile"), Value: proto.String(g.name)})
+		}
+	}
+	return families, err
+}
@@ -0,0 +1,201 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/config"
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"go.uber.org/zap"
+)
+
+// Event types
+const (
+	EventAlert      = "alert"
+	EventIndexRun   = "index_run"
+	EventDeadLetter = "dead_letter"
+	EventTest       = "test"
+)
+
+// Event is what gets notified, Data holds the payload specific to the type of event.
+type Event struct {
+	Type      string      `json:"type"`
+	Timestamp string      `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// NewEvent returns an event of the given type timestamped now.
+func NewEvent(eventType string, data interface{}) Event {
+	return Event{Type: eventType, Timestamp: time.Now().UTC().Format(time.RFC3339), Data: data}
+}
+
+// Notifier delivers events outside of the tool.
+type Notifier interface {
+	Name() string
+	Notify(events []Event) error
+}
+
+var (
+	mu       sync.Mutex
+	registry = map[string]Notifier{}
+	// subscriptions lists the notifiers receiving each type of event published by the indexer.
+	subscriptions = map[string][]string{}
+)
+
+func init() {
+	Register(&stdoutNotifier{})
+	Register(&logNotifier{})
+}
+
+// Register makes a notifier available under its name, a notifier registered with the same name replaces it.
+func Register(n Notifier) {
+	mu.Lock()
+	defer mu.Unlock()
+	registry[n.Name()] = n
+}
+
+// Lookup returns the notifier registered under name.
+func Lookup(name string) (Notifier, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	n, ok := registry[name]
+	if !ok {
+		var names []string
+		for name := range registry {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown notifier %s, expecting one of %s", name, strings.Join(names, ", "))
+	}
+	return n, nil
+}
+
+// NotifyAll sends the events to each named notifier, a failing notifier does not prevent the others from being called.
+func NotifyAll(names []string, events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	var failed []string
+	for _, name := range names {
+		n, err := Lookup(name)
+		if err == nil {
+			err = n.Notify(events)
+		}
+		if err != nil {
+			logger.ErrorLogger.Error("Error notifying events", zap.String("notifier", name), zap.Error(err))
+			failed = append(failed, name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("notifiers failed: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// Publish sends the events to the notifiers subscribed to their type in the configuration, alerts are not published
+// this way but sent to the notifiers named by each rule.
+func Publish(events []Event) {
+	byType := map[string][]Event{}
+	for _, event := range events {
+		byType[event.Type] = append(byType[event.Type], event)
+	}
+
+	for eventType, events := range byType {
+		mu.Lock()
+		names := subscriptions[eventType]
+		mu.Unlock()
+		NotifyAll(names, events)
+	}
+}
+
+// InitNotifiers registers the notifiers defined under notifiers in the configuration, keyed by their name, i.e.
+//
+//	"notifiers": {
+//	  "ops": {
+//	    "type": "webhook",
+//	    "url": "https://hooks.example.com/flowlogs",
+//	    "secret": "<provide_value>",
+//	    "events": ["index_run", "dead_letter"]
+//	  }
+//	}
+func InitNotifiers() error {
+	cfg := config.Load()
+	if err := config.Error(cfg.ValidateNotifiers()); err != nil {
+		return err
+	}
+
+	for _, n := range cfg.Notifiers {
+		w, err := newConfiguredWebhook(n)
+		if err != nil {
+			return fmt.Errorf("notifier %s: %v", n.Name, err)
+		}
+
+		Register(w)
+
+		mu.Lock()
+		for _, eventType := range n.Events {
+			subscriptions[eventType] = append(subscriptions[eventType], n.Name)
+		}
+		mu.Unlock()
+	}
+	return nil
+}
+
+// stdoutNotifier prints each event as a JSON line.
+type stdoutNotifier struct{}
+
+func (n *stdoutNotifier) Name() string {
+	return "stdout"
+}
+
+func (n *stdoutNotifier) Notify(events []Event) error {
+	for _, event := range events {
+		b, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("json.Marshal: %v", err)
+		}
+		fmt.Fprintln(os.Stdout, string(b))
+	}
+	return nil
+}
+
+// logNotifier writes each event to the system log.
+type logNotifier struct{}
+
+func (n *logNotifier) Name() string {
+	return "log"
+}
+
+func (n *logNotifier) Notify(events []Event) error {
+	for _, event := range events {
+		b, err := json.Marshal(event.Data)
+		if err != nil {
+			return fmt.Errorf("json.Marshal: %v", err)
+		}
+		logger.SystemLogger.Warn("Notified event.", zap.String("type", event.Type), zap.String("timestamp", event.Timestamp), zap.ByteString("data", b))
+	}
+	return nil
+}
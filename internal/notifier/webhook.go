@@ -0,0 +1,212 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package notifier
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/config"
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"go.uber.org/zap"
+)
+
+// Headers set on each webhook request. The signature is the hex encoded HMAC-SHA256 of the timestamp header value,
+// a dot and the request body, computed with the webhook secret, so a receiver can verify the sender and reject replays.
+const (
+	SignatureHeader = "X-Flowlogs-Signature"
+	TimestampHeader = "X-Flowlogs-Timestamp"
+	EventHeader     = "X-Flowlogs-Event"
+)
+
+// Webhook posts each event as JSON to a URL.
+type Webhook struct {
+	name string
+
+	// URL receives the POST requests.
+	URL string
+	// Secret signs the requests when set.
+	Secret string
+	// Template renders the request body from the event, the event is marshalled as is when nil.
+	Template *template.Template
+	// Headers are added to each request.
+	Headers map[string]string
+	// MaxRetries is the number of times a request is sent again after a network error, a 429 or a 5xx response.
+	MaxRetries int
+	// Backoff is the wait before the first retry, it doubles with each retry.
+	Backoff time.Duration
+	// Client sends the requests.
+	Client *http.Client
+}
+
+// NewWebhook returns a webhook notifier registered under name posting to url, with 3 retries and a 10 seconds timeout.
+func NewWebhook(name string, url string) *Webhook {
+	return &Webhook{
+		name:       name,
+		URL:        url,
+		Headers:    map[string]string{},
+		MaxRetries: 3,
+		Backoff:    time.Second,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// newConfiguredWebhook returns the webhook of a notifier of the configuration, validated by config.ValidateNotifiers.
+func newConfiguredWebhook(n config.Notifier) (*Webhook, error) {
+	w := NewWebhook(n.Name, n.URL)
+	w.Secret = n.Secret
+	w.Headers = n.Headers
+	w.MaxRetries = n.MaxRetries
+	w.Backoff = n.Backoff
+	w.Client.Timeout = n.Timeout
+
+	text := n.Template
+	if n.TemplateFile != "" {
+		b, err := ioutil.ReadFile(n.TemplateFile)
+		if err != nil {
+			return nil, fmt.Errorf("ioutil.ReadFile: %v", err)
+		}
+		text = string(b)
+	}
+	if text != "" {
+		if err := w.ParseTemplate(text); err != nil {
+			return nil, err
+		}
+	}
+
+	return w, nil
+}
+
+// ParseTemplate sets the template rendering the request body, the template can use the json function to marshal a value.
+func (w *Webhook) ParseTemplate(text string) error {
+	t, err := template.New(w.name).Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+	if err != nil {
+		return fmt.Errorf("template.Parse: %v", err)
+	}
+	w.Template = t
+	return nil
+}
+
+// Name returns the name the webhook is registered under.
+func (w *Webhook) Name() string {
+	return w.name
+}
+
+// Notify posts each event, it returns the last error after trying every event.
+func (w *Webhook) Notify(events []Event) error {
+	var lastErr error
+	for _, event := range events {
+		if err := w.post(event); err != nil {
+			logger.ErrorLogger.Error("Error posting to webhook", zap.String("notifier", w.name), zap.String("event", event.Type), zap.Error(err))
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (w *Webhook) post(event Event) error {
+	body, err := w.render(event)
+	if err != nil {
+		return err
+	}
+
+	backoff := w.Backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := w.send(event, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= w.MaxRetries {
+			return err
+		}
+
+		logger.SystemLogger.Debug("Retrying webhook.", zap.String("notifier", w.name), zap.Duration("backoff", backoff), zap.Error(err))
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (w *Webhook) render(event Event) ([]byte, error) {
+	if w.Template == nil {
+		b, err := json.Marshal(event)
+		if err != nil {
+			return nil, fmt.Errorf("json.Marshal: %v", err)
+		}
+		return b, nil
+	}
+
+	var buf bytes.Buffer
+	if err := w.Template.Execute(&buf, event); err != nil {
+		return nil, fmt.Errorf("template.Execute: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// send posts the body once and reports whether a failure is worth retrying.
+func (w *Webhook) send(event Event, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("http.NewRequest: %v", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, event.Type)
+	req.Header.Set(TimestampHeader, timestamp)
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	if w.Secret != "" {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(w.Secret, timestamp, body))
+	}
+
+	res, err := w.Client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("http.Client.Do: %v", err)
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+	retry := res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+	return retry, fmt.Errorf("%s responded %s", w.URL, res.Status)
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the timestamp and body, as sent in the signature header.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
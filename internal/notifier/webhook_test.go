@@ -0,0 +1,137 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package notifier
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	logger.SystemLogger = zap.NewNop()
+	logger.ErrorLogger = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+func TestWebhookSignature(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		received <- r
+	}))
+	defer server.Close()
+
+	w := NewWebhook("test", server.URL)
+	w.Secret = "s3cret"
+	if err := w.Notify([]Event{NewEvent(EventTest, map[string]string{"message": "hello"})}); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+
+	r := <-received
+	if got := r.Header.Get(EventHeader); got != EventTest {
+		t.Errorf("%s = %q, want %q", EventHeader, got, EventTest)
+	}
+	if got := r.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	timestamp := r.Header.Get(TimestampHeader)
+	if timestamp == "" {
+		t.Fatalf("%s not set", TimestampHeader)
+	}
+	if got, want := r.Header.Get(SignatureHeader), "sha256="+Sign("s3cret", timestamp, body); got != want {
+		t.Errorf("%s = %q, want %q", SignatureHeader, got, want)
+	}
+}
+
+func TestWebhookUnsigned(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(SignatureHeader) != "" {
+			t.Errorf("%s set without a secret", SignatureHeader)
+		}
+	}))
+	defer server.Close()
+
+	if err := NewWebhook("test", server.URL).Notify([]Event{NewEvent(EventTest, nil)}); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int32
+		wantErr  bool
+	}{
+		{"success", []int{200}, 1, false},
+		{"5xx retried", []int{500, 502, 200}, 3, false},
+		{"429 retried", []int{429, 204}, 2, false},
+		{"4xx not retried", []int{400, 200}, 1, true},
+		{"401 not retried", []int{401, 200}, 1, true},
+		{"gives up after max retries", []int{503, 503, 503, 503}, 3, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&attempts, 1)
+				w.WriteHeader(tt.statuses[n-1])
+			}))
+			defer server.Close()
+
+			w := NewWebhook("test", server.URL)
+			w.MaxRetries = 2
+			w.Backoff = time.Millisecond
+
+			err := w.Notify([]Event{NewEvent(EventTest, nil)})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Notify error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := atomic.LoadInt32(&attempts); got != tt.attempts {
+				t.Errorf("attempts = %d, want %d", got, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	w := NewWebhook("test", server.URL)
+	if err := w.ParseTemplate(`{"text": "{{.Type}}", "data": {{json .Data}}}`); err != nil {
+		t.Fatalf("ParseTemplate: %v", err)
+	}
+	if err := w.Notify([]Event{NewEvent(EventTest, map[string]int{"n": 1})}); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if want := `{"text": "test", "data": {"n":1}}`; string(body) != want {
+		t.Errorf("body = %s, want %s", body, want)
+	}
+}
@@ -0,0 +1,166 @@
+/*
+Copyright © 2020 Dimitri Prosper <dimitri_prosper@us.ibm.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/config"
+	"github.com/dprosper/vpc-flowlogs-elasticsearch/internal/logger"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/stdout"
+	"go.opentelemetry.io/otel/label"
+	exporttrace "go.opentelemetry.io/otel/sdk/export/trace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	// ExporterOTLP sends the spans to an OpenTelemetry collector over grpc.
+	ExporterOTLP = config.TracingOTLP
+	// ExporterFile appends the spans as json to a local file.
+	ExporterFile = config.TracingFile
+
+	tracerName         = "github.com/dprosper/vpc-flowlogs-elasticsearch"
+	defaultServiceName = "vpc-flowlogs-elasticsearch"
+	defaultEndpoint    = "localhost:4317"
+)
+
+// Common span attributes.
+var (
+	Bucket     = label.Key("cos.bucket")
+	Key        = label.Key("cos.key")
+	Page       = label.Key("cos.page")
+	Objects    = label.Key("cos.objects")
+	DocumentID = label.Key("flowlogs.document_id")
+	Documents  = label.Key("flowlogs.documents")
+	Status     = label.Key("flowlogs.status")
+	Index      = label.Key("elasticsearch.index")
+)
+
+// Init configures the tracer provider from the tracing block of the configuration, i.e.
+//
+//	"tracing": {"exporter": "otlp", "endpoint": "localhost:4317", "insecure": true, "headers": {}}
+//	"tracing": {"exporter": "file", "file": "logs/traces.json"}
+//
+// The returned function flushes the spans and must be called before exiting. Without exporter the spans are dropped.
+func Init(cfg config.Tracing) (func(), error) {
+	if err := config.Error(cfg.Validate()); err != nil {
+		return nil, err
+	}
+
+	var exporter exporttrace.SpanExporter
+	var closers []func() error
+
+	switch cfg.Exporter {
+	case "":
+		return func() {}, nil
+	case ExporterOTLP:
+		endpoint := cfg.Endpoint
+		if endpoint == "" {
+			endpoint = defaultEndpoint
+		}
+		options := []otlp.ExporterOption{otlp.WithAddress(endpoint), otlp.WithHeaders(cfg.Headers)}
+		if cfg.Insecure {
+			options = append(options, otlp.WithInsecure())
+		}
+		otlpExporter, err := otlp.NewExporter(context.Background(), options...)
+		if err != nil {
+			return nil, fmt.Errorf("otlp.NewExporter: %v", err)
+		}
+		exporter = otlpExporter
+	case ExporterFile:
+		path := cfg.File
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, fmt.Errorf("os.MkdirAll: %v", err)
+		}
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("os.OpenFile: %v", err)
+		}
+		closers = append(closers, file.Close)
+		stdoutExporter, err := stdout.NewExporter(stdout.WithWriter(file), stdout.WithoutMetricExport())
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("stdout.NewExporter: %v", err)
+		}
+		exporter = stdoutExporter
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.ServiceNameKey.String(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetErrorHandler(errorHandler{})
+
+	return func() {
+		if err := provider.Shutdown(context.Background()); err != nil {
+			logger.ErrorLogger.Error("Error flushing the spans.", zap.Error(err))
+		}
+		for _, closer := range closers {
+			closer()
+		}
+	}, nil
+}
+
+// errorHandler logs the errors of the exporters, the sdk also reports the nil result of each shutdown.
+type errorHandler struct{}
+
+func (errorHandler) Handle(err error) {
+	if err != nil {
+		logger.ErrorLogger.Error("Error exporting the spans.", zap.Error(err))
+	}
+}
+
+// Tracer returns the tracer of the tool, a no-op tracer until Init configured an exporter.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Start starts a span of the tool with the attributes.
+func Start(ctx context.Context, name string, attributes ...label.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// AddEvent adds an event with the attributes to the span of the context.
+func AddEvent(ctx context.Context, name string, attributes ...label.KeyValue) {
+	trace.SpanFromContext(ctx).AddEvent(name, trace.WithAttributes(attributes...))
+}
+
+// End ends the span of the context.
+func End(ctx context.Context) {
+	trace.SpanFromContext(ctx).End()
+}
+
+// Fail records the error on the span and marks it failed.
+func Fail(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}